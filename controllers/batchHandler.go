@@ -0,0 +1,240 @@
+/*
+Copyright Pascal Limeux. 2017 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/pascallimeux/ocms2/model"
+	"github.com/pascallimeux/ocms2/modules/common"
+	"github.com/pascallimeux/ocms2/modules/log"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	DEFAULT_BATCH_WORKERS = 8
+	MAX_BATCH_SIZE        = 1000
+	MAX_BATCH_WRITES      = 10000
+	NDJSON_CONTENT_TYPE   = "application/x-ndjson"
+)
+
+//HTTP Post - /ocms/v2/consents/check
+func (a *AppContext) postConsentChecks(w http.ResponseWriter, r *http.Request) {
+	log.Trace(log.Here(), "postConsentChecks() : calling method -")
+
+	r, err1 := a.authorizeCaller(w, r)
+	if err1 != nil {
+		return
+	}
+
+	var consents []model.Consent
+	err := json.NewDecoder(r.Body).Decode(&consents)
+	if err != nil {
+		common.SendError(log.Here(), w, common.NewValidationError("body is not an array of consents: "+err.Error()))
+		return
+	}
+	if len(consents) > MAX_BATCH_SIZE {
+		common.SendError(log.Here(), w, common.NewValidationError("more than "+strconv.Itoa(MAX_BATCH_SIZE)+" consents in the batch"))
+		return
+	}
+	applicationID := a.getApplicationID(r, "")
+	decisions := make([]model.ConsentDecision, len(consents))
+	a.runBatch(len(consents), func(i int) {
+		consents[i].Appid = applicationID
+		decisions[i] = a.checkTuple(r, consents[i])
+	})
+	bytes, _ := json.Marshal(decisions)
+	sendBytes(w, http.StatusOK, bytes)
+}
+
+//HTTP Post - /ocms/v2/consents/batch
+func (a *AppContext) postConsents(w http.ResponseWriter, r *http.Request) {
+	log.Trace(log.Here(), "postConsents() : calling method -")
+
+	r, err1 := a.authorizeCaller(w, r)
+	if err1 != nil {
+		return
+	}
+
+	consents, err := readBatch(r)
+	if err != nil {
+		common.SendError(log.Here(), w, err)
+		return
+	}
+	results := make([]model.BatchResult, len(consents))
+	a.runBatch(len(consents), func(i int) {
+		consents[i].Appid = a.getApplicationID(r, consents[i].Appid)
+		consent, err := a.submitConsent(r, consents[i])
+		results[i] = batchResult(r, i, consent.Consentid, consent.Consentid, http.StatusCreated, err)
+	})
+	sendBatchResponse(w, results)
+}
+
+//HTTP Post - /ocms/v2/consents/batch/revoke
+func (a *AppContext) revokeConsents(w http.ResponseWriter, r *http.Request) {
+	log.Trace(log.Here(), "revokeConsents() : calling method -")
+
+	r, err1 := a.authorizeCaller(w, r)
+	if err1 != nil {
+		return
+	}
+
+	consents, err := readBatch(r)
+	if err != nil {
+		common.SendError(log.Here(), w, err)
+		return
+	}
+	applicationID := a.getApplicationID(r, "")
+	results := make([]model.BatchResult, len(consents))
+	a.runBatch(len(consents), func(i int) {
+		if consents[i].Consentid == "" {
+			results[i] = batchResult(r, i, "", "", http.StatusOK, common.NewValidationError("consentID is mandatory!"))
+			return
+		}
+		_, tr_uuid, err := a.revokeConsent(r, applicationID, consents[i].Consentid)
+		results[i] = batchResult(r, i, consents[i].Consentid, tr_uuid, http.StatusOK, err)
+	})
+	sendBatchResponse(w, results)
+}
+
+// Read a JSON array of consents or a stream of JSON consents, one per line (NDJSON)
+func readBatch(r *http.Request) ([]model.Consent, error) {
+	log.Trace(log.Here(), "readBatch() : calling method -")
+	consents := make([]model.Consent, 0)
+	reader := bufio.NewReader(r.Body)
+	first, err := firstByte(reader)
+	if err == io.EOF {
+		return consents, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(reader)
+	if first == '[' && !strings.HasPrefix(r.Header.Get("Content-Type"), NDJSON_CONTENT_TYPE) {
+		err = decoder.Decode(&consents)
+		if err != nil {
+			return nil, common.NewValidationError("body is not an array of consents: " + err.Error())
+		}
+	} else {
+		for {
+			consent := model.Consent{}
+			err = decoder.Decode(&consent)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, common.NewValidationError("line " + strconv.Itoa(len(consents)+1) + " is not a consent: " + err.Error())
+			}
+			consents = append(consents, consent)
+			if len(consents) > MAX_BATCH_WRITES {
+				break
+			}
+		}
+	}
+	if len(consents) > MAX_BATCH_WRITES {
+		return nil, common.NewValidationError("more than " + strconv.Itoa(MAX_BATCH_WRITES) + " consents in the batch")
+	}
+	return consents, nil
+}
+
+func firstByte(reader *bufio.Reader) (byte, error) {
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if b != ' ' && b != '\t' && b != '\r' && b != '\n' {
+			return b, reader.UnreadByte()
+		}
+	}
+}
+
+func batchResult(r *http.Request, index int, consentID, tr_uuid string, status int, err error) model.BatchResult {
+	if err != nil {
+		httpError := itemError(r, err)
+		return model.BatchResult{Index: index, Consentid: consentID, Status: httpError.Status, Code: httpError.Code, Error: httpError.Message}
+	}
+	return model.BatchResult{Index: index, Consentid: consentID, Txuuid: tr_uuid, Status: status}
+}
+
+// Error of a batch item, the detail of an internal error is logged with the request id as the client only gets its code
+func itemError(r *http.Request, err error) *common.HttpError {
+	httpError := common.ToHttpError(err)
+	if httpError.Code == common.INTERNAL_ERROR {
+		log.Error(log.Here(), "batch item: ", err.Error(), " request: ", r.Header.Get(common.REQUESTIDHEADER))
+	}
+	return httpError
+}
+
+// The batch is answered 200 whatever the outcome of its items
+func sendBatchResponse(w http.ResponseWriter, results []model.BatchResult) {
+	response := model.BatchResponse{Results: results}
+	for _, result := range results {
+		if result.Code == "" {
+			response.Succeeded++
+		} else {
+			response.Failed++
+		}
+	}
+	bytes, _ := json.Marshal(response)
+	sendBytes(w, http.StatusOK, bytes)
+}
+
+// Evaluate one tuple of a batch check, the error is reported in the decision
+func (a *AppContext) checkTuple(r *http.Request, consent model.Consent) model.ConsentDecision {
+	message := fmt.Sprintf("checkTuple(consent=%s) : calling method -", consent.Print())
+	log.Trace(log.Here(), message)
+	decision := model.ConsentDecision{Ownerid: consent.Ownerid, Consumerid: consent.Consumerid, Datatype: consent.Datatype, Dataaccess: consent.Dataaccess, At: consent.At, Consent: "False"}
+	isconsent, consentID, err := a.decideConsent(r, consent)
+	if err != nil {
+		httpError := itemError(r, err)
+		decision.Code = httpError.Code
+		decision.Error = httpError.Message
+		return decision
+	}
+	if isconsent {
+		decision.Consent = "True"
+		decision.Consentid = consentID
+	}
+	return decision
+}
+
+// Run the n jobs of a batch with at most BatchWorkers of them at the same time
+func (a *AppContext) runBatch(n int, job func(i int)) {
+	workers := a.Configuration.BatchWorkers
+	if workers <= 0 {
+		workers = DEFAULT_BATCH_WORKERS
+	}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				job(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+}
@@ -15,9 +15,7 @@ package controllers
 
 import (
 	//"bytes"
-	b64 "encoding/base64"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"github.com/gorilla/mux"
 	"github.com/pascallimeux/ocms2/hyperledger"
@@ -25,7 +23,6 @@ import (
 	"github.com/pascallimeux/ocms2/modules/common"
 	"github.com/pascallimeux/ocms2/modules/log"
 	"net/http"
-	"strconv"
 	"time"
 )
 
@@ -33,7 +30,7 @@ import (
 func (a *AppContext) processConsent(w http.ResponseWriter, r *http.Request) {
 	log.Trace(log.Here(), "processConsent() : calling method -")
 
-	err1 := a.AuthContext.CheckPermissionFromToken(w, r, "processConsent", "")
+	r, err1 := a.authorizeCaller(w, r)
 	if err1 != nil {
 		return
 	}
@@ -45,24 +42,36 @@ func (a *AppContext) processConsent(w http.ResponseWriter, r *http.Request) {
 		common.SendError(log.Here(), w, err)
 		return
 	}
+	consent.Appid = a.getApplicationID(r, consent.Appid)
+	r = withCommitWait(r, consent.Wait)
 	switch action := consent.Action; action {
 	case "create":
-		bytes, err = a.createConsent(consent)
+		bytes, err = a.createConsent(r, consent)
+	case "request":
+		bytes, err = a.requestConsent(r, consent)
+	case "approve":
+		bytes, err = a.answerConsentRequest(r, consent.Appid, consent.Consentid, hyperledger.STATE_ACTIVE)
+	case "reject":
+		bytes, err = a.answerConsentRequest(r, consent.Appid, consent.Consentid, hyperledger.STATE_REJECTED)
 	case "list":
-		bytes, err = a.listConsents(consent.Appid)
+		bytes, err = a.listConsents(r, consent.Appid, consent)
 	case "get":
-		bytes, err = a.getConsent(consent.Appid, consent.Consentid)
-	case "remove":
-		bytes, err = a.unactivateConsent(consent.Appid, consent.Consentid)
+		bytes, err = a.getConsent(r, consent.Appid, consent.Consentid)
+	case "remove", "revoke":
+		bytes, err = a.unactivateConsent(r, consent.Appid, consent.Consentid)
+	case "suspend":
+		bytes, err = a.changeConsentState(r, consent.Appid, consent.Consentid, hyperledger.STATE_SUSPENDED)
+	case "resume":
+		bytes, err = a.changeConsentState(r, consent.Appid, consent.Consentid, hyperledger.STATE_ACTIVE)
 	case "list4owner":
-		bytes, err = a.getConsents4Owner(consent.Appid, consent.Ownerid)
+		bytes, err = a.getConsents4Owner(r, consent.Appid, consent.Ownerid, consent)
 	case "list4consumer":
-		bytes, err = a.getConsents4Consumer(consent.Appid, consent.Consumerid)
+		bytes, err = a.getConsents4Consumer(r, consent.Appid, consent.Consumerid, consent)
 	case "isconsent":
-		bytes, err = a.isConsent(consent)
+		bytes, err = a.isConsent(r, consent)
 	default:
 		log.Error(log.Here(), "bad action request")
-		common.SendError(log.Here(), w, err)
+		common.SendError(log.Here(), w, common.NewValidationError("unknown action: "+action))
 		return
 	}
 	if err != nil {
@@ -81,15 +90,15 @@ func (a *AppContext) processConsentTR(w http.ResponseWriter, r *http.Request) {
 	message := fmt.Sprintf("processConsentTR(tr_uuid=%s) : calling method -", tr_uuid)
 	log.Info(log.Here(), message)
 	var bytes []byte
-	transaction, err := a.Consent_helper.HP_helper.GetTransaction(tr_uuid)
-	if transaction.Txid == "" {
-		common.SendError(log.Here(), w, errors.New("This is not a consent creation transaction"))
-		return
-	}
+	transaction, err := a.Consent_helper.GetTransaction(tr_uuid)
 	if err != nil {
 		common.SendError(log.Here(), w, err)
 		return
 	}
+	if transaction.Txid == "" {
+		common.SendError(log.Here(), w, common.NewNotFoundError("transaction "+tr_uuid+" not found"))
+		return
+	}
 	bytes, err1 := buildDecodedConsent(transaction)
 	if err1 != nil {
 		common.SendError(log.Here(), w, err1)
@@ -100,7 +109,16 @@ func (a *AppContext) processConsentTR(w http.ResponseWriter, r *http.Request) {
 	w.Write(bytes)
 }
 
-func (a *AppContext) createConsent(consent model.Consent) ([]byte, error) {
+func (a *AppContext) createConsent(r *http.Request, consent model.Consent) ([]byte, error) {
+	consent, err := a.submitConsent(r, consent)
+	if err != nil {
+		return nil, err
+	}
+	return consent2Bytes(consent)
+}
+
+// Validate and write a consent on the ledger, its ID is the ID of the creation transaction
+func (a *AppContext) submitConsent(r *http.Request, consent model.Consent) (model.Consent, error) {
 	err := check_args(&consent)
 	var message string
 	if err != nil {
@@ -109,78 +127,310 @@ func (a *AppContext) createConsent(consent model.Consent) ([]byte, error) {
 		message = fmt.Sprintf("createConsent(%s) : calling method -", consent.Print())
 	}
 	log.Info(log.Here(), message)
+	if err != nil {
+		return consent, err
+	}
+	err = a.checkConsentPermission(r, consent.Appid, "createConsent", consent.Ownerid)
+	if err != nil {
+		return consent, err
+	}
+	err = a.checkDirectCreation(r, consent.Ownerid)
+	if err != nil {
+		return consent, err
+	}
+	err = a.checkVocabulary(consent.Appid, consent.Datatype, consent.Dataaccess)
+	if err != nil {
+		return consent, err
+	}
+	consentID, err := a.Consent_helper.CreateConsent(consent.Appid, consent.Ownerid, consent.Consumerid, consent.Datatype, consent.Dataaccess, consent.Dt_begin, consent.Dt_end)
+	if err != nil {
+		return consent, err
+	}
+	consent.Consentid = consentID
+	consent.State = hyperledger.STATE_ACTIVE
+	// The invoke is submitted, its event is sent even when the commit wait times out
+	err = a.trackTransaction(r, consent.Appid, consentID, consentID, "PostConsent")
+	a.publishConsentEvent(EVENT_CONSENT_CREATED, consent, consentID)
+	return consent, err
+}
+
+func (a *AppContext) requestConsent(r *http.Request, consent model.Consent) ([]byte, error) {
+	err := check_args(&consent)
 	if err != nil {
 		return nil, err
 	}
-	consentID, err := a.Consent_helper.CreateConsent(a.Configuration.ApplicationID, consent.Ownerid, consent.Consumerid, consent.Datatype, consent.Dataaccess, consent.Dt_begin, consent.Dt_end)
+	message := fmt.Sprintf("requestConsent(%s) : calling method -", consent.Print())
+	log.Info(log.Here(), message)
+	err = a.checkConsentPermission(r, consent.Appid, "requestConsent", consent.Consumerid)
+	if err != nil {
+		return nil, err
+	}
+	err = a.checkVocabulary(consent.Appid, consent.Datatype, consent.Dataaccess)
+	if err != nil {
+		return nil, err
+	}
+	consentID, err := a.Consent_helper.CreateConsentRequest(consent.Appid, consent.Ownerid, consent.Consumerid, consent.Datatype, consent.Dataaccess, consent.Dt_begin, consent.Dt_end)
 	if err != nil {
 		return nil, err
 	}
 	consent.Consentid = consentID
+	consent.State = hyperledger.STATE_PENDING
+	err = a.trackTransaction(r, consent.Appid, consentID, consentID, "PostConsentRequest")
+	a.publishConsentEvent(EVENT_CONSENT_CREATED, consent, consentID)
+	if err != nil {
+		return nil, err
+	}
 	return consent2Bytes(consent)
 }
 
-func (a *AppContext) listConsents(applicationID string) ([]byte, error) {
+// Only the consent owner can approve (state active) or reject a pending consent
+func (a *AppContext) answerConsentRequest(r *http.Request, applicationID, consentID, state string) ([]byte, error) {
+	message := fmt.Sprintf("answerConsentRequest(applicationID=%s, consentID=%s, state=%s) : calling method -", applicationID, consentID, state)
+	log.Info(log.Here(), message)
+	consent, err := a.Consent_helper.GetConsent(applicationID, consentID)
+	if err != nil {
+		return nil, err
+	}
+	err = a.checkConsentPermission(r, applicationID, "approveConsent", consent.OwnerID)
+	if err != nil {
+		return nil, err
+	}
+	if hyperledger.NormalizeState(consent.State) != hyperledger.STATE_PENDING {
+		return nil, common.NewConflictError("consent " + consentID + " is not pending")
+	}
+	return a.setConsentState(r, consent, state)
+}
+
+// The listing filters, sort and page are read from the request consent
+func (a *AppContext) listConsents(r *http.Request, applicationID string, request model.Consent) ([]byte, error) {
 	message := fmt.Sprintf("listConsents(applicationID=%s) : calling method -", applicationID)
 	log.Info(log.Here(), message)
-	consents, err := a.Consent_helper.GetActivesConsents(a.Configuration.ApplicationID)
+	err := a.checkConsentPermission(r, applicationID, "listConsents")
 	if err != nil {
 		return nil, err
 	}
-	return HPconsents2ConsentsBytes(consents)
+	options, err := buildListOptions(request)
+	if err != nil {
+		return nil, err
+	}
+	return a.listConsentPage(applicationID, "", "", options)
 }
 
-func (a *AppContext) getConsent(applicationID, consentID string) ([]byte, error) {
+func (a *AppContext) getConsent(r *http.Request, applicationID, consentID string) ([]byte, error) {
 	message := fmt.Sprintf("getConsent(applicationID=%s, consentID=%s) : calling method -", applicationID, consentID)
 	log.Info(log.Here(), message)
-	consent, err := a.Consent_helper.GetConsent(a.Configuration.ApplicationID, consentID)
+	consent, err := a.Consent_helper.GetConsent(applicationID, consentID)
+	if err != nil {
+		return nil, err
+	}
+	err = a.checkConsentPermission(r, applicationID, "getConsent", consent.OwnerID, consent.ConsumerID)
 	if err != nil {
 		return nil, err
 	}
 	return HPconsent2ConsentBytes(consent)
 }
 
-func (a *AppContext) unactivateConsent(applicationID, consentID string) ([]byte, error) {
+func (a *AppContext) unactivateConsent(r *http.Request, applicationID, consentID string) ([]byte, error) {
+	consent, _, err := a.revokeConsent(r, applicationID, consentID)
+	if err != nil {
+		return nil, err
+	}
+	return HPconsent2ConsentBytes(consent)
+}
+
+// Only the owner revokes a consent, returns the revoked consent and the transaction ID
+func (a *AppContext) revokeConsent(r *http.Request, applicationID, consentID string) (hyperledger.Consent, string, error) {
 	message := fmt.Sprintf("unactivateConsent(applicationID=%s, consentID=%s) : calling method -", applicationID, consentID)
 	log.Info(log.Here(), message)
-	_, err := a.Consent_helper.UnactivateConsent(a.Configuration.ApplicationID, consentID)
+	consent, err := a.Consent_helper.GetConsent(applicationID, consentID)
+	if err != nil {
+		return consent, "", err
+	}
+	err = a.checkConsentPermission(r, applicationID, "revokeConsent", consent.OwnerID)
+	if err != nil {
+		return consent, "", err
+	}
+	err = checkTransition(consent, hyperledger.STATE_REVOKED)
+	if err != nil {
+		return consent, "", err
+	}
+	response, err := a.Consent_helper.UnactivateConsent(applicationID, consentID)
+	if err != nil {
+		return consent, "", err
+	}
+	if !response.IsOK() {
+		return consent, "", common.NewLedgerError(response.GetError())
+	}
+	tr_uuid := response.GetMessage()
+	consent.State = hyperledger.STATE_REVOKED
+	consent.Transitions = append(consent.Transitions, hyperledger.Transition{State: consent.State, Date: time.Now().Format(time.RFC3339)})
+	err = a.trackTransaction(r, applicationID, consentID, tr_uuid, "RemoveConsent")
+	a.publishConsentEvent(EVENT_CONSENT_REVOKED, convertHPConsent2APIConsent(consent), tr_uuid)
+	return consent, tr_uuid, err
+}
+
+func (a *AppContext) changeConsentState(r *http.Request, applicationID, consentID, state string) ([]byte, error) {
+	message := fmt.Sprintf("changeConsentState(applicationID=%s, consentID=%s, state=%s) : calling method -", applicationID, consentID, state)
+	log.Info(log.Here(), message)
+	if state == hyperledger.STATE_REVOKED {
+		return a.unactivateConsent(r, applicationID, consentID)
+	}
+	consent, err := a.Consent_helper.GetConsent(applicationID, consentID)
+	if err != nil {
+		return nil, err
+	}
+	err = a.checkConsentPermission(r, applicationID, "updateConsentState", consent.OwnerID)
+	if err != nil {
+		return nil, err
+	}
+	if hyperledger.NormalizeState(consent.State) == hyperledger.STATE_PENDING {
+		return nil, common.NewConflictError("pending consent " + consentID + " must be approved or rejected by its owner")
+	}
+	return a.setConsentState(r, consent, state)
+}
+
+func (a *AppContext) setConsentState(r *http.Request, consent hyperledger.Consent, state string) ([]byte, error) {
+	log.Trace(log.Here(), "setConsentState() : calling method -")
+	err := checkTransition(consent, state)
+	if err != nil {
+		return nil, err
+	}
+	tr_uuid, err := a.Consent_helper.SetConsentState(consent.AppID, consent.ConsentID, state)
 	if err != nil {
 		return nil, err
 	}
-	consent, err := a.Consent_helper.GetConsent(a.Configuration.ApplicationID, consentID)
+	consent.State = state
+	consent.Transitions = append(consent.Transitions, hyperledger.Transition{State: state, Date: time.Now().Format(time.RFC3339)})
+	err = a.trackTransaction(r, consent.AppID, consent.ConsentID, tr_uuid, "SetConsentState")
+	a.publishConsentEvent(EVENT_CONSENT_STATE_CHANGED, convertHPConsent2APIConsent(consent), tr_uuid)
 	if err != nil {
 		return nil, err
 	}
 	return HPconsent2ConsentBytes(consent)
 }
 
-func (a *AppContext) getConsents4Consumer(applicationID, consumerID string) ([]byte, error) {
+func checkTransition(consent hyperledger.Consent, state string) error {
+	log.Trace(log.Here(), "checkTransition() : calling method -")
+	if !hyperledger.IsState(state) {
+		return common.NewValidationError("unknown state: " + state)
+	}
+	current := consent.EffectiveState(time.Now())
+	if !hyperledger.CanTransition(current, state) {
+		return common.NewConflictError("consent " + consent.ConsentID + " cannot move from " + current + " to " + state)
+	}
+	return nil
+}
+
+// The listing filters, sort and page are read from the request consent
+func (a *AppContext) getConsents4Consumer(r *http.Request, applicationID, consumerID string, request model.Consent) ([]byte, error) {
 	message := fmt.Sprintf("getConsents4Consumer(applicationID=%s, consumerID=%s) : calling method -", applicationID, consumerID)
 	log.Info(log.Here(), message)
-	consents, err := a.Consent_helper.GetConsents4Consumer(a.Configuration.ApplicationID, consumerID)
+	err := a.checkConsentPermission(r, applicationID, "listConsumerConsents", consumerID)
 	if err != nil {
 		return nil, err
 	}
-	return HPconsents2ConsentsBytes(consents)
+	options, err := buildListOptions(request)
+	if err != nil {
+		return nil, err
+	}
+	return a.listConsentPage(applicationID, "", consumerID, options)
 }
 
-func (a *AppContext) getConsents4Owner(applicationID, ownerID string) ([]byte, error) {
+// The listing filters, sort and page are read from the request consent
+func (a *AppContext) getConsents4Owner(r *http.Request, applicationID, ownerID string, request model.Consent) ([]byte, error) {
 	message := fmt.Sprintf("getConsents4Owner(applicationID=%s, ownerID=%s) : calling method -", applicationID, ownerID)
 	log.Info(log.Here(), message)
-	consents, err := a.Consent_helper.GetConsents4Owner(a.Configuration.ApplicationID, ownerID)
+	err := a.checkConsentPermission(r, applicationID, "listOwnerConsents", ownerID)
 	if err != nil {
 		return nil, err
 	}
-	return HPconsents2ConsentsBytes(consents)
+	options, err := buildListOptions(request)
+	if err != nil {
+		return nil, err
+	}
+	return a.listConsentPage(applicationID, ownerID, "", options)
 }
 
-func (a *AppContext) isConsent(consent model.Consent) ([]byte, error) {
+func (a *AppContext) isConsent(r *http.Request, consent model.Consent) ([]byte, error) {
 	message := fmt.Sprintf("isConsent(consent=%s) : calling method -", consent.Print())
 	log.Info(log.Here(), message)
-	isconsent, err := a.Consent_helper.IsConsent(a.Configuration.ApplicationID, consent.Ownerid, consent.Consumerid, consent.Datatype, consent.Dataaccess)
+	isconsent, _, err := a.decideConsent(r, consent)
 	if err != nil {
 		return nil, err
 	}
+	return isConsent2Bytes(isconsent)
+}
+
+// Decision and covering consent for a tuple of a check or a batch check, served by the consent index when there is one.
+// The consents of the owner are evaluated here rather than trusting the IsConsent of the chaincode, which ignores
+// the lifecycle states. A consent on a parent datatype, on All or on all the access modes covers the request.
+func (a *AppContext) decideConsent(r *http.Request, consent model.Consent) (bool, string, error) {
+	if consent.Ownerid == "" || consent.Consumerid == "" {
+		return false, "", common.NewValidationError("owner and consumer are mandatory!")
+	}
+	err := a.checkConsentPermission(r, consent.Appid, "checkConsent", consent.Ownerid, consent.Consumerid)
+	if err != nil {
+		return false, "", err
+	}
+	if consent.Datatype == "" {
+		consent.Datatype = ALL_DATATYPES
+	}
+	if consent.Dataaccess == "" {
+		consent.Dataaccess = ALL_DATAACCESS
+	}
+	err = a.checkVocabulary(consent.Appid, consent.Datatype, consent.Dataaccess)
+	if err != nil {
+		return false, "", err
+	}
+	at := time.Now()
+	if consent.At != "" {
+		at, err = common.DateTimeParse(consent.At)
+		if err != nil {
+			return false, "", common.NewValidationError("at is not a date (2006-01-02) nor a RFC 3339 timestamp: " + consent.At)
+		}
+	}
+	consentID, err1 := a.matchingConsent(consent, at)
+	return consentID != "", consentID, err1
+}
+
+// ID of the first consent of the owner covering the request at the given instant, empty if none.
+// A past instant is checked against all the consents of the application, those since revoked,
+// suspended or expired included, with the state their transitions give at that instant.
+func (a *AppContext) matchingConsent(consent model.Consent, at time.Time) (string, error) {
+	log.Trace(log.Here(), "matchingConsent() : calling method -")
+	consents, err := a.ownerConsentsAt(consent, at)
+	if err != nil {
+		return "", err
+	}
+	datatypes := coveringDatatypes(consent.Datatype)
+	accesses := coveringDataaccess(consent.Dataaccess)
+	for _, HPconsent := range consents {
+		if HPconsent.ConsumerID == consent.Consumerid && contains(datatypes, HPconsent.Datatype) && contains(accesses, HPconsent.Dataaccess) && HPconsent.IsActive(at) {
+			return HPconsent.ConsentID, nil
+		}
+	}
+	return "", nil
+}
+
+// Consents of the owner that may be active at the given instant, the active ones only unless a past instant is given
+func (a *AppContext) ownerConsentsAt(consent model.Consent, at time.Time) ([]hyperledger.Consent, error) {
+	if consent.At == "" || !at.Before(time.Now()) {
+		return a.Consent_helper.GetConsents4Owner(consent.Appid, consent.Ownerid)
+	}
+	consents, err := a.Consent_helper.GetAllConsents(consent.Appid)
+	if err != nil {
+		return nil, err
+	}
+	var owned []hyperledger.Consent
+	for _, HPconsent := range consents {
+		if HPconsent.OwnerID == consent.Ownerid {
+			owned = append(owned, HPconsent)
+		}
+	}
+	return owned, nil
+}
+
+func isConsent2Bytes(isconsent bool) ([]byte, error) {
 	response := model.IsConsent{}
 	if isconsent {
 		response.Consent = "True"
@@ -204,12 +454,17 @@ func convertHPConsent2APIConsent(HPconsent hyperledger.Consent) model.Consent {
 	log.Trace(log.Here(), "convertHPConsent2APIConsent() : calling method -")
 	consent := model.Consent{}
 	consent.Consentid = HPconsent.ConsentID
+	consent.Appid = HPconsent.AppID
+	consent.State = HPconsent.EffectiveState(time.Now())
 	consent.Ownerid = HPconsent.OwnerID
 	consent.Consumerid = HPconsent.ConsumerID
 	consent.Dataaccess = HPconsent.Dataaccess
 	consent.Datatype = HPconsent.Datatype
 	consent.Dt_begin = HPconsent.Dt_begin
 	consent.Dt_end = HPconsent.Dt_end
+	for _, transition := range HPconsent.Transitions {
+		consent.Transitions = append(consent.Transitions, model.Transition{State: transition.State, Date: transition.Date})
+	}
 	return consent
 }
 
@@ -241,19 +496,19 @@ func consent2Bytes(consent model.Consent) ([]byte, error) {
 func check_args(consent *model.Consent) error {
 	log.Trace(log.Here(), "check_args() : calling method -")
 	if consent.Appid == "" {
-		return errors.New("appID is mandatory!")
+		return common.NewValidationError("appID is mandatory!")
 	}
 	if consent.Ownerid == "" {
-		return errors.New("ownerID is mandatory!")
+		return common.NewValidationError("ownerID is mandatory!")
 	}
 	if consent.Consumerid == "" {
-		return errors.New("consumerID is mandatory!")
+		return common.NewValidationError("consumerID is mandatory!")
 	}
 	if consent.Dataaccess == "" {
-		consent.Dataaccess = "A"
+		consent.Dataaccess = ALL_DATAACCESS
 	}
 	if consent.Datatype == "" {
-		consent.Datatype = "All"
+		consent.Datatype = ALL_DATATYPES
 	}
 	if consent.Dt_begin == "" {
 		consent.Dt_begin = time.Now().Format("2006-01-02")
@@ -261,55 +516,35 @@ func check_args(consent *model.Consent) error {
 	if consent.Dt_end == "" {
 		consent.Dt_end = "2099-01-01"
 	}
+	begin, err := common.DateTimeParse(consent.Dt_begin)
+	if err != nil {
+		return common.NewValidationError("dt_begin is not a date (2006-01-02) nor a RFC 3339 timestamp: " + consent.Dt_begin)
+	}
+	end, err := common.DateTimeParse(consent.Dt_end)
+	if err != nil {
+		return common.NewValidationError("dt_end is not a date (2006-01-02) nor a RFC 3339 timestamp: " + consent.Dt_end)
+	}
+	if end.Before(begin) {
+		return common.NewValidationError("dt_end is before dt_begin!")
+	}
 	return nil
 }
 
 func buildDecodedConsent(transaction hyperledger.Transaction) ([]byte, error) {
 	log.Trace(log.Here(), "buildDecodedConsent() : calling method -")
-
-	type DecodedConsent struct {
-		Txuuid     string `json:"txuuid"`
-		Appid      string `json:"appid"`
-		Ownerid    string `json:"ownerid"`
-		Consumerid string `json:"consumerid"`
-		Datatype   string `json:"datatype"`
-		Dataaccess string `json:"dataaccess"`
-		Dt_begin   string `json:"dt_begin"`
-		Dt_end     string `json:"dt_end"`
-		Ccid       string `json:"ccid"`
-	}
-
-	decConsent := DecodedConsent{}
-	decConsent.Txuuid = transaction.Txid
-	decConsent.Ccid = transaction.ChaincodeID
-	decPayload, _ := b64.StdEncoding.DecodeString(transaction.Payload)
-	//ccid := string(decPayload[11:139])
-	args := make([]string, 9)
-	j := 1
-	i := 1
-	decPayload = decPayload[140:]
-	for i < len(decPayload) {
-		if decPayload[i] == 10 {
-			size, _ := strconv.Atoi(fmt.Sprintf("%v", decPayload[i+1]))
-			args[j] = string(decPayload[i+2 : i+2+size])
-			j++
-			i = i + 2 + size
-		} else {
-			i++
-		}
-	}
-	fmt.Printf("%#v\n", args)
-	decConsent.Appid = string(fmt.Sprintf("%v", args[2]))
-	decConsent.Ownerid = string(fmt.Sprintf("%v", args[3]))
-	decConsent.Consumerid = string(fmt.Sprintf("%v", args[4]))
-	decConsent.Datatype = string(fmt.Sprintf("%v", args[5]))
-	decConsent.Dataaccess = string(fmt.Sprintf("%v", args[6]))
-	decConsent.Dt_begin = string(fmt.Sprintf("%v", args[7]))
-	decConsent.Dt_end = string(fmt.Sprintf("%v", args[8]))
-	js, err := json.Marshal(decConsent)
+	invocation, err := transaction.GetConsentInvocation()
 	if err != nil {
 		return nil, err
 	}
-	fmt.Println("TRconsent: ", string(js))
-	return js, nil
+	decConsent := model.DecodedConsent{}
+	decConsent.Txuuid = transaction.Txid
+	decConsent.Ccid = transaction.ChaincodeID
+	decConsent.Appid = invocation.Args[0]
+	decConsent.Ownerid = invocation.Args[1]
+	decConsent.Consumerid = invocation.Args[2]
+	decConsent.Datatype = invocation.Args[3]
+	decConsent.Dataaccess = invocation.Args[4]
+	decConsent.Dt_begin = invocation.Args[5]
+	decConsent.Dt_end = invocation.Args[6]
+	return json.Marshal(decConsent)
 }
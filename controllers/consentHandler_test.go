@@ -41,8 +41,10 @@ var logfile *os.File
 var authContext authcontrollers.AppContext
 var configuration setting.Settings
 var tokenValue string
+var appContext AppContext
 
-const TransactionTimeout = 5000000000
+// Delay before reading a committed transaction, only needed with a real peer
+var TransactionTimeout time.Duration
 
 func TestMain(m *testing.M) {
 	setup()
@@ -53,27 +55,66 @@ func TestMain(m *testing.M) {
 
 func setup() {
 	// Init configs
-	authConfig := authsetting.Settings{DataSourceName: "/tmp/auth_test.db", LogFileName: "/tmp/test.log", LogMode: "Trace", ExpireInToken: 24}
+	authConfig := authsetting.Settings{DataSourceName: "/tmp/ocms_test.db", LogFileName: "/tmp/test.log", LogMode: "Trace", ExpireInToken: 24, ExpireInRefreshToken: 720, TokenSecret: "test-secret"}
 
 	configuration = setting.Settings{Version: "1.0.1 (2017-02-01)", LogFileName: "/tmp/test.log", LogMode: "Trace", HttpHyperledger: "http://10.194.18.49:7050", ChainCodePath: "github.com/orangelabs/consent", ChainCodeName: "41149f5089e76b3b95fcf20e25a64fde7be07452b602dafe78f192bf826c14da25a3767c3a281255f4f355842a5a87a366aca65f44f5a02afc1784417079b76e", ApplicationID: "280399A20162908Z", EnrollID: "orange_user", EnrollSecret: "GtflmdhF6K32"}
 
 	// Init logger
 	logfile = log.Init_log(configuration.LogFileName, configuration.LogMode)
 
+	// Start from an empty database
+	os.Remove(authConfig.DataSourceName)
+
 	// Init Auth mode
 	var router *mux.Router
 	var err error
-	router, authContext, err = initialize.Init(true, &authConfig)
+	router, authContext, err = initialize.Init(true, &authConfig, nil)
 	if err != nil {
 		panic(err.Error())
 	}
 
-	// Init Hyperledger helpers
-	HP_helper := hyperledger.HP_Helper{HttpHyperledger: configuration.HttpHyperledger, HLTimeout: configuration.HLTimeout}
-	Consent_Helper := hyperledger.Consent_Helper{HP_helper: HP_helper, ChainCodePath: configuration.ChainCodePath, ChainCodeName: configuration.ChainCodeName, EnrollID: configuration.EnrollID, EnrollSecret: configuration.EnrollSecret}
+	// Init ledger backend, in memory unless OCMS_LEDGER=hyperledger
+	var consentLedger hyperledger.ConsentLedger
+	if os.Getenv("OCMS_LEDGER") == hyperledger.HYPERLEDGER_LEDGER {
+		HP_helper := hyperledger.HP_Helper{HttpHyperledger: configuration.HttpHyperledger, HLTimeout: configuration.HLTimeout}
+		consentLedger = &hyperledger.Consent_Helper{HP_helper: HP_helper, ChainCodePath: configuration.ChainCodePath, ChainCodeName: configuration.ChainCodeName, EnrollID: configuration.EnrollID, EnrollSecret: configuration.EnrollSecret}
+		TransactionTimeout = 5000000000
+	} else {
+		consentLedger = hyperledger.NewMemoryLedger(configuration.ChainCodeName)
+	}
+
+	// Commit wait of the wait=true writes
+	configuration.TransactionTimeout = 5000000000
+
+	// Migrate the OCMS tables
+	sqlContext := model.SqlContext{Db: authContext.SqlContext.Db}
+	err6 := sqlContext.MigrateUp()
+	if err6 != nil {
+		panic(err6.Error())
+	}
+
+	// Serve the reads from an empty consent index
+	configuration.IndexStaleness = 60000000000
+	consentIndex, err2 := hyperledger.NewIndexLedger(consentLedger, authContext.SqlContext.Db, configuration.IndexStaleness, configuration.TransactionTimeout)
+	if err2 != nil {
+		panic(err2.Error())
+	}
+	err2 = consentIndex.Clear()
+	if err2 != nil {
+		panic(err2.Error())
+	}
+	consentLedger = consentIndex
 
 	// Init application context
-	appContext := AppContext{Consent_helper: Consent_Helper, Configuration: configuration, AuthContext: authContext}
+	appContext = AppContext{Consent_helper: consentLedger, Configuration: configuration, AuthContext: authContext, SqlContext: sqlContext}
+
+	// Log and stream the consent events, the streams check their token often
+	appContext.Events = NewEventBroker(appContext.SqlContext)
+	appContext.Events.Heartbeat = 100 * time.Millisecond
+
+	// Deliver the consent events quickly to the local receivers, the failed deliveries are retried twice
+	appContext.Configuration.WebhookPrivate = true
+	appContext.Webhooks = NewWebhookDispatcher(appContext.SqlContext, 2, 3, 10*time.Millisecond, time.Second, true)
 
 	// Init permissions for application
 	err3 := appContext.InitPermissions()
@@ -81,6 +122,12 @@ func setup() {
 		panic(err3.Error())
 	}
 
+	// Register the default application
+	err5 := appContext.InitApplication()
+	if err5 != nil {
+		panic(err5.Error())
+	}
+
 	// Init routes for application
 	appContext.CreateOCMSRoutes(router)
 
@@ -171,6 +218,40 @@ func TestGetConsents4ConsumerFromAPINominal(t *testing.T) {
 	}
 }
 
+func TestGetInactiveConsentsFromAPINominal(t *testing.T) {
+	ownerid := "5555"
+	consentID, err := createConsent(model.Consent{Ownerid: ownerid, Consumerid: "6666"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	createConsent(model.Consent{Ownerid: ownerid, Consumerid: "7777"})
+	data, _ := json.Marshal(model.Consent{Action: "revoke", Appid: configuration.ApplicationID, Consentid: consentID})
+	request, _ := common.BuildRequestWithToken("POST", httpServerTest.URL+CONSENTAPI, string(data), tokenValue)
+	status, _, err2 := common.ExecuteRequest(request)
+	if err2 != nil || status != http.StatusOK {
+		t.Fatal("revoke failed: ", status, err2)
+	}
+	actives, err3 := getListOfConsents(ownerid, "")
+	if err3 != nil || len(actives) != 1 {
+		t.Error("expected 1 active consent, got ", len(actives), err3)
+	}
+	for _, action := range []string{"list", "list4owner"} {
+		consents, err4 := postListOfConsents(model.Consent{Action: action, Appid: configuration.ApplicationID, Ownerid: ownerid, Include_inactive: true})
+		if err4 != nil {
+			t.Fatal(err4)
+		}
+		states := map[string]string{}
+		for _, consent := range consents {
+			if consent.Ownerid == ownerid {
+				states[consent.Consentid] = consent.State
+			}
+		}
+		if len(states) != 2 || states[consentID] != "revoked" {
+			t.Error(action, ": expected the revoked consent in the listing, got ", states)
+		}
+	}
+}
+
 func TestGetTRconsentFromAPINominal(t *testing.T) {
 	consent := model.Consent{Ownerid: "1111", Consumerid: "2222"}
 	consentID, err := createConsent(consent)
@@ -289,7 +370,6 @@ func getConsent(consentID string) (model.Consent, error) {
 
 func getListOfConsents(ownerID, consumerID string) ([]model.Consent, error) {
 	consent := model.Consent{Action: "list", Appid: configuration.ApplicationID}
-	consents := []model.Consent{}
 	if ownerID != "" {
 		consent.Ownerid = ownerID
 		consent.Action = "list4owner"
@@ -297,6 +377,11 @@ func getListOfConsents(ownerID, consumerID string) ([]model.Consent, error) {
 		consent.Consumerid = consumerID
 		consent.Action = "list4consumer"
 	}
+	return postListOfConsents(consent)
+}
+
+func postListOfConsents(consent model.Consent) ([]model.Consent, error) {
+	consents := []model.Consent{}
 	data, _ := json.Marshal(consent)
 	request, err1 := common.BuildRequestWithToken("POST", httpServerTest.URL+CONSENTAPI, string(data), tokenValue)
 	if err1 != nil {
@@ -0,0 +1,249 @@
+/*
+Copyright Pascal Limeux. 2017 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/pascallimeux/ocms2/hyperledger"
+	"github.com/pascallimeux/ocms2/model"
+	"github.com/pascallimeux/ocms2/modules/common"
+	"github.com/pascallimeux/ocms2/modules/log"
+	"net/http"
+)
+
+//HTTP Post - /ocms/v2/consents
+func (a *AppContext) postConsent(w http.ResponseWriter, r *http.Request) {
+	log.Trace(log.Here(), "postConsent() : calling method -")
+
+	r, err1 := a.authorizeCaller(w, r)
+	if err1 != nil {
+		return
+	}
+
+	var consent model.Consent
+	err := json.NewDecoder(r.Body).Decode(&consent)
+	if err != nil {
+		common.SendError(log.Here(), w, err)
+		return
+	}
+	consent.Appid = a.getApplicationID(r, consent.Appid)
+	bytes, err := a.createConsent(r, consent)
+	if err != nil {
+		common.SendError(log.Here(), w, err)
+		return
+	}
+	created := model.Consent{}
+	json.Unmarshal(bytes, &created)
+	w.Header().Set("Location", CONSENTSAPI+"/"+created.Consentid)
+	sendBytes(w, http.StatusCreated, bytes)
+}
+
+//HTTP Post - /ocms/v2/consents/requests
+func (a *AppContext) postConsentRequest(w http.ResponseWriter, r *http.Request) {
+	log.Trace(log.Here(), "postConsentRequest() : calling method -")
+
+	r, err1 := a.authorizeCaller(w, r)
+	if err1 != nil {
+		return
+	}
+
+	var consent model.Consent
+	err := json.NewDecoder(r.Body).Decode(&consent)
+	if err != nil {
+		common.SendError(log.Here(), w, err)
+		return
+	}
+	consent.Appid = a.getApplicationID(r, consent.Appid)
+	bytes, err := a.requestConsent(r, consent)
+	if err != nil {
+		common.SendError(log.Here(), w, err)
+		return
+	}
+	created := model.Consent{}
+	json.Unmarshal(bytes, &created)
+	w.Header().Set("Location", CONSENTSAPI+"/"+created.Consentid)
+	sendBytes(w, http.StatusCreated, bytes)
+}
+
+//HTTP Post - /ocms/v2/consents/{id}/approve
+func (a *AppContext) approveConsent(w http.ResponseWriter, r *http.Request) {
+	log.Trace(log.Here(), "approveConsent() : calling method -")
+	a.answerConsent(w, r, hyperledger.STATE_ACTIVE)
+}
+
+//HTTP Post - /ocms/v2/consents/{id}/reject
+func (a *AppContext) rejectConsent(w http.ResponseWriter, r *http.Request) {
+	log.Trace(log.Here(), "rejectConsent() : calling method -")
+	a.answerConsent(w, r, hyperledger.STATE_REJECTED)
+}
+
+func (a *AppContext) answerConsent(w http.ResponseWriter, r *http.Request, state string) {
+	r, err1 := a.authorizeCaller(w, r)
+	if err1 != nil {
+		return
+	}
+
+	vars := mux.Vars(r)
+	bytes, err := a.answerConsentRequest(r, a.getApplicationID(r, ""), vars["id"], state)
+	if err != nil {
+		common.SendError(log.Here(), w, err)
+		return
+	}
+	sendBytes(w, http.StatusOK, bytes)
+}
+
+//HTTP Get - /ocms/v2/consents?owner=&consumer=&state=&datatype=&dataaccess=&from=&to=&sort=&limit=&cursor=
+func (a *AppContext) getConsents(w http.ResponseWriter, r *http.Request) {
+	log.Trace(log.Here(), "getConsents() : calling method -")
+
+	r, err1 := a.authorizeCaller(w, r)
+	if err1 != nil {
+		return
+	}
+
+	query := r.URL.Query()
+	appID := a.getApplicationID(r, "")
+	ownerID := query.Get("owner")
+	consumerID := query.Get("consumer")
+	message := fmt.Sprintf("getConsents(applicationID=%s, owner=%s, consumer=%s, query=%s) : calling method -", appID, ownerID, consumerID, r.URL.RawQuery)
+	log.Info(log.Here(), message)
+
+	options, err := parseListOptions(query)
+	if err != nil {
+		common.SendError(log.Here(), w, err)
+		return
+	}
+	err = a.checkListPermission(r, appID, ownerID, consumerID)
+	if err != nil {
+		common.SendError(log.Here(), w, err)
+		return
+	}
+
+	bytes, err := a.listConsentPage(appID, ownerID, consumerID, options)
+	if err != nil {
+		common.SendError(log.Here(), w, err)
+		return
+	}
+	sendBytes(w, http.StatusOK, bytes)
+}
+
+//HTTP Get - /ocms/v2/consents/{id}
+func (a *AppContext) getConsentResource(w http.ResponseWriter, r *http.Request) {
+	log.Trace(log.Here(), "getConsentResource() : calling method -")
+
+	r, err1 := a.authorizeCaller(w, r)
+	if err1 != nil {
+		return
+	}
+
+	vars := mux.Vars(r)
+	bytes, err := a.getConsent(r, a.getApplicationID(r, ""), vars["id"])
+	if err != nil {
+		common.SendError(log.Here(), w, err)
+		return
+	}
+	sendBytes(w, http.StatusOK, bytes)
+}
+
+//HTTP Delete - /ocms/v2/consents/{id}
+func (a *AppContext) deleteConsent(w http.ResponseWriter, r *http.Request) {
+	log.Trace(log.Here(), "deleteConsent() : calling method -")
+
+	r, err1 := a.authorizeCaller(w, r)
+	if err1 != nil {
+		return
+	}
+
+	vars := mux.Vars(r)
+	bytes, err := a.unactivateConsent(r, a.getApplicationID(r, ""), vars["id"])
+	if err != nil {
+		common.SendError(log.Here(), w, err)
+		return
+	}
+	sendBytes(w, http.StatusOK, bytes)
+}
+
+//HTTP Put - /ocms/v2/consents/{id}/state
+func (a *AppContext) putConsentState(w http.ResponseWriter, r *http.Request) {
+	log.Trace(log.Here(), "putConsentState() : calling method -")
+
+	r, err1 := a.authorizeCaller(w, r)
+	if err1 != nil {
+		return
+	}
+
+	var consent model.Consent
+	err := json.NewDecoder(r.Body).Decode(&consent)
+	if err != nil {
+		common.SendError(log.Here(), w, err)
+		return
+	}
+	if consent.State == "" {
+		common.SendError(log.Here(), w, common.NewValidationError("state is mandatory!"))
+		return
+	}
+	vars := mux.Vars(r)
+	bytes, err := a.changeConsentState(r, a.getApplicationID(r, ""), vars["id"], consent.State)
+	if err != nil {
+		common.SendError(log.Here(), w, err)
+		return
+	}
+	sendBytes(w, http.StatusOK, bytes)
+}
+
+//HTTP Get - /ocms/v2/consents/check?owner=&consumer=&datatype=&dataaccess=&at=
+func (a *AppContext) checkConsent(w http.ResponseWriter, r *http.Request) {
+	log.Trace(log.Here(), "checkConsent() : calling method -")
+
+	r, err1 := a.authorizeCaller(w, r)
+	if err1 != nil {
+		return
+	}
+
+	query := r.URL.Query()
+	consent := model.Consent{Appid: a.getApplicationID(r, ""), Ownerid: query.Get("owner"), Consumerid: query.Get("consumer"), Datatype: query.Get("datatype"), Dataaccess: query.Get("dataaccess"), At: query.Get("at")}
+	if consent.Ownerid == "" || consent.Consumerid == "" {
+		common.SendError(log.Here(), w, common.NewValidationError("owner and consumer are mandatory!"))
+		return
+	}
+	bytes, err := a.isConsent(r, consent)
+	if err != nil {
+		common.SendError(log.Here(), w, err)
+		return
+	}
+	sendBytes(w, http.StatusOK, bytes)
+}
+
+// Owners list their consents, consumers the consents given to them, the whole application needs listConsents
+func (a *AppContext) checkListPermission(r *http.Request, applicationID, ownerID, consumerID string) error {
+	var err error
+	if ownerID != "" {
+		err = a.checkConsentPermission(r, applicationID, "listOwnerConsents", ownerID)
+	}
+	if consumerID != "" && (ownerID == "" || (err != nil && common.ToHttpError(err).Status == http.StatusForbidden)) {
+		err = a.checkConsentPermission(r, applicationID, "listConsumerConsents", consumerID)
+	}
+	if ownerID == "" && consumerID == "" {
+		err = a.checkConsentPermission(r, applicationID, "listConsents")
+	}
+	return err
+}
+
+func sendBytes(w http.ResponseWriter, status int, bytes []byte) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(bytes)
+}
@@ -0,0 +1,719 @@
+/*
+Copyright Pascal Limeux. 2017 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"encoding/json"
+	"github.com/pascallimeux/ocms2/hyperledger"
+	"github.com/pascallimeux/ocms2/model"
+	authcontrollers "github.com/pascallimeux/ocms2/modules/auth/controllers"
+	authmodel "github.com/pascallimeux/ocms2/modules/auth/model"
+	"github.com/pascallimeux/ocms2/modules/common"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConsentResourceLifecycleNominal(t *testing.T) {
+	data, _ := json.Marshal(model.Consent{Ownerid: "R001", Consumerid: "R002", Datatype: "BP", Dataaccess: "R", Dt_begin: common.GetStringDateNow(0), Dt_end: common.GetStringDateNow(1)})
+	request, _ := common.BuildRequestWithToken("POST", httpServerTest.URL+CONSENTSAPI, string(data), tokenValue)
+	status, body, err := common.ExecuteRequest(request)
+	if err != nil || status != http.StatusCreated {
+		t.Fatal("create consent: ", status, " ", string(body))
+	}
+	created := model.Consent{}
+	json.Unmarshal(body, &created)
+	time.Sleep(TransactionTimeout)
+
+	request, _ = common.BuildRequestWithToken("GET", httpServerTest.URL+CONSENTSAPI+"/"+created.Consentid, " ", tokenValue)
+	status, body, _ = common.ExecuteRequest(request)
+	read := model.Consent{}
+	json.Unmarshal(body, &read)
+	if status != http.StatusOK || read.Ownerid != "R001" {
+		t.Error("get consent: ", status, " ", string(body))
+	}
+
+	request, _ = common.BuildRequestWithToken("GET", httpServerTest.URL+CONSENTSAPI+"?owner=R001", " ", tokenValue)
+	status, body, _ = common.ExecuteRequest(request)
+	consents := []model.Consent{}
+	json.Unmarshal(body, &consents)
+	if status != http.StatusOK || len(consents) != 1 {
+		t.Error("list consents for owner: ", status, " ", string(body))
+	}
+
+	request, _ = common.BuildRequestWithToken("GET", httpServerTest.URL+CONSENTSAPI+"/check?owner=R001&consumer=R002&datatype=BP&dataaccess=R", " ", tokenValue)
+	status, body, _ = common.ExecuteRequest(request)
+	isconsent := model.IsConsent{}
+	json.Unmarshal(body, &isconsent)
+	if status != http.StatusOK || isconsent.Consent != "True" {
+		t.Error("check consent: ", status, " ", string(body))
+	}
+
+	request, _ = common.BuildRequestWithToken("DELETE", httpServerTest.URL+CONSENTSAPI+"/"+created.Consentid, " ", tokenValue)
+	status, body, _ = common.ExecuteRequest(request)
+	if status != http.StatusOK {
+		t.Error("revoke consent: ", status, " ", string(body))
+	}
+	time.Sleep(TransactionTimeout)
+
+	request, _ = common.BuildRequestWithToken("GET", httpServerTest.URL+CONSENTSAPI+"/check?owner=R001&consumer=R002&datatype=BP&dataaccess=R", " ", tokenValue)
+	_, body, _ = common.ExecuteRequest(request)
+	json.Unmarshal(body, &isconsent)
+	if isconsent.Consent != "False" {
+		t.Error("revoked consent still granted: ", string(body))
+	}
+}
+
+func TestConsentResourceErrorCodes(t *testing.T) {
+	checkErrorBody(t, "GET", CONSENTSAPI+"/unknownconsent", " ", tokenValue, http.StatusNotFound, common.NOT_FOUND)
+	checkErrorBody(t, "GET", CONSENTSAPI, " ", "", http.StatusUnauthorized, common.UNAUTHORIZED)
+	checkErrorBody(t, "POST", CONSENTSAPI+"/unknownconsent/approve", " ", "", http.StatusUnauthorized, common.UNAUTHORIZED)
+	checkErrorBody(t, "POST", CONSENTSAPI, "{bad json", tokenValue, http.StatusBadRequest, common.VALIDATION_ERROR)
+	checkErrorBody(t, "POST", CONSENTAPI, "{\"action\":\"unknown\"}", tokenValue, http.StatusBadRequest, common.VALIDATION_ERROR)
+}
+
+func checkErrorBody(t *testing.T, method, uri, data, token string, expectedStatus int, expectedCode string) {
+	request, _ := common.BuildRequestWithToken(method, httpServerTest.URL+uri, data, token)
+	status, body, err := common.ExecuteRequest(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errorBody := common.ErrorBody{}
+	json.Unmarshal(body, &errorBody)
+	if status != expectedStatus || errorBody.Code != expectedCode || errorBody.Request_id == "" {
+		t.Error(method, " ", uri, " : ", status, " ", string(body))
+	}
+}
+
+func TestConsentResourceStatesNominal(t *testing.T) {
+	data, _ := json.Marshal(model.Consent{Ownerid: "R101", Consumerid: "R102", Datatype: "BP", Dataaccess: "R", Dt_begin: common.GetStringDateNow(0), Dt_end: common.GetStringDateNow(1)})
+	request, _ := common.BuildRequestWithToken("POST", httpServerTest.URL+CONSENTSAPI, string(data), tokenValue)
+	_, body, _ := common.ExecuteRequest(request)
+	created := model.Consent{}
+	json.Unmarshal(body, &created)
+	time.Sleep(TransactionTimeout)
+
+	checkConsentState(t, created.Consentid, "suspended", http.StatusOK)
+	checkConsentState(t, created.Consentid, "suspended", http.StatusConflict)
+	request, _ = common.BuildRequestWithToken("GET", httpServerTest.URL+CONSENTSAPI+"/check?owner=R101&consumer=R102&datatype=BP&dataaccess=R", " ", tokenValue)
+	_, body, _ = common.ExecuteRequest(request)
+	isconsent := model.IsConsent{}
+	json.Unmarshal(body, &isconsent)
+	if isconsent.Consent != "False" {
+		t.Error("suspended consent still granted: ", string(body))
+	}
+	checkConsentState(t, created.Consentid, "active", http.StatusOK)
+	checkConsentState(t, created.Consentid, "revoked", http.StatusOK)
+	checkConsentState(t, created.Consentid, "active", http.StatusNotFound)
+
+	data, _ = json.Marshal(model.Consent{Ownerid: "R103", Consumerid: "R102", Datatype: "BP", Dataaccess: "R", Dt_begin: common.GetStringDateNow(-2), Dt_end: common.GetStringDateNow(-1)})
+	request, _ = common.BuildRequestWithToken("POST", httpServerTest.URL+CONSENTSAPI, string(data), tokenValue)
+	common.ExecuteRequest(request)
+	time.Sleep(TransactionTimeout)
+	request, _ = common.BuildRequestWithToken("GET", httpServerTest.URL+CONSENTSAPI+"?owner=R103", " ", tokenValue)
+	_, body, _ = common.ExecuteRequest(request)
+	consents := []model.Consent{}
+	json.Unmarshal(body, &consents)
+	if len(consents) != 0 {
+		t.Error("expired consent listed as active: ", string(body))
+	}
+	request, _ = common.BuildRequestWithToken("GET", httpServerTest.URL+CONSENTSAPI+"?owner=R103&state=expired", " ", tokenValue)
+	_, body, _ = common.ExecuteRequest(request)
+	json.Unmarshal(body, &consents)
+	if len(consents) != 1 || consents[0].State != "expired" {
+		t.Error("expired consent not listed: ", string(body))
+	}
+	request, _ = common.BuildRequestWithToken("GET", httpServerTest.URL+CONSENTSAPI+"?owner=R101&include_inactive=true", " ", tokenValue)
+	_, body, _ = common.ExecuteRequest(request)
+	consents = []model.Consent{}
+	json.Unmarshal(body, &consents)
+	if len(consents) != 1 || consents[0].State != "revoked" {
+		t.Error("revoked consent not listed with include_inactive: ", string(body))
+	}
+}
+
+func checkConsentState(t *testing.T, consentID, state string, expectedStatus int) {
+	request, _ := common.BuildRequestWithToken("PUT", httpServerTest.URL+CONSENTSAPI+"/"+consentID+"/state", "{\"State\":\""+state+"\"}", tokenValue)
+	status, body, _ := common.ExecuteRequest(request)
+	consent := model.Consent{}
+	json.Unmarshal(body, &consent)
+	if status != expectedStatus || (status == http.StatusOK && consent.State != state) {
+		t.Error("move consent to ", state, " : ", status, " ", string(body))
+	}
+	time.Sleep(TransactionTimeout)
+}
+
+func TestConsentRequestApprovalNominal(t *testing.T) {
+	owner, ownerToken := registerUser(t, "owner1")
+
+	consentID := requestConsent(t, owner.Id)
+	checkIsConsent(t, owner.Id, "False")
+	answerConsent(t, consentID, "approve", tokenValue, http.StatusForbidden)
+	answerConsent(t, consentID, "approve", ownerToken, http.StatusOK)
+	checkIsConsent(t, owner.Id, "True")
+	answerConsent(t, consentID, "reject", ownerToken, http.StatusConflict)
+
+	consentID = requestConsent(t, owner.Id)
+	checkConsentState(t, consentID, "active", http.StatusConflict)
+	answerConsent(t, consentID, "reject", ownerToken, http.StatusOK)
+	answerConsent(t, consentID, "approve", ownerToken, http.StatusConflict)
+}
+
+func requestConsent(t *testing.T, ownerID string) string {
+	data, _ := json.Marshal(model.Consent{Ownerid: ownerID, Consumerid: "R202", Datatype: "BP", Dataaccess: "R"})
+	request, _ := common.BuildRequestWithToken("POST", httpServerTest.URL+CONSENTSAPI+"/requests", string(data), tokenValue)
+	status, body, _ := common.ExecuteRequest(request)
+	consent := model.Consent{}
+	json.Unmarshal(body, &consent)
+	if status != http.StatusCreated || consent.State != "pending" {
+		t.Error("request consent: ", status, " ", string(body))
+	}
+	time.Sleep(TransactionTimeout)
+	return consent.Consentid
+}
+
+func answerConsent(t *testing.T, consentID, answer, token string, expectedStatus int) {
+	request, _ := common.BuildRequestWithToken("POST", httpServerTest.URL+CONSENTSAPI+"/"+consentID+"/"+answer, " ", token)
+	status, body, _ := common.ExecuteRequest(request)
+	if status != expectedStatus {
+		t.Error(answer, " consent: ", status, " ", string(body))
+	}
+	time.Sleep(TransactionTimeout)
+}
+
+func checkIsConsent(t *testing.T, ownerID, expected string) {
+	request, _ := common.BuildRequestWithToken("GET", httpServerTest.URL+CONSENTSAPI+"/check?owner="+ownerID+"&consumer=R202&datatype=BP&dataaccess=R", " ", tokenValue)
+	_, body, _ := common.ExecuteRequest(request)
+	isconsent := model.IsConsent{}
+	json.Unmarshal(body, &isconsent)
+	if isconsent.Consent != expected {
+		t.Error("check consent: ", expected, " ", string(body))
+	}
+}
+
+func TestConsentOwnershipNominal(t *testing.T) {
+	owner, ownerToken := registerUser(t, "owner2")
+	consumer, consumerToken := registerUser(t, "consumer2")
+
+	data, _ := json.Marshal(model.Consent{Ownerid: owner.Id, Consumerid: consumer.Id, Datatype: "BP", Dataaccess: "R"})
+	request, _ := common.BuildRequestWithToken("POST", httpServerTest.URL+CONSENTSAPI, string(data), consumerToken)
+	status, _, _ := common.ExecuteRequest(request)
+	if status != http.StatusForbidden {
+		t.Error("consumer created a consent for the owner: ", status)
+	}
+	request, _ = common.BuildRequestWithToken("POST", httpServerTest.URL+CONSENTSAPI, string(data), ownerToken)
+	status, body, _ := common.ExecuteRequest(request)
+	created := model.Consent{}
+	json.Unmarshal(body, &created)
+	if status != http.StatusCreated {
+		t.Fatal("owner can not create a consent: ", status, " ", string(body))
+	}
+	time.Sleep(TransactionTimeout)
+
+	checkStatus(t, "GET", CONSENTSAPI+"?owner="+owner.Id, ownerToken, http.StatusOK)
+	checkStatus(t, "GET", CONSENTSAPI+"?owner="+owner.Id, consumerToken, http.StatusForbidden)
+	checkStatus(t, "GET", CONSENTSAPI+"?consumer="+consumer.Id, consumerToken, http.StatusOK)
+	checkStatus(t, "GET", CONSENTSAPI, ownerToken, http.StatusForbidden)
+	checkStatus(t, "GET", CONSENTSAPI+"/"+created.Consentid, consumerToken, http.StatusOK)
+	checkStatus(t, "DELETE", CONSENTSAPI+"/"+created.Consentid, consumerToken, http.StatusForbidden)
+	checkStatus(t, "DELETE", CONSENTSAPI+"/"+created.Consentid, ownerToken, http.StatusOK)
+
+	logs, _ := authContext.SqlContext.GetLogs()
+	denied := false
+	for _, logg := range logs {
+		if logg.Resource_name == "revokeConsent" && logg.User_id == consumer.Id && !logg.Access_granted {
+			denied = true
+		}
+	}
+	if !denied {
+		t.Error("denied revocation not logged")
+	}
+}
+
+// Even when an application lets its users create the consents of others, they can only request them
+func TestConsentDirectCreationOwnerOnly(t *testing.T) {
+	request, _ := common.BuildRequestWithToken("POST", httpServerTest.URL+authcontrollers.APPLIURI, "{\"id\":\"APPLI6\",\"name\":\"appli6\",\"datatypes\":[\"BP\"]}", tokenValue)
+	status, body, _ := common.ExecuteRequest(request)
+	if status != http.StatusCreated {
+		t.Fatal("register application: ", status, " ", string(body))
+	}
+	authContext.SqlContext.CreateApplicationPermission(authmodel.ApplicationPermission{Application_id: "APPLI6", Resource_name: "createConsent", Role_code: 3, Owner_only: false})
+	consumer, consumerToken := registerUser(t, "consumer6")
+	request, _ = common.BuildRequestWithToken("POST", httpServerTest.URL+authcontrollers.APPLIURI+"/APPLI6/user", "{\"user_id\":\""+consumer.Id+"\"}", tokenValue)
+	common.ExecuteRequest(request)
+
+	data, _ := json.Marshal(model.Consent{Appid: "APPLI6", Ownerid: "R601", Consumerid: consumer.Id, Datatype: "BP", Dataaccess: "R"})
+	request, _ = common.BuildRequestWithToken("POST", httpServerTest.URL+CONSENTSAPI, string(data), consumerToken)
+	status, _, _ = common.ExecuteRequest(request)
+	if status != http.StatusForbidden {
+		t.Error("consumer created a consent directly: ", status)
+	}
+	data, _ = json.Marshal(model.Consent{Action: "create", Appid: "APPLI6", Ownerid: "R601", Consumerid: consumer.Id, Datatype: "BP", Dataaccess: "R"})
+	request, _ = common.BuildRequestWithToken("POST", httpServerTest.URL+CONSENTAPI, string(data), consumerToken)
+	status, _, _ = common.ExecuteRequest(request)
+	if status != http.StatusForbidden {
+		t.Error("consumer created a consent directly with the v1 api: ", status)
+	}
+}
+
+// The users of a deployment upgraded to the applications become members of the default application
+func TestInitApplicationMembersNominal(t *testing.T) {
+	user, err := authContext.SqlContext.CreateUser("member8", "", "", "member8@orange.com", "member8pwd", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upgraded := AppContext{AuthContext: authContext, Configuration: configuration}
+	upgraded.Configuration.ApplicationID = "APPLI8"
+	err1 := upgraded.InitApplication()
+	if err1 != nil {
+		t.Fatal(err1)
+	}
+	member, _ := authContext.SqlContext.IsApplicationUser("APPLI8", user.Id)
+	if !member {
+		t.Error("existing user not member of the default application")
+	}
+	authContext.SqlContext.DeleteApplicationUser("APPLI8", user.Id)
+	upgraded.InitApplication()
+	member, _ = authContext.SqlContext.IsApplicationUser("APPLI8", user.Id)
+	if member {
+		t.Error("removed member added again at startup")
+	}
+}
+
+func registerUser(t *testing.T, username string) (authmodel.User, string) {
+	request, _ := common.BuildRequestWithToken("POST", httpServerTest.URL+authcontrollers.REGISTERURI, "{\"username\":\""+username+"\",\"email\":\""+username+"@orange.com\",\"password\":\""+username+"pwd\",\"role_id\":3}", tokenValue)
+	_, body, _ := common.ExecuteRequest(request)
+	user := authmodel.User{}
+	json.Unmarshal(body, &user)
+	request, _ = common.BuildRequestWithToken("POST", httpServerTest.URL+authcontrollers.APPLIURI+"/"+configuration.ApplicationID+"/user", "{\"user_id\":\""+user.Id+"\"}", tokenValue)
+	common.ExecuteRequest(request)
+	token, err := getToken(username, username+"pwd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user, token.Token
+}
+
+func checkStatus(t *testing.T, method, uri, token string, expectedStatus int) {
+	request, _ := common.BuildRequestWithToken(method, httpServerTest.URL+uri, " ", token)
+	status, body, _ := common.ExecuteRequest(request)
+	if status != expectedStatus {
+		t.Error(method, " ", uri, " : ", status, " ", string(body))
+	}
+}
+
+func TestConsentApplicationScopeNominal(t *testing.T) {
+	request, _ := common.BuildRequestWithToken("POST", httpServerTest.URL+authcontrollers.APPLIURI, "{\"id\":\"APPLI2\",\"name\":\"appli2\",\"datatypes\":[\"BP\"]}", tokenValue)
+	status, body, _ := common.ExecuteRequest(request)
+	if status != http.StatusCreated {
+		t.Fatal("register application: ", status, " ", string(body))
+	}
+	owner, ownerToken := registerUser(t, "owner3")
+
+	data, _ := json.Marshal(model.Consent{Appid: "APPLI2", Ownerid: owner.Id, Consumerid: "R302", Datatype: "BP", Dataaccess: "R"})
+	request, _ = common.BuildRequestWithToken("POST", httpServerTest.URL+CONSENTSAPI, string(data), ownerToken)
+	status, _, _ = common.ExecuteRequest(request)
+	if status != http.StatusForbidden {
+		t.Error("consent created by a user outside the application: ", status)
+	}
+
+	request, _ = common.BuildRequestWithToken("POST", httpServerTest.URL+authcontrollers.APPLIURI+"/APPLI2/user", "{\"user_id\":\""+owner.Id+"\"}", tokenValue)
+	common.ExecuteRequest(request)
+	request, _ = common.BuildRequestWithToken("POST", httpServerTest.URL+CONSENTSAPI, string(data), ownerToken)
+	status, body, _ = common.ExecuteRequest(request)
+	if status != http.StatusCreated {
+		t.Fatal("create consent in application: ", status, " ", string(body))
+	}
+	time.Sleep(TransactionTimeout)
+
+	data, _ = json.Marshal(model.Consent{Appid: "APPLI2", Ownerid: owner.Id, Consumerid: "R302", Datatype: "HR", Dataaccess: "R"})
+	request, _ = common.BuildRequestWithToken("POST", httpServerTest.URL+CONSENTSAPI, string(data), ownerToken)
+	status, _, _ = common.ExecuteRequest(request)
+	if status != http.StatusBadRequest {
+		t.Error("datatype not allowed for the application accepted: ", status)
+	}
+
+	consents := []model.Consent{}
+	request, _ = common.BuildRequestWithToken("GET", httpServerTest.URL+CONSENTSAPI+"?appid=APPLI2&owner="+owner.Id, " ", ownerToken)
+	_, body, _ = common.ExecuteRequest(request)
+	json.Unmarshal(body, &consents)
+	if len(consents) != 1 || consents[0].Appid != "APPLI2" {
+		t.Error("consents of the application: ", string(body))
+	}
+	request, _ = common.BuildRequestWithToken("GET", httpServerTest.URL+CONSENTSAPI+"?owner="+owner.Id, " ", ownerToken)
+	_, body, _ = common.ExecuteRequest(request)
+	json.Unmarshal(body, &consents)
+	if len(consents) != 0 {
+		t.Error("consents leaked to the default application: ", string(body))
+	}
+}
+
+func TestConsentVocabularyNominal(t *testing.T) {
+	request, _ := common.BuildRequestWithToken("POST", httpServerTest.URL+authcontrollers.APPLIURI, "{\"id\":\"APPLI3\",\"name\":\"appli3\"}", tokenValue)
+	common.ExecuteRequest(request)
+	for _, term := range []string{"datatype:health/heartrate", "datatype:health/weight", "dataaccess:read", "dataaccess:aggregate"} {
+		parts := strings.SplitN(term, ":", 2)
+		request, _ = common.BuildRequestWithToken("POST", httpServerTest.URL+authcontrollers.APPLIURI+"/APPLI3/"+parts[0], "{\"name\":\""+parts[1]+"\"}", tokenValue)
+		status, body, _ := common.ExecuteRequest(request)
+		if status != http.StatusCreated {
+			t.Fatal("register ", term, " : ", status, " ", string(body))
+		}
+	}
+
+	createInApplication(t, "APPLI3", "R401", "health", "read", http.StatusCreated)
+	createInApplication(t, "APPLI3", "R402", "All", "A", http.StatusCreated)
+	createInApplication(t, "APPLI3", "R403", "finance", "read", http.StatusBadRequest)
+	createInApplication(t, "APPLI3", "R403", "health/weight", "write", http.StatusBadRequest)
+	time.Sleep(TransactionTimeout)
+
+	checkInApplication(t, "APPLI3", "R401", "health/heartrate", "read", http.StatusOK, "True")
+	checkInApplication(t, "APPLI3", "R401", "health/heartrate", "aggregate", http.StatusOK, "False")
+	checkInApplication(t, "APPLI3", "R402", "health/weight", "aggregate", http.StatusOK, "True")
+	checkInApplication(t, "APPLI3", "R401", "health/heartrate", "write", http.StatusBadRequest, "")
+}
+
+func createInApplication(t *testing.T, appID, ownerID, datatype, dataaccess string, expectedStatus int) {
+	data, _ := json.Marshal(model.Consent{Appid: appID, Ownerid: ownerID, Consumerid: "R499", Datatype: datatype, Dataaccess: dataaccess})
+	request, _ := common.BuildRequestWithToken("POST", httpServerTest.URL+CONSENTSAPI, string(data), tokenValue)
+	status, body, _ := common.ExecuteRequest(request)
+	if status != expectedStatus {
+		t.Error("create ", datatype, "/", dataaccess, " : ", status, " ", string(body))
+	}
+}
+
+func checkInApplication(t *testing.T, appID, ownerID, datatype, dataaccess string, expectedStatus int, expected string) {
+	request, _ := common.BuildRequestWithToken("GET", httpServerTest.URL+CONSENTSAPI+"/check?appid="+appID+"&owner="+ownerID+"&consumer=R499&datatype="+datatype+"&dataaccess="+dataaccess, " ", tokenValue)
+	status, body, _ := common.ExecuteRequest(request)
+	isconsent := model.IsConsent{}
+	json.Unmarshal(body, &isconsent)
+	if status != expectedStatus || isconsent.Consent != expected {
+		t.Error("check ", ownerID, " ", datatype, "/", dataaccess, " : ", status, " ", string(body))
+	}
+}
+
+func TestConsentValidityWindowNominal(t *testing.T) {
+	createWithDates(t, "R501", "2017-13-45", "2099-01-01", http.StatusBadRequest)
+	createWithDates(t, "R501", "2017-03-01", "2017-02-01", http.StatusBadRequest)
+	createWithDates(t, "R501", "2030-01-01T08:00:00+02:00", "2030-01-31T18:00:00+02:00", http.StatusCreated)
+	time.Sleep(TransactionTimeout)
+
+	checkAt(t, "R501", "", "False")
+	checkAt(t, "R501", "2030-01-01T05:59:59Z", "False")
+	checkAt(t, "R501", "2030-01-15", "True")
+	checkAt(t, "R501", "2030-01-31T16:00:00Z", "True")
+	checkAt(t, "R501", "2030-01-31T16:00:01Z", "False")
+}
+
+func TestConsentCheckAtPastInstant(t *testing.T) {
+	before := time.Now().Add(-time.Hour).Format(time.RFC3339)
+	data, _ := json.Marshal(model.Consent{Ownerid: "R502", Consumerid: "R599", Datatype: "BP", Dataaccess: "R"})
+	request, _ := common.BuildRequestWithToken("POST", httpServerTest.URL+CONSENTSAPI, string(data), tokenValue)
+	status, body, _ := common.ExecuteRequest(request)
+	created := model.Consent{}
+	json.Unmarshal(body, &created)
+	if status != http.StatusCreated {
+		t.Fatal("create : ", status, " ", string(body))
+	}
+	time.Sleep(TransactionTimeout)
+	valid := time.Now().Format(time.RFC3339)
+	time.Sleep(time.Second)
+	checkStatus(t, "DELETE", CONSENTSAPI+"/"+created.Consentid, tokenValue, http.StatusOK)
+	time.Sleep(TransactionTimeout)
+
+	checkAt(t, "R502", "", "False")
+	checkAt(t, "R502", valid, "True")
+	checkAt(t, "R502", before, "False")
+}
+
+func createWithDates(t *testing.T, ownerID, dt_begin, dt_end string, expectedStatus int) {
+	data, _ := json.Marshal(model.Consent{Ownerid: ownerID, Consumerid: "R599", Datatype: "BP", Dataaccess: "R", Dt_begin: dt_begin, Dt_end: dt_end})
+	request, _ := common.BuildRequestWithToken("POST", httpServerTest.URL+CONSENTSAPI, string(data), tokenValue)
+	status, body, _ := common.ExecuteRequest(request)
+	if status != expectedStatus {
+		t.Error("create ", dt_begin, " - ", dt_end, " : ", status, " ", string(body))
+	}
+}
+
+func checkAt(t *testing.T, ownerID, at, expected string) {
+	request, _ := common.BuildRequestWithToken("GET", httpServerTest.URL+CONSENTSAPI+"/check?owner="+ownerID+"&consumer=R599&datatype=BP&dataaccess=R&at="+url.QueryEscape(at), " ", tokenValue)
+	status, body, _ := common.ExecuteRequest(request)
+	isconsent := model.IsConsent{}
+	json.Unmarshal(body, &isconsent)
+	if status != http.StatusOK || isconsent.Consent != expected {
+		t.Error("check at ", at, " : ", status, " ", string(body))
+	}
+}
+
+func TestConsentHistoryNominal(t *testing.T) {
+	owner, ownerToken := registerUser(t, "owner4")
+	_, otherToken := registerUser(t, "other4")
+	data, _ := json.Marshal(model.Consent{Ownerid: owner.Id, Consumerid: "H002", Datatype: "BP", Dataaccess: "R"})
+	request, _ := common.BuildRequestWithToken("POST", httpServerTest.URL+CONSENTSAPI, string(data), ownerToken)
+	status, body, _ := common.ExecuteRequest(request)
+	created := model.Consent{}
+	json.Unmarshal(body, &created)
+	if status != http.StatusCreated {
+		t.Fatal("create consent: ", status, " ", string(body))
+	}
+	time.Sleep(TransactionTimeout)
+
+	checkConsentState(t, created.Consentid, "suspended", http.StatusOK)
+	checkConsentState(t, created.Consentid, "active", http.StatusOK)
+	checkStatus(t, "DELETE", CONSENTSAPI+"/"+created.Consentid, ownerToken, http.StatusOK)
+	time.Sleep(TransactionTimeout)
+
+	request, _ = common.BuildRequestWithToken("GET", httpServerTest.URL+CONSENTSAPI+"/"+created.Consentid+"/history", " ", ownerToken)
+	status, body, _ = common.ExecuteRequest(request)
+	events := []model.ConsentEvent{}
+	json.Unmarshal(body, &events)
+	if status != http.StatusOK || len(events) != 4 {
+		t.Fatal("consent history: ", status, " ", string(body))
+	}
+	functions := []string{"PostConsent", "SetConsentState", "SetConsentState", "RemoveConsent"}
+	for i, event := range events {
+		if event.Function != functions[i] || event.Txuuid == "" || event.Timestamp == "" {
+			t.Error("bad event ", i, ": ", event)
+		}
+	}
+	if events[0].Txuuid != created.Consentid || len(events[0].Args) != 7 || events[0].Args[1] != owner.Id {
+		t.Error("bad creation event: ", events[0])
+	}
+	if events[1].Args[2] != "suspended" || events[2].Args[2] != "active" {
+		t.Error("bad state events: ", events[1], events[2])
+	}
+
+	checkStatus(t, "GET", CONSENTSAPI+"/"+created.Consentid+"/history", otherToken, http.StatusForbidden)
+	checkStatus(t, "GET", CONSENTSAPI+"/unknownconsent/history", tokenValue, http.StatusNotFound)
+	checkStatus(t, "GET", CONSENTSAPI+"/"+events[1].Txuuid+"/history", tokenValue, http.StatusNotFound)
+}
+
+func TestIndexDriftNominal(t *testing.T) {
+	_, userToken := registerUser(t, "user5")
+	request, _ := common.BuildRequestWithToken("GET", httpServerTest.URL+INDEXAPI+"/drift", " ", tokenValue)
+	status, body, _ := common.ExecuteRequest(request)
+	drift := hyperledger.Drift{}
+	json.Unmarshal(body, &drift)
+	if status != http.StatusOK || drift.AppID != configuration.ApplicationID || !drift.IsEmpty() {
+		t.Error("index drift: ", status, " ", string(body))
+	}
+	checkStatus(t, "GET", INDEXAPI+"/drift", userToken, http.StatusForbidden)
+}
+
+func TestConsentBatchCheckNominal(t *testing.T) {
+	user, userToken := registerUser(t, "user6")
+	createInApplication(t, configuration.ApplicationID, "B001", "BP", "R", http.StatusCreated)
+	createInApplication(t, configuration.ApplicationID, user.Id, "health", "A", http.StatusCreated)
+	time.Sleep(TransactionTimeout)
+
+	tuples := []model.Consent{
+		{Ownerid: "B001", Consumerid: "R499", Datatype: "BP", Dataaccess: "R"},
+		{Ownerid: "B001", Consumerid: "R499", Datatype: "BP", Dataaccess: "W"},
+		{Ownerid: user.Id, Consumerid: "R499", Datatype: "health/heartrate", Dataaccess: "R"},
+		{Ownerid: "B001", Datatype: "BP", Dataaccess: "R"},
+		{Ownerid: "B001", Consumerid: "R499", Datatype: "BP", Dataaccess: "R", At: "2000-01-01"},
+	}
+	expected := []string{"True", "False", "True", "False", "False"}
+	for i := 0; i < 50; i++ {
+		tuples = append(tuples, tuples[i%3])
+		expected = append(expected, expected[i%3])
+	}
+	logs, _ := authContext.SqlContext.GetLogs()
+	data, _ := json.Marshal(tuples)
+	request, _ := common.BuildRequestWithToken("POST", httpServerTest.URL+CONSENTSAPI+"/check", string(data), tokenValue)
+	status, body, _ := common.ExecuteRequest(request)
+	decisions := []model.ConsentDecision{}
+	json.Unmarshal(body, &decisions)
+	if status != http.StatusOK || len(decisions) != len(tuples) {
+		t.Fatal("batch check: ", status, " ", string(body))
+	}
+	// One decision per owner, the token check is not logged
+	logsAfter, _ := authContext.SqlContext.GetLogs()
+	if len(logsAfter)-len(logs) != 2 {
+		t.Error("batch check logged ", len(logsAfter)-len(logs), " decisions for 2 owners")
+	}
+	for i, decision := range decisions {
+		if decision.Consent != expected[i] || decision.Ownerid != tuples[i].Ownerid || decision.Consent == "True" && decision.Consentid == "" {
+			t.Error("bad decision ", i, ": ", decision)
+		}
+	}
+	if decisions[3].Code != common.VALIDATION_ERROR {
+		t.Error("tuple without consumer evaluated: ", decisions[3])
+	}
+
+	data, _ = json.Marshal(tuples[:3])
+	request, _ = common.BuildRequestWithToken("POST", httpServerTest.URL+CONSENTSAPI+"/check", string(data), userToken)
+	_, body, _ = common.ExecuteRequest(request)
+	json.Unmarshal(body, &decisions)
+	if len(decisions) != 3 || decisions[0].Code != common.FORBIDDEN || decisions[2].Consent != "True" {
+		t.Error("batch check as owner: ", string(body))
+	}
+	checkErrorBody(t, "POST", CONSENTSAPI+"/check", "{\"Ownerid\":\"B001\"}", tokenValue, http.StatusBadRequest, common.VALIDATION_ERROR)
+}
+
+func TestConsentBatchWriteNominal(t *testing.T) {
+	consents := []model.Consent{
+		{Ownerid: "W001", Consumerid: "W002", Datatype: "BP", Dataaccess: "R"},
+		{Ownerid: "W001", Datatype: "BP", Dataaccess: "R"},
+		{Ownerid: "W001", Consumerid: "W003", Datatype: "BP", Dataaccess: "R", Dt_begin: "2017-12-31", Dt_end: "2017-01-01"},
+	}
+	for i := 0; i < 20; i++ {
+		consents = append(consents, model.Consent{Ownerid: "W001", Consumerid: "W1" + strconv.Itoa(i), Datatype: "BP", Dataaccess: "R"})
+	}
+	data, _ := json.Marshal(consents)
+	response := postBatch(t, CONSENTSAPI+"/batch", string(data), "")
+	if response.Succeeded != 21 || response.Failed != 2 || len(response.Results) != 23 {
+		t.Fatal("batch create: ", response)
+	}
+	if response.Results[1].Code != common.VALIDATION_ERROR || response.Results[2].Status != http.StatusBadRequest || response.Results[0].Txuuid == "" || response.Results[0].Status != http.StatusCreated {
+		t.Error("bad results: ", response.Results[:3])
+	}
+	time.Sleep(TransactionTimeout)
+
+	lines := ""
+	for _, result := range response.Results[3:] {
+		lines += "{\"Consentid\":\"" + result.Consentid + "\"}\n"
+	}
+	lines += "{\"Consentid\":\"unknownconsent\"}\n"
+	response = postBatch(t, CONSENTSAPI+"/batch/revoke", lines, NDJSON_CONTENT_TYPE)
+	if response.Succeeded != 20 || response.Failed != 1 || response.Results[20].Status != http.StatusNotFound || response.Results[0].Txuuid == "" {
+		t.Error("batch revoke: ", response)
+	}
+	time.Sleep(TransactionTimeout)
+	request, _ := common.BuildRequestWithToken("GET", httpServerTest.URL+CONSENTSAPI+"?owner=W001", " ", tokenValue)
+	_, body, _ := common.ExecuteRequest(request)
+	listed := []model.Consent{}
+	json.Unmarshal(body, &listed)
+	if len(listed) != 1 || listed[0].Consumerid != "W002" {
+		t.Error("consents left after the batch revoke: ", string(body))
+	}
+	checkErrorBody(t, "POST", CONSENTSAPI+"/batch", "{\"Ownerid\":\"W001\"}\n{bad", tokenValue, http.StatusBadRequest, common.VALIDATION_ERROR)
+}
+
+func postBatch(t *testing.T, uri, data, contentType string) model.BatchResponse {
+	request, _ := common.BuildRequestWithToken("POST", httpServerTest.URL+uri, data, tokenValue)
+	if contentType != "" {
+		request.Header.Set("Content-Type", contentType)
+	}
+	status, body, _ := common.ExecuteRequest(request)
+	response := model.BatchResponse{}
+	json.Unmarshal(body, &response)
+	if status != http.StatusOK {
+		t.Error("batch ", uri, " : ", status, " ", string(body))
+	}
+	return response
+}
+
+func TestConsentPaginationNominal(t *testing.T) {
+	for i := 1; i <= 7; i++ {
+		createWithDates(t, "P001", common.GetStringDateNow(time.Duration(-i)), common.GetStringDateNow(time.Duration(10-i)), http.StatusCreated)
+	}
+	createInApplication(t, configuration.ApplicationID, "P001", "health/heartrate", "W", http.StatusCreated)
+	time.Sleep(TransactionTimeout)
+
+	seen := make(map[string]bool)
+	previous := ""
+	cursor := ""
+	for pages := 1; ; pages++ {
+		page := getPage(t, "?owner=P001&datatype=BP&sort=dt_begin&limit=3&cursor="+cursor)
+		if page.Total != 7 || len(page.Consents) > 3 {
+			t.Fatal("bad page: ", page)
+		}
+		for _, consent := range page.Consents {
+			if seen[consent.Consentid] || consent.Dt_begin < previous || consent.Datatype != "BP" {
+				t.Error("bad consent order: ", consent.Dt_begin, " after ", previous)
+			}
+			seen[consent.Consentid] = true
+			previous = consent.Dt_begin
+		}
+		cursor = page.NextCursor
+		if cursor == "" {
+			if pages != 3 || len(seen) != 7 {
+				t.Error("pages: ", pages, " consents: ", len(seen))
+			}
+			break
+		}
+	}
+
+	page := getPage(t, "?owner=P001&sort=-dt_end&limit=2")
+	if page.Total != 8 || len(page.Consents) != 2 || page.Consents[0].Dt_end != "2099-01-01" || page.Consents[1].Dt_end != common.GetStringDateNow(9) {
+		t.Error("descending page: ", page)
+	}
+	page = getPage(t, "?owner=P001&datatype=health&dataaccess=W&limit=10")
+	if page.Total != 1 || page.NextCursor != "" {
+		t.Error("datatype filter: ", page)
+	}
+	page = getPage(t, "?owner=P001&datatype=BP&to="+common.GetStringDateNow(-5)+"&from="+common.GetStringDateNow(4)+"&limit=10")
+	if page.Total != 2 {
+		t.Error("date range filter: ", page)
+	}
+	checkStatus(t, "GET", CONSENTSAPI+"?owner=P001&limit=0", tokenValue, http.StatusBadRequest)
+	checkStatus(t, "GET", CONSENTSAPI+"?owner=P001&limit=2&cursor=bad", tokenValue, http.StatusBadRequest)
+	checkStatus(t, "GET", CONSENTSAPI+"?owner=P001&sort=owner", tokenValue, http.StatusBadRequest)
+
+	data, _ := json.Marshal(model.Consent{Action: "list4owner", Ownerid: "P001", Datatype: "BP", Sort: "dt_begin", Limit: 5})
+	request, _ := common.BuildRequestWithToken("POST", httpServerTest.URL+CONSENTAPI, string(data), tokenValue)
+	_, body, _ := common.ExecuteRequest(request)
+	page = model.ConsentPage{}
+	json.Unmarshal(body, &page)
+	if page.Total != 7 || len(page.Consents) != 5 || page.NextCursor == "" {
+		t.Error("v1 page: ", string(body))
+	}
+}
+
+func getPage(t *testing.T, query string) model.ConsentPage {
+	request, _ := common.BuildRequestWithToken("GET", httpServerTest.URL+CONSENTSAPI+query, " ", tokenValue)
+	status, body, _ := common.ExecuteRequest(request)
+	page := model.ConsentPage{}
+	json.Unmarshal(body, &page)
+	if status != http.StatusOK {
+		t.Error("list ", query, " : ", status, " ", string(body))
+	}
+	return page
+}
+
+func TestTransactionStatusNominal(t *testing.T) {
+	data, _ := json.Marshal(model.Consent{Ownerid: "T001", Consumerid: "T002", Datatype: "BP", Dataaccess: "R"})
+	request, _ := common.BuildRequestWithToken("POST", httpServerTest.URL+CONSENTSAPI+"?wait=true", string(data), tokenValue)
+	status, body, _ := common.ExecuteRequest(request)
+	created := model.Consent{}
+	json.Unmarshal(body, &created)
+	if status != http.StatusCreated || created.Consentid == "" {
+		t.Fatal("create consent with wait: ", status, " ", string(body))
+	}
+	checkTransactionStatus(t, created.Consentid, hyperledger.TX_COMMITTED, "PostConsent")
+
+	sqlContext := model.SqlContext{Db: authContext.SqlContext.Db}
+	sqlContext.AddConsentTransaction(model.ConsentTransaction{Consentid: "T003", Txuuid: "pendingtx", Appid: configuration.ApplicationID, Function: "PostConsent", CreatedAt: time.Now()})
+	sqlContext.AddConsentTransaction(model.ConsentTransaction{Consentid: "T004", Txuuid: "failedtx", Appid: configuration.ApplicationID, Function: "PostConsent", CreatedAt: time.Now().Add(-time.Hour)})
+	checkTransactionStatus(t, "pendingtx", hyperledger.TX_PENDING, "PostConsent")
+	transaction := checkTransactionStatus(t, "failedtx", hyperledger.TX_FAILED, "PostConsent")
+	if transaction.Note != TX_FAILED_NOTE {
+		t.Error("failed status not noted as a heuristic: ", transaction.Note)
+	}
+	checkStatus(t, "GET", TRANSACTIONSAPI+"/unknowntx", tokenValue, http.StatusNotFound)
+}
+
+func checkTransactionStatus(t *testing.T, tr_uuid, expected, function string) model.TransactionStatus {
+	request, _ := common.BuildRequestWithToken("GET", httpServerTest.URL+TRANSACTIONSAPI+"/"+tr_uuid, " ", tokenValue)
+	status, body, _ := common.ExecuteRequest(request)
+	transaction := model.TransactionStatus{}
+	json.Unmarshal(body, &transaction)
+	if status != http.StatusOK || transaction.Status != expected || transaction.Function != function || transaction.Txuuid != tr_uuid {
+		t.Error("transaction ", tr_uuid, " status: ", status, " ", string(body))
+	}
+	return transaction
+}
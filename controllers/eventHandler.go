@@ -0,0 +1,367 @@
+/*
+Copyright Pascal Limeux. 2017 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pascallimeux/ocms2/hyperledger"
+	"github.com/pascallimeux/ocms2/model"
+	authmodel "github.com/pascallimeux/ocms2/modules/auth/model"
+	"github.com/pascallimeux/ocms2/modules/common"
+	"github.com/pascallimeux/ocms2/modules/log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	EVENT_CONSENT_CREATED       = "consent.created"
+	EVENT_CONSENT_REVOKED       = "consent.revoked"
+	EVENT_CONSENT_EXPIRED       = "consent.expired"
+	EVENT_CONSENT_STATE_CHANGED = "consent.state_changed"
+	SSE_CONTENT_TYPE            = "text/event-stream"
+	SSE_BUFFER_SIZE             = 100 // events queued for a client before it is disconnected
+	SSE_REPLAY_BATCH            = 500
+	SSE_HEARTBEAT               = 15 * time.Second
+	EVENT_PURGE_INTERVAL        = time.Hour
+	LAST_EVENT_ID               = "Last-Event-ID"
+	LAST_EVENT_ID_QUERY         = "lastEventId"
+)
+
+var consentEvents = []string{EVENT_CONSENT_CREATED, EVENT_CONSENT_REVOKED, EVENT_CONSENT_EXPIRED, EVENT_CONSENT_STATE_CHANGED}
+
+// Log the consent events and fan them out to the SSE clients.
+// A client too slow to follow is disconnected, it resumes from the log with its Last-Event-ID.
+// Each Heartbeat a client is sent a keepalive, it is disconnected once its token expired or its session was revoked.
+type Event_Broker struct {
+	SqlContext  model.SqlContext
+	Heartbeat   time.Duration
+	mutex       sync.Mutex
+	subscribers map[chan model.WebhookEvent]bool
+}
+
+// Events streamed to a client
+type eventFilter struct {
+	Appid      string
+	Ownerid    string
+	Consumerid string
+	Datatype   string
+	Types      []string
+}
+
+func NewEventBroker(sqlContext model.SqlContext) *Event_Broker {
+	log.Trace(log.Here(), "NewEventBroker() : calling method -")
+	return &Event_Broker{SqlContext: sqlContext, Heartbeat: SSE_HEARTBEAT, subscribers: make(map[chan model.WebhookEvent]bool)}
+}
+
+// Append the event to the log and send it to the clients, the lock keeps the clients in the log order
+func (b *Event_Broker) Publish(event model.WebhookEvent) model.WebhookEvent {
+	log.Trace(log.Here(), "Publish(", event.Type, ", ", event.Consentid, ") : calling method -")
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	seq, err := b.SqlContext.AddConsentEvent(event)
+	if err != nil {
+		log.Error(log.Here(), "could not log event ", event.Id, ": ", err.Error())
+	}
+	event.Seq = seq
+	for subscriber := range b.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+			delete(b.subscribers, subscriber)
+			close(subscriber)
+		}
+	}
+	return event
+}
+
+func (b *Event_Broker) Subscribe() chan model.WebhookEvent {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	subscriber := make(chan model.WebhookEvent, SSE_BUFFER_SIZE)
+	b.subscribers[subscriber] = true
+	return subscriber
+}
+
+func (b *Event_Broker) Unsubscribe(subscriber chan model.WebhookEvent) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.subscribers[subscriber] {
+		delete(b.subscribers, subscriber)
+		close(subscriber)
+	}
+}
+
+//HTTP Get - /ocms/v2/events?owner=&consumer=&datatype=&type=
+func (a *AppContext) getEvents(w http.ResponseWriter, r *http.Request) {
+	log.Trace(log.Here(), "getEvents() : calling method -")
+
+	r, err1 := a.authorizeCaller(w, r)
+	if err1 != nil {
+		return
+	}
+
+	query := r.URL.Query()
+	filter := eventFilter{Appid: a.getApplicationID(r, ""), Ownerid: query.Get("owner"), Consumerid: query.Get("consumer"), Datatype: query.Get("datatype"), Types: query["type"]}
+	message := fmt.Sprintf("getEvents(applicationID=%s, query=%s) : calling method -", filter.Appid, r.URL.RawQuery)
+	log.Info(log.Here(), message)
+	after, resume, err := lastEventID(r)
+	for _, eventType := range filter.Types {
+		if err == nil && !isConsentEvent(eventType) {
+			err = common.NewValidationError("unknown event: " + eventType)
+		}
+	}
+	if err == nil && a.Events == nil {
+		err = common.NewNotFoundError("event stream is not enabled")
+	}
+	if err == nil {
+		err = a.checkListPermission(r, filter.Appid, filter.Ownerid, filter.Consumerid)
+	}
+	if err != nil {
+		common.SendError(log.Here(), w, err)
+		return
+	}
+	a.streamEvents(w, r, filter, after, resume)
+}
+
+// A resuming client first gets the logged events following its Last-Event-ID, a new one only the live events.
+// The client subscribes before reading the log so no event is lost in between.
+func (a *AppContext) streamEvents(w http.ResponseWriter, r *http.Request, filter eventFilter, after int64, resume bool) {
+	log.Trace(log.Here(), "streamEvents() : calling method -")
+	caller, err1 := a.requestCaller(r)
+	if err1 != nil {
+		common.SendError(log.Here(), w, err1)
+		return
+	}
+	events := a.Events.Subscribe()
+	defer a.Events.Unsubscribe(events)
+	if !resume {
+		var err error
+		after, err = a.SqlContext.GetLastConsentEventSeq()
+		if err != nil {
+			common.SendError(log.Here(), w, err)
+			return
+		}
+	}
+
+	// The stream outlives the server write timeout
+	controller := http.NewResponseController(w)
+	controller.SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", SSE_CONTENT_TYPE)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	for resume {
+		logged, err := a.SqlContext.GetConsentEvents(filter.Appid, filter.Ownerid, filter.Consumerid, after, SSE_REPLAY_BATCH)
+		if err != nil {
+			log.Error(log.Here(), "event log: ", err.Error())
+			return
+		}
+		for _, event := range logged {
+			if filter.match(event) && writeEvent(w, event) != nil {
+				return
+			}
+			after = event.Seq
+		}
+		resume = len(logged) == SSE_REPLAY_BATCH
+	}
+	if controller.Flush() != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(a.Events.Heartbeat)
+	defer heartbeat.Stop()
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				log.Warning(log.Here(), "event stream client too slow, disconnected")
+				return
+			}
+			if (event.Seq != 0 && event.Seq <= after) || !filter.match(event) {
+				continue
+			}
+			err = writeEvent(w, event)
+			if event.Seq != 0 {
+				after = event.Seq
+			}
+		case <-heartbeat.C:
+			if a.sessionEnded(caller.claims) {
+				log.Info(log.Here(), "event stream of ", caller.claims.Username, " closed, its token expired or was revoked")
+				return
+			}
+			_, err = fmt.Fprint(w, ": keepalive\n\n")
+		}
+		if err == nil {
+			err = controller.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// True once the token of a streaming client expired or its session was revoked
+func (a *AppContext) sessionEnded(claims authmodel.Claims) bool {
+	return time.Now().Unix() >= claims.ExpiresAt || a.AuthContext.SqlContext.IsRevokedSession(claims.Session)
+}
+
+// An event without rank was not logged, it is sent without id
+func writeEvent(w http.ResponseWriter, event model.WebhookEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if event.Seq != 0 {
+		_, err = fmt.Fprintf(w, "id: %d\n", event.Seq)
+	}
+	if err == nil {
+		_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+	}
+	return err
+}
+
+// Rank of the last event received by a reconnecting client, false for a new client
+func lastEventID(r *http.Request) (int64, bool, error) {
+	value := r.Header.Get(LAST_EVENT_ID)
+	if value == "" {
+		value = r.URL.Query().Get(LAST_EVENT_ID_QUERY)
+	}
+	if value == "" {
+		return 0, false, nil
+	}
+	seq, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || seq < 0 {
+		return 0, false, common.NewValidationError("bad last event id: " + value)
+	}
+	return seq, true, nil
+}
+
+func isConsentEvent(eventType string) bool {
+	for _, consentEvent := range consentEvents {
+		if consentEvent == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *eventFilter) match(event model.WebhookEvent) bool {
+	if event.Appid != f.Appid {
+		return false
+	}
+	if f.Ownerid != "" && event.Consent.Ownerid != f.Ownerid {
+		return false
+	}
+	if f.Consumerid != "" && event.Consent.Consumerid != f.Consumerid {
+		return false
+	}
+	if f.Datatype != "" && event.Consent.Datatype != f.Datatype && !strings.HasPrefix(event.Consent.Datatype, f.Datatype+DATATYPE_SEPARATOR) {
+		return false
+	}
+	if len(f.Types) == 0 {
+		return true
+	}
+	for _, eventType := range f.Types {
+		if eventType == event.Type {
+			return true
+		}
+	}
+	return false
+}
+
+// Log and send a consent change to the SSE clients and the webhooks
+func (a *AppContext) publishConsentEvent(eventType string, consent model.Consent, tr_uuid string) {
+	log.Trace(log.Here(), "publishConsentEvent(", eventType, ", ", consent.Consentid, ") : calling method -")
+	event := model.WebhookEvent{Id: common.Generate_uuid(), Type: eventType, Appid: consent.Appid, Consentid: consent.Consentid, Txuuid: tr_uuid, OccurredAt: time.Now().Format(time.RFC3339Nano), Consent: consent}
+	if a.Events != nil {
+		event = a.Events.Publish(event)
+	}
+	if a.Webhooks != nil {
+		a.Webhooks.Publish(event)
+	}
+}
+
+// Drop the events logged before the retention each EVENT_PURGE_INTERVAL until stop is closed,
+// a client resuming from a dropped event gets the events still logged
+func (a *AppContext) EventPurger(retention time.Duration, stop <-chan struct{}) {
+	log.Trace(log.Here(), "EventPurger() : calling method -")
+	ticker := time.NewTicker(EVENT_PURGE_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			purged, err := a.SqlContext.PurgeConsentEvents(time.Now().Add(-retention))
+			if err != nil {
+				log.Error(log.Here(), "event purge: ", err.Error())
+			} else if purged > 0 {
+				log.Info(log.Here(), strconv.FormatInt(purged, 10), " consent events purged")
+			}
+		}
+	}
+}
+
+// Publish the consent.expired event of the active consents whose validity ended, once per consent
+func (a *AppContext) ExpirySweeper(interval time.Duration, stop <-chan struct{}) {
+	log.Trace(log.Here(), "ExpirySweeper() : calling method -")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			a.sweepExpiredConsents()
+		}
+	}
+}
+
+func (a *AppContext) sweepExpiredConsents() {
+	log.Trace(log.Here(), "sweepExpiredConsents() : calling method -")
+	applications, err := a.AuthContext.SqlContext.GetApplications()
+	if err != nil {
+		log.Error(log.Here(), "expiry sweep: ", err.Error())
+		return
+	}
+	now := time.Now()
+	for _, application := range applications {
+		consents, err1 := a.Consent_helper.GetActivesConsents(application.Id)
+		if err1 != nil {
+			log.Error(log.Here(), "expiry sweep of ", application.Id, ": ", err1.Error())
+			continue
+		}
+		for _, consent := range consents {
+			if consent.EffectiveState(now) != hyperledger.STATE_EXPIRED {
+				continue
+			}
+			first, err2 := a.SqlContext.AddConsentExpiration(application.Id, consent.ConsentID)
+			if err2 != nil {
+				log.Error(log.Here(), "expiry sweep of ", consent.ConsentID, ": ", err2.Error())
+				continue
+			}
+			if first {
+				a.publishConsentEvent(EVENT_CONSENT_EXPIRED, convertHPConsent2APIConsent(consent), "")
+			}
+		}
+	}
+}
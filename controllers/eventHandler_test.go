@@ -0,0 +1,160 @@
+/*
+Copyright Pascal Limeux. 2017 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"github.com/pascallimeux/ocms2/model"
+	authcontrollers "github.com/pascallimeux/ocms2/modules/auth/controllers"
+	"github.com/pascallimeux/ocms2/modules/common"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Event read from a stream
+type streamedEvent struct {
+	id    string
+	event model.WebhookEvent
+}
+
+// Open an event stream, the events are read until the test cancels the stream
+func openEventStream(t *testing.T, query, lastEventID string) (chan streamedEvent, context.CancelFunc) {
+	return openEventStreamWithToken(t, query, lastEventID, tokenValue)
+}
+
+func openEventStreamWithToken(t *testing.T, query, lastEventID, token string) (chan streamedEvent, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	request, _ := common.BuildRequestWithToken("GET", httpServerTest.URL+EVENTSAPI+query, " ", token)
+	if lastEventID != "" {
+		request.Header.Set(LAST_EVENT_ID, lastEventID)
+	}
+	response, err := http.DefaultClient.Do(request.WithContext(ctx))
+	if err != nil || response.StatusCode != http.StatusOK || response.Header.Get("Content-Type") != SSE_CONTENT_TYPE {
+		cancel()
+		t.Fatal("open event stream: ", response, err)
+	}
+	events := make(chan streamedEvent, 10)
+	go func() {
+		defer response.Body.Close()
+		defer close(events)
+		scanner := bufio.NewScanner(response.Body)
+		streamed := streamedEvent{}
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "id: "):
+				streamed.id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "data: "):
+				json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &streamed.event)
+			case line == "" && streamed.event.Id != "":
+				events <- streamed
+				streamed = streamedEvent{}
+			}
+		}
+	}()
+	return events, cancel
+}
+
+func nextEvent(t *testing.T, events chan streamedEvent) streamedEvent {
+	select {
+	case streamed := <-events:
+		return streamed
+	case <-time.After(2 * time.Second):
+		t.Fatal("no event streamed")
+	}
+	return streamedEvent{}
+}
+
+func TestEventStreamNominal(t *testing.T) {
+	events, cancel := openEventStream(t, "?owner=E001", "")
+	defer cancel()
+
+	consentID := createAndGetID(t, "E001")
+	createAndGetID(t, "E002")
+	checkStatus(t, "DELETE", CONSENTSAPI+"/"+consentID, tokenValue, http.StatusOK)
+
+	created := nextEvent(t, events)
+	revoked := nextEvent(t, events)
+	if created.event.Type != EVENT_CONSENT_CREATED || created.event.Consentid != consentID || created.event.Consent.Ownerid != "E001" {
+		t.Error("bad created event: ", created)
+	}
+	if revoked.event.Type != EVENT_CONSENT_REVOKED || revoked.event.Consentid != consentID || revoked.event.Consent.State != "revoked" {
+		t.Error("bad revoked event: ", revoked)
+	}
+	createdID, _ := strconv.ParseInt(created.id, 10, 64)
+	revokedID, _ := strconv.ParseInt(revoked.id, 10, 64)
+	if createdID == 0 || revokedID <= createdID {
+		t.Error("bad event ids: ", created.id, " ", revoked.id)
+	}
+
+	// A reconnecting client gets the events it missed from the log
+	resumed, cancel2 := openEventStream(t, "?owner=E001&type="+EVENT_CONSENT_REVOKED, strconv.FormatInt(createdID-1, 10))
+	defer cancel2()
+	replayed := nextEvent(t, resumed)
+	if replayed.id != revoked.id || replayed.event.Id != revoked.event.Id {
+		t.Error("bad replayed event: ", replayed, " expected ", revoked)
+	}
+}
+
+func TestEventStreamValidation(t *testing.T) {
+	_, userToken := registerUser(t, "user8")
+	checkStatus(t, "GET", EVENTSAPI, userToken, http.StatusForbidden)
+	checkStatus(t, "GET", EVENTSAPI+"?owner=E001", userToken, http.StatusForbidden)
+	checkStatus(t, "GET", EVENTSAPI+"?type=consent.deleted", tokenValue, http.StatusBadRequest)
+	checkStatus(t, "GET", EVENTSAPI+"?"+LAST_EVENT_ID_QUERY+"=last", tokenValue, http.StatusBadRequest)
+}
+
+func createAndGetID(t *testing.T, ownerID string) string {
+	data, _ := json.Marshal(model.Consent{Ownerid: ownerID, Consumerid: "E100", Datatype: "BP", Dataaccess: "R"})
+	request, _ := common.BuildRequestWithToken("POST", httpServerTest.URL+CONSENTSAPI, string(data), tokenValue)
+	status, body, _ := common.ExecuteRequest(request)
+	created := model.Consent{}
+	json.Unmarshal(body, &created)
+	if status != http.StatusCreated {
+		t.Fatal("create consent: ", status, " ", string(body))
+	}
+	return created.Consentid
+}
+
+func TestEventStreamRevokedSession(t *testing.T) {
+	user, token := registerUser(t, "stream1")
+	events, cancel := openEventStreamWithToken(t, "?owner="+user.Id, "", token)
+	defer cancel()
+	checkStatus(t, "POST", authcontrollers.LOGOUTURI, token, http.StatusNoContent)
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Error("event streamed after the logout")
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("event stream not closed after the logout")
+	}
+}
+
+func TestEventPurge(t *testing.T) {
+	appContext.publishConsentEvent(EVENT_CONSENT_CREATED, model.Consent{Appid: configuration.ApplicationID, Consentid: "P001", Ownerid: "E009"}, "")
+	purged, err := appContext.SqlContext.PurgeConsentEvents(time.Now().Add(time.Second))
+	if err != nil || purged == 0 {
+		t.Fatal("no event purged: ", purged, err)
+	}
+	logged, _ := appContext.SqlContext.GetConsentEvents(configuration.ApplicationID, "E009", "", 0, SSE_REPLAY_BATCH)
+	if len(logged) != 0 {
+		t.Error("purged events still logged: ", logged)
+	}
+}
@@ -0,0 +1,124 @@
+/*
+Copyright Pascal Limeux. 2017 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/pascallimeux/ocms2/hyperledger"
+	"github.com/pascallimeux/ocms2/model"
+	"github.com/pascallimeux/ocms2/modules/common"
+	"github.com/pascallimeux/ocms2/modules/log"
+	"net/http"
+	"sort"
+	"time"
+)
+
+//HTTP Get - /ocms/v2/consents/{id}/history
+func (a *AppContext) getConsentHistory(w http.ResponseWriter, r *http.Request) {
+	log.Trace(log.Here(), "getConsentHistory() : calling method -")
+
+	r, err1 := a.authorizeCaller(w, r)
+	if err1 != nil {
+		return
+	}
+
+	vars := mux.Vars(r)
+	bytes, err := a.consentHistory(r, a.getApplicationID(r, ""), vars["id"])
+	if err != nil {
+		common.SendError(log.Here(), w, err)
+		return
+	}
+	sendBytes(w, http.StatusOK, bytes)
+}
+
+// The consent ID is the ID of its creation transaction, the following ones come from the local index
+func (a *AppContext) consentHistory(r *http.Request, applicationID, consentID string) ([]byte, error) {
+	message := fmt.Sprintf("consentHistory(applicationID=%s, consentID=%s) : calling method -", applicationID, consentID)
+	log.Info(log.Here(), message)
+	creation, err := a.Consent_helper.GetTransaction(consentID)
+	if err != nil {
+		return nil, err
+	}
+	if creation.Txid == "" {
+		return nil, common.NewNotFoundError("consent " + consentID + " not found")
+	}
+	invocation, err := creation.GetConsentInvocation()
+	if err != nil {
+		if common.ToHttpError(err).Status == http.StatusBadRequest {
+			return nil, common.NewNotFoundError("consent " + consentID + " not found")
+		}
+		return nil, err
+	}
+	if invocation.Args[0] != applicationID {
+		return nil, common.NewNotFoundError("consent " + consentID + " not found")
+	}
+	err = a.checkConsentPermission(r, applicationID, "getConsentHistory", invocation.Args[1], invocation.Args[2])
+	if err != nil {
+		return nil, err
+	}
+	consentTransactions, err := a.SqlContext.GetConsentTransactions(consentID)
+	if err != nil {
+		return nil, err
+	}
+	type entry struct {
+		date  time.Time
+		event model.ConsentEvent
+	}
+	entries := []entry{{creation.Timestamp.Time(), buildConsentEvent(creation, invocation)}}
+	for _, consentTransaction := range consentTransactions {
+		if consentTransaction.Txuuid == consentID {
+			continue
+		}
+		transaction, err1 := a.Consent_helper.GetTransaction(consentTransaction.Txuuid)
+		if err1 != nil {
+			return nil, err1
+		}
+		if transaction.Txid == "" {
+			log.Warning(log.Here(), "transaction ", consentTransaction.Txuuid, " of consent ", consentID, " is not on the ledger")
+			continue
+		}
+		transactionInvocation, err2 := transaction.GetInvocation()
+		if err2 != nil {
+			return nil, err2
+		}
+		entries = append(entries, entry{transaction.Timestamp.Time(), buildConsentEvent(transaction, transactionInvocation)})
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].date.Before(entries[j].date) })
+	events := make([]model.ConsentEvent, len(entries))
+	for i, e := range entries {
+		events[i] = e.event
+	}
+	return json.Marshal(events)
+}
+
+func buildConsentEvent(transaction hyperledger.Transaction, invocation hyperledger.Invocation) model.ConsentEvent {
+	signer, err := transaction.GetSigner()
+	if err != nil {
+		log.Warning(log.Here(), "unreadable certificate for transaction ", transaction.Txid, ": ", err.Error())
+	}
+	timestamp := transaction.Timestamp.Time().UTC().Format(time.RFC3339Nano)
+	return model.ConsentEvent{Txuuid: transaction.Txid, Timestamp: timestamp, Function: invocation.Function, Signer: signer, Args: invocation.Args}
+}
+
+// Index a transaction which touched a consent, the ledger stays the reference so a failure is only logged
+func (a *AppContext) recordTransaction(applicationID, consentID, tr_uuid, function string) {
+	log.Trace(log.Here(), "recordTransaction() : calling method -")
+	consentTransaction := model.ConsentTransaction{Consentid: consentID, Txuuid: tr_uuid, Appid: applicationID, Function: function, CreatedAt: time.Now()}
+	err := a.SqlContext.AddConsentTransaction(consentTransaction)
+	if err != nil {
+		log.Error(log.Here(), "could not index transaction ", tr_uuid, " of consent ", consentID, ": ", err.Error())
+	}
+}
@@ -0,0 +1,58 @@
+/*
+Copyright Pascal Limeux. 2017 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pascallimeux/ocms2/hyperledger"
+	"github.com/pascallimeux/ocms2/modules/common"
+	"github.com/pascallimeux/ocms2/modules/log"
+	"net/http"
+)
+
+//HTTP Get - /ocms/v2/index/drift?appid=&repair=true
+func (a *AppContext) getIndexDrift(w http.ResponseWriter, r *http.Request) {
+	log.Trace(log.Here(), "getIndexDrift() : calling method -")
+
+	r, err1 := a.authorizeCaller(w, r)
+	if err1 != nil {
+		return
+	}
+
+	bytes, err := a.indexDrift(r, a.getApplicationID(r, ""), r.URL.Query().Get("repair") == "true")
+	if err != nil {
+		common.SendError(log.Here(), w, err)
+		return
+	}
+	sendBytes(w, http.StatusOK, bytes)
+}
+
+func (a *AppContext) indexDrift(r *http.Request, applicationID string, repair bool) ([]byte, error) {
+	message := fmt.Sprintf("indexDrift(applicationID=%s, repair=%t) : calling method -", applicationID, repair)
+	log.Info(log.Here(), message)
+	err := a.checkConsentPermission(r, applicationID, "getIndexDrift")
+	if err != nil {
+		return nil, err
+	}
+	consentIndex, ok := a.Consent_helper.(*hyperledger.Index_Ledger)
+	if !ok {
+		return nil, common.NewNotImplementedError("consent index disabled, set index.staleness to enable it")
+	}
+	drift, err := consentIndex.Reconcile(applicationID, repair)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(drift)
+}
@@ -0,0 +1,252 @@
+/*
+Copyright Pascal Limeux. 2017 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	b64 "encoding/base64"
+	"encoding/json"
+	"github.com/pascallimeux/ocms2/hyperledger"
+	"github.com/pascallimeux/ocms2/model"
+	"github.com/pascallimeux/ocms2/modules/common"
+	"github.com/pascallimeux/ocms2/modules/log"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	MAX_PAGE_SIZE    = 1000
+	SORT_CONSENTID   = "consentid"
+	SORT_DT_BEGIN    = "dt_begin"
+	SORT_DT_END      = "dt_end"
+	SORT_DESCENDING  = "-"
+	CURSOR_SEPARATOR = "|"
+	SORT_KEY_FORMAT  = "2006-01-02T15:04:05.000000000"
+)
+
+// Filters, sort and page of a consent listing.
+// Without limit nor cursor the whole listing is sent as an array, else as a model.ConsentPage.
+type listOptions struct {
+	State      string // empty with AllStates
+	AllStates  bool
+	Datatype   string
+	Dataaccess string
+	From       time.Time // validity window ends after From
+	To         time.Time // validity window begins before To
+	Sort       string
+	Descending bool
+	Limit      int
+	Cursor     string
+	Paged      bool
+}
+
+// Consent of a listing with its sort key, computed once before sorting
+type keyedConsent struct {
+	key     string
+	consent hyperledger.Consent
+}
+
+// Listing options of the v2 query string
+func parseListOptions(query url.Values) (listOptions, error) {
+	consent := model.Consent{State: query.Get("state"), Datatype: query.Get("datatype"), Dataaccess: query.Get("dataaccess"), From: query.Get("from"), To: query.Get("to"), Sort: query.Get("sort"), Cursor: query.Get("cursor")}
+	if query.Get("include_inactive") != "" {
+		includeInactive, err := strconv.ParseBool(query.Get("include_inactive"))
+		if err != nil {
+			return listOptions{}, common.NewValidationError("include_inactive is not a boolean: " + query.Get("include_inactive"))
+		}
+		consent.Include_inactive = includeInactive
+	}
+	if query.Get("limit") != "" {
+		limit, err := strconv.Atoi(query.Get("limit"))
+		if err != nil || limit <= 0 {
+			return listOptions{}, common.NewValidationError("limit is not a positive number: " + query.Get("limit"))
+		}
+		consent.Limit = limit
+	}
+	return buildListOptions(consent)
+}
+
+// Listing options of a v1 request
+func buildListOptions(consent model.Consent) (listOptions, error) {
+	options := listOptions{State: consent.State, Datatype: consent.Datatype, Dataaccess: consent.Dataaccess, Limit: consent.Limit, Cursor: consent.Cursor}
+	options.AllStates = consent.Include_inactive && options.State == ""
+	if options.State == "" && !options.AllStates {
+		options.State = hyperledger.STATE_ACTIVE
+	}
+	if !options.AllStates && !hyperledger.IsState(options.State) {
+		return options, common.NewValidationError("unknown state: " + options.State)
+	}
+	var err error
+	if consent.From != "" {
+		options.From, err = common.DateTimeParse(consent.From)
+		if err != nil {
+			return options, common.NewValidationError("from is not a date (2006-01-02) nor a RFC 3339 timestamp: " + consent.From)
+		}
+	}
+	if consent.To != "" {
+		options.To, err = common.DateTimeParse(consent.To)
+		if err != nil {
+			return options, common.NewValidationError("to is not a date (2006-01-02) nor a RFC 3339 timestamp: " + consent.To)
+		}
+	}
+	options.Sort = strings.TrimPrefix(consent.Sort, SORT_DESCENDING)
+	options.Descending = strings.HasPrefix(consent.Sort, SORT_DESCENDING)
+	switch options.Sort {
+	case "":
+		options.Sort = SORT_CONSENTID
+	case SORT_CONSENTID, SORT_DT_BEGIN, SORT_DT_END:
+	default:
+		return options, common.NewValidationError("unknown sort: " + consent.Sort + ", expected dt_begin, dt_end or consentid, - for a descending order")
+	}
+	if options.Limit < 0 || options.Limit > MAX_PAGE_SIZE {
+		return options, common.NewValidationError("limit must be between 1 and " + strconv.Itoa(MAX_PAGE_SIZE))
+	}
+	options.Paged = options.Limit > 0 || options.Cursor != ""
+	if options.Paged && options.Limit == 0 {
+		options.Limit = MAX_PAGE_SIZE
+	}
+	return options, nil
+}
+
+// Read, filter, sort and page the consents of an application, of an owner or of a consumer
+func (a *AppContext) listConsentPage(applicationID, ownerID, consumerID string, options listOptions) ([]byte, error) {
+	log.Trace(log.Here(), "listConsentPage() : calling method -")
+	var consents []hyperledger.Consent
+	var err error
+	if options.AllStates || options.State != hyperledger.STATE_ACTIVE {
+		consents, err = a.Consent_helper.GetAllConsents(applicationID)
+	} else if ownerID != "" {
+		consents, err = a.Consent_helper.GetConsents4Owner(applicationID, ownerID)
+	} else if consumerID != "" {
+		consents, err = a.Consent_helper.GetConsents4Consumer(applicationID, consumerID)
+	} else {
+		consents, err = a.Consent_helper.GetActivesConsents(applicationID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	listed := make([]keyedConsent, 0, len(consents))
+	for _, consent := range consents {
+		if ownerID != "" && consent.OwnerID != ownerID {
+			continue
+		}
+		if consumerID != "" && consent.ConsumerID != consumerID {
+			continue
+		}
+		if (!options.AllStates && consent.EffectiveState(now) != options.State) || !options.match(consent) {
+			continue
+		}
+		listed = append(listed, keyedConsent{key: options.sortKey(consent), consent: consent})
+	}
+	sort.SliceStable(listed, func(i, j int) bool { return options.less(listed[i], listed[j]) })
+	filtered := make([]hyperledger.Consent, len(listed))
+	for i, keyed := range listed {
+		filtered[i] = keyed.consent
+	}
+	if !options.Paged {
+		return HPconsents2ConsentsBytes(filtered)
+	}
+
+	start := 0
+	if options.Cursor != "" {
+		key, consentID, err1 := decodeCursor(options.Cursor)
+		if err1 != nil {
+			return nil, err1
+		}
+		start = sort.Search(len(filtered), func(i int) bool {
+			return options.after(listed[i].key, filtered[i].ConsentID, key, consentID)
+		})
+	}
+	end := start + options.Limit
+	if end > len(filtered) {
+		end = len(filtered)
+	}
+	page := model.ConsentPage{Total: len(filtered), Consents: convertHPConsents2APIConsents(filtered[start:end])}
+	if end < len(filtered) {
+		last := listed[end-1]
+		page.NextCursor = encodeCursor(last.key, last.consent.ConsentID)
+	}
+	return json.Marshal(page)
+}
+
+func (o *listOptions) match(consent hyperledger.Consent) bool {
+	if o.Datatype != "" && consent.Datatype != o.Datatype && !strings.HasPrefix(consent.Datatype, o.Datatype+DATATYPE_SEPARATOR) {
+		return false
+	}
+	if o.Dataaccess != "" && consent.Dataaccess != o.Dataaccess {
+		return false
+	}
+	if !o.From.IsZero() && consent.Dt_end != "" {
+		end, err := common.WindowEnd(consent.Dt_end)
+		if err == nil && !end.After(o.From) {
+			return false
+		}
+	}
+	if !o.To.IsZero() && consent.Dt_begin != "" {
+		begin, err := common.DateTimeValue(consent.Dt_begin)
+		if err == nil && begin.After(o.To) {
+			return false
+		}
+	}
+	return true
+}
+
+// Sortable key of a consent, a consent without begin comes first and one without end comes last
+func (o *listOptions) sortKey(consent hyperledger.Consent) string {
+	switch o.Sort {
+	case SORT_DT_BEGIN:
+		begin, err := common.DateTimeValue(consent.Dt_begin)
+		if err != nil {
+			return ""
+		}
+		return begin.UTC().Format(SORT_KEY_FORMAT)
+	case SORT_DT_END:
+		end, err := common.DateTimeValue(consent.Dt_end)
+		if err != nil {
+			return "9999"
+		}
+		return end.UTC().Format(SORT_KEY_FORMAT)
+	}
+	return consent.ConsentID
+}
+
+func (o *listOptions) less(c1, c2 keyedConsent) bool {
+	return o.after(c2.key, c2.consent.ConsentID, c1.key, c1.consent.ConsentID)
+}
+
+// True when (key1, id1) comes after (key2, id2) in the listing order
+func (o *listOptions) after(key1, id1, key2, id2 string) bool {
+	if key1 != key2 {
+		return (key1 > key2) != o.Descending
+	}
+	return (id1 > id2) != o.Descending
+}
+
+func encodeCursor(key, consentID string) string {
+	return b64.RawURLEncoding.EncodeToString([]byte(key + CURSOR_SEPARATOR + consentID))
+}
+
+func decodeCursor(cursor string) (string, string, error) {
+	bytes, err := b64.RawURLEncoding.DecodeString(cursor)
+	if err == nil {
+		parts := strings.SplitN(string(bytes), CURSOR_SEPARATOR, 2)
+		if len(parts) == 2 {
+			return parts[0], parts[1], nil
+		}
+	}
+	return "", "", common.NewValidationError("invalid cursor: " + cursor)
+}
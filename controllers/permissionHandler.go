@@ -14,10 +14,18 @@ limitations under the License.
 package controllers
 
 import (
+	"context"
 	"github.com/pascallimeux/ocms2/modules/auth/model"
+	"github.com/pascallimeux/ocms2/modules/common"
 	"github.com/pascallimeux/ocms2/modules/log"
+	"net/http"
+	"strconv"
+	"sync"
 )
 
+// Consent operations, admins and superusers act on every consent, users only on their own ones
+var consentOperations = []string{"createConsent", "requestConsent", "getConsent", "revokeConsent", "updateConsentState", "listOwnerConsents", "listConsumerConsents", "checkConsent", "getConsentHistory"}
+
 func (a *AppContext) InitPermissions() error {
 	log.Trace(log.Here(), "InitPermissions() : calling method -")
 	var perms []model.Permission
@@ -26,8 +34,30 @@ func (a *AppContext) InitPermissions() error {
 	perms = append(perms, model.Permission{Resource_name: "processConsent", Role_code: 2, Owner_only: false})
 	perms = append(perms, model.Permission{Resource_name: "processConsent", Role_code: 3, Owner_only: false})
 
+	perms = append(perms, model.Permission{Resource_name: "approveConsent", Role_code: 1, Owner_only: true})
+	perms = append(perms, model.Permission{Resource_name: "approveConsent", Role_code: 2, Owner_only: true})
+	perms = append(perms, model.Permission{Resource_name: "approveConsent", Role_code: 3, Owner_only: true})
+
+	perms = append(perms, model.Permission{Resource_name: "listConsents", Role_code: 1, Owner_only: false})
+	perms = append(perms, model.Permission{Resource_name: "listConsents", Role_code: 2, Owner_only: false})
+
+	perms = append(perms, model.Permission{Resource_name: "getIndexDrift", Role_code: 1, Owner_only: false})
+
+	perms = append(perms, model.Permission{Resource_name: "manageWebhooks", Role_code: 1, Owner_only: false})
+	perms = append(perms, model.Permission{Resource_name: "manageWebhooks", Role_code: 2, Owner_only: false})
+
+	perms = append(perms, model.Permission{Resource_name: "getTransactionStatus", Role_code: 1, Owner_only: false})
+	perms = append(perms, model.Permission{Resource_name: "getTransactionStatus", Role_code: 2, Owner_only: false})
+	perms = append(perms, model.Permission{Resource_name: "getTransactionStatus", Role_code: 3, Owner_only: false})
+
+	for _, operation := range consentOperations {
+		perms = append(perms, model.Permission{Resource_name: operation, Role_code: 1, Owner_only: false})
+		perms = append(perms, model.Permission{Resource_name: operation, Role_code: 2, Owner_only: false})
+		perms = append(perms, model.Permission{Resource_name: operation, Role_code: 3, Owner_only: true})
+	}
+
 	for _, perm := range perms {
-		_, err := a.AuthContext.SqlContext.CreatePermission(perm)
+		_, err := a.AuthContext.SqlContext.SeedPermission(perm)
 		if err != nil {
 			log.Error(log.Here(), err.Error())
 			return err
@@ -35,3 +65,122 @@ func (a *AppContext) InitPermissions() error {
 	}
 	return nil
 }
+
+// Register the configured application, used when a request gives no application.
+// The users existing when it is registered, those of a deployment without applications, become its members.
+func (a *AppContext) InitApplication() error {
+	log.Trace(log.Here(), "InitApplication() : calling method -")
+	_, err := a.AuthContext.SqlContext.GetApplication(a.Configuration.ApplicationID)
+	if err == nil {
+		return nil
+	}
+	application := model.Application{Id: a.Configuration.ApplicationID, Name: a.Configuration.ApplicationID}
+	_, err = a.AuthContext.SqlContext.CreateApplication(application)
+	if err != nil {
+		log.Error(log.Here(), err.Error())
+		return err
+	}
+	members, err1 := a.AuthContext.SqlContext.AddAllApplicationUsers(application.Id)
+	if err1 != nil {
+		log.Error(log.Here(), err1.Error())
+		return err1
+	}
+	log.Info(log.Here(), strconv.FormatInt(members, 10), " users added to the application ", application.Id)
+	return nil
+}
+
+// Application of the request: the given one, else the appid query parameter, else the configured one
+func (a *AppContext) getApplicationID(r *http.Request, applicationID string) string {
+	if applicationID == "" {
+		applicationID = r.URL.Query().Get("appid")
+	}
+	if applicationID == "" {
+		applicationID = a.Configuration.ApplicationID
+	}
+	return applicationID
+}
+
+type callerKey struct{}
+
+// Caller of a request: its token is verified once, its decisions are taken and logged once per operation and user
+type consentCaller struct {
+	claims    model.Claims
+	mutex     sync.Mutex
+	decisions map[string]error
+}
+
+// Verify the token and check the caller may use the consent API, the returned request carries the caller.
+// This coarse check is not logged, the operations are.
+func (a *AppContext) authorizeCaller(w http.ResponseWriter, r *http.Request) (*http.Request, error) {
+	log.Trace(log.Here(), "authorizeCaller() : calling method -")
+	claims, err := a.AuthContext.ClaimsFromHeader(r)
+	if err == nil {
+		err = a.AuthContext.SqlContext.IsAllowed4Claims(claims, "processConsent", "")
+	}
+	if err != nil {
+		common.SendError(log.Here(), w, err)
+		return r, err
+	}
+	caller := &consentCaller{claims: claims, decisions: make(map[string]error)}
+	return r.WithContext(context.WithValue(r.Context(), callerKey{}, caller)), nil
+}
+
+// Caller set by authorizeCaller, else built from the token of the request
+func (a *AppContext) requestCaller(r *http.Request) (*consentCaller, error) {
+	caller, ok := r.Context().Value(callerKey{}).(*consentCaller)
+	if ok {
+		return caller, nil
+	}
+	claims, err := a.AuthContext.ClaimsFromHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	return &consentCaller{claims: claims, decisions: make(map[string]error)}, nil
+}
+
+// Check the caller is granted the operation on the consents of one of the given users (owner or consumer),
+// each decision is recorded in the logs table
+func (a *AppContext) checkConsentPermission(r *http.Request, applicationID, operation string, userIDs ...string) error {
+	log.Trace(log.Here(), "checkConsentPermission(", applicationID, ", ", operation, ") : calling method -")
+	if len(userIDs) == 0 {
+		userIDs = []string{""}
+	}
+	caller, err := a.requestCaller(r)
+	if err != nil {
+		return err
+	}
+	for _, userID := range userIDs {
+		err = a.decide(caller, applicationID, operation, userID)
+		if err == nil || common.ToHttpError(err).Status != http.StatusForbidden {
+			return err
+		}
+	}
+	return err
+}
+
+// A decision already taken for the request is reused, so a batch logs it once
+func (a *AppContext) decide(caller *consentCaller, applicationID, operation, userID string) error {
+	caller.mutex.Lock()
+	defer caller.mutex.Unlock()
+	key := applicationID + "\x00" + operation + "\x00" + userID
+	err, ok := caller.decisions[key]
+	if !ok {
+		err = a.AuthContext.SqlContext.IsAuthorized4Application(caller.claims, applicationID, operation, userID)
+		caller.decisions[key] = err
+	}
+	return err
+}
+
+// A consent created directly is active at once, only its owner, an administrator or a superuser skips the owner approval
+func (a *AppContext) checkDirectCreation(r *http.Request, ownerID string) error {
+	log.Trace(log.Here(), "checkDirectCreation() : calling method -")
+	caller, err := a.requestCaller(r)
+	if err != nil {
+		return err
+	}
+	claims := caller.claims
+	if claims.Subject == ownerID || claims.Role == model.ADMINROLE || claims.Role == model.SUPERUSERROLE {
+		return nil
+	}
+	return common.NewForbiddenError("Only the owner creates a consent directly, other users request it!")
+}
@@ -16,6 +16,7 @@ package controllers
 import (
 	"github.com/gorilla/mux"
 	"github.com/pascallimeux/ocms2/hyperledger"
+	"github.com/pascallimeux/ocms2/model"
 	"github.com/pascallimeux/ocms2/modules/auth/controllers"
 	"github.com/pascallimeux/ocms2/modules/log"
 	"github.com/pascallimeux/ocms2/setting"
@@ -23,16 +24,24 @@ import (
 )
 
 const (
-	VERSIONURI   = "/ocms/v1/version"
-	CONSENTAPI   = "/ocms/v1/api/consent/"
-	CONSENTTRAPI = "/ocms/v1/api/hyperledger/consenttr"
+	VERSIONURI      = "/ocms/v1/version"
+	CONSENTAPI      = "/ocms/v1/api/consent/"
+	CONSENTTRAPI    = "/ocms/v1/api/hyperledger/consenttr"
+	CONSENTSAPI     = "/ocms/v2/consents"
+	INDEXAPI        = "/ocms/v2/index"
+	TRANSACTIONSAPI = "/ocms/v2/transactions"
+	WEBHOOKSAPI     = "/ocms/v2/webhooks"
+	EVENTSAPI       = "/ocms/v2/events"
 )
 
 type AppContext struct {
 	HttpServer     *http.Server
-	Consent_helper hyperledger.Consent_Helper
+	Consent_helper hyperledger.ConsentLedger
 	Configuration  setting.Settings
 	AuthContext    controllers.AppContext
+	SqlContext     model.SqlContext
+	Webhooks       *Webhook_Dispatcher
+	Events         *Event_Broker
 }
 
 func (appContext *AppContext) CreateOCMSRoutes(router *mux.Router) {
@@ -40,4 +49,28 @@ func (appContext *AppContext) CreateOCMSRoutes(router *mux.Router) {
 	router.HandleFunc(VERSIONURI, appContext.getVersion).Methods("GET")
 	router.HandleFunc(CONSENTAPI, appContext.processConsent).Methods("POST")
 	router.HandleFunc(CONSENTTRAPI+"/{truuid}", appContext.processConsentTR).Methods("GET")
+
+	router.HandleFunc(CONSENTSAPI, appContext.postConsent).Methods("POST")                      // create a consent
+	router.HandleFunc(CONSENTSAPI, appContext.getConsents).Methods("GET")                       // list consents (owner, consumer, state filters)
+	router.HandleFunc(CONSENTSAPI+"/check", appContext.checkConsent).Methods("GET")             // is there a consent
+	router.HandleFunc(CONSENTSAPI+"/check", appContext.postConsentChecks).Methods("POST")       // check a batch of consents
+	router.HandleFunc(CONSENTSAPI+"/{id}", appContext.getConsentResource).Methods("GET")        // read a consent
+	router.HandleFunc(CONSENTSAPI+"/{id}", appContext.deleteConsent).Methods("DELETE")          // revoke a consent
+	router.HandleFunc(CONSENTSAPI+"/{id}/state", appContext.putConsentState).Methods("PUT")     // suspend, resume or revoke a consent
+	router.HandleFunc(CONSENTSAPI+"/{id}/history", appContext.getConsentHistory).Methods("GET") // transactions of a consent
+	router.HandleFunc(CONSENTSAPI+"/requests", appContext.postConsentRequest).Methods("POST")   // file a consent request
+	router.HandleFunc(CONSENTSAPI+"/batch", appContext.postConsents).Methods("POST")            // create a batch of consents
+	router.HandleFunc(CONSENTSAPI+"/batch/revoke", appContext.revokeConsents).Methods("POST")   // revoke a batch of consents
+	router.HandleFunc(CONSENTSAPI+"/{id}/approve", appContext.approveConsent).Methods("POST")   // owner approves a request
+	router.HandleFunc(CONSENTSAPI+"/{id}/reject", appContext.rejectConsent).Methods("POST")     // owner rejects a request
+
+	router.HandleFunc(TRANSACTIONSAPI+"/{id}", appContext.getTransactionStatus).Methods("GET")             // commit status of a submitted transaction
+	router.HandleFunc(EVENTSAPI, appContext.getEvents).Methods("GET")                                      // live stream of the consent events (SSE)
+	router.HandleFunc(WEBHOOKSAPI, appContext.postWebhook).Methods("POST")                                 // register a webhook
+	router.HandleFunc(WEBHOOKSAPI, appContext.getWebhooks).Methods("GET")                                  // webhooks of an application
+	router.HandleFunc(WEBHOOKSAPI+"/deadletters", appContext.getDeadLetters).Methods("GET")                // failed deliveries
+	router.HandleFunc(WEBHOOKSAPI+"/deadletters/replay", appContext.replayDeadLetters).Methods("POST")     // replay every failed delivery
+	router.HandleFunc(WEBHOOKSAPI+"/deadletters/{id}/replay", appContext.replayDeadLetter).Methods("POST") // replay a failed delivery
+	router.HandleFunc(WEBHOOKSAPI+"/{id}", appContext.deleteWebhook).Methods("DELETE")                     // unregister a webhook
+	router.HandleFunc(INDEXAPI+"/drift", appContext.getIndexDrift).Methods("GET")                          // compare the consent index with the ledger
 }
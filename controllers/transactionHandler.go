@@ -0,0 +1,132 @@
+/*
+Copyright Pascal Limeux. 2017 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/pascallimeux/ocms2/hyperledger"
+	"github.com/pascallimeux/ocms2/model"
+	"github.com/pascallimeux/ocms2/modules/common"
+	"github.com/pascallimeux/ocms2/modules/log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// A failed status is inferred from the timeout, the peer does not report rejected transactions
+const TX_FAILED_NOTE = "not committed within the transaction timeout, the status turns to committed if the transaction commits later"
+
+// Context key of the wait-for-commit mode of a v1 request
+type commitWaitKey struct{}
+
+//HTTP Get - /ocms/v2/transactions/{id}
+func (a *AppContext) getTransactionStatus(w http.ResponseWriter, r *http.Request) {
+	log.Trace(log.Here(), "getTransactionStatus() : calling method -")
+
+	r, err1 := a.authorizeCaller(w, r)
+	if err1 != nil {
+		return
+	}
+
+	vars := mux.Vars(r)
+	bytes, err := a.transactionStatus(r, vars["id"])
+	if err != nil {
+		common.SendError(log.Here(), w, err)
+		return
+	}
+	sendBytes(w, http.StatusOK, bytes)
+}
+
+// A transaction submitted by OCMS is pending until it is visible on the ledger, failed when it is still
+// not visible after the transaction timeout. Failed is a guess noted in the response, a late commit turns it
+// into committed. Any other transaction is either committed or not found.
+func (a *AppContext) transactionStatus(r *http.Request, tr_uuid string) ([]byte, error) {
+	message := fmt.Sprintf("transactionStatus(tr_uuid=%s) : calling method -", tr_uuid)
+	log.Info(log.Here(), message)
+	submitted, err := a.SqlContext.GetSubmittedTransaction(tr_uuid)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	applicationID := submitted.Appid
+	if applicationID == "" {
+		applicationID = a.getApplicationID(r, "")
+	}
+	err = a.checkConsentPermission(r, applicationID, "getTransactionStatus")
+	if err != nil {
+		return nil, err
+	}
+
+	status := model.TransactionStatus{Txuuid: tr_uuid}
+	if submitted.Txuuid == "" {
+		committed, err1 := hyperledger.IsCommitted(a.Consent_helper, tr_uuid)
+		if err1 != nil {
+			return nil, err1
+		}
+		if !committed {
+			return nil, common.NewNotFoundError("transaction " + tr_uuid + " not found")
+		}
+		status.Status = hyperledger.TX_COMMITTED
+		return json.Marshal(status)
+	}
+	status.Status, err = hyperledger.TransactionStatus(a.Consent_helper, tr_uuid, submitted.CreatedAt, a.Configuration.TransactionTimeout)
+	if err != nil {
+		return nil, err
+	}
+	status.Function = submitted.Function
+	status.Consentid = submitted.Consentid
+	status.SubmittedAt = submitted.CreatedAt.Format(time.RFC3339)
+	if status.Status == hyperledger.TX_FAILED {
+		status.Note = TX_FAILED_NOTE
+	}
+	return json.Marshal(status)
+}
+
+// Record a write transaction, then wait for its commit when the caller asked for it
+func (a *AppContext) trackTransaction(r *http.Request, applicationID, consentID, tr_uuid, function string) error {
+	log.Trace(log.Here(), "trackTransaction() : calling method -")
+	a.recordTransaction(applicationID, consentID, tr_uuid, function)
+	if !commitWanted(r) {
+		return nil
+	}
+	timeout := a.Configuration.TransactionTimeout
+	committed, err := hyperledger.WaitForCommit(a.Consent_helper, tr_uuid, timeout)
+	if err != nil {
+		return err
+	}
+	if !committed {
+		return common.NewTimeoutError("transaction " + tr_uuid + " not committed after " + timeout.String() + ", its status is at " + TRANSACTIONSAPI + "/" + tr_uuid)
+	}
+	return nil
+}
+
+// A v1 request asks for the wait-for-commit mode in its body
+func withCommitWait(r *http.Request, wait bool) *http.Request {
+	if !wait {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), commitWaitKey{}, true))
+}
+
+// True when the caller waits for the commit: Wait field of a v1 request or wait=true query parameter
+func commitWanted(r *http.Request) bool {
+	if wait, ok := r.Context().Value(commitWaitKey{}).(bool); ok {
+		return wait
+	}
+	wait, _ := strconv.ParseBool(r.URL.Query().Get("wait"))
+	return wait
+}
@@ -0,0 +1,86 @@
+/*
+Copyright Pascal Limeux. 2017 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+       http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"github.com/pascallimeux/ocms2/modules/common"
+	"github.com/pascallimeux/ocms2/modules/log"
+	"strings"
+)
+
+// Wildcards of the vocabulary, always valid
+const (
+	ALL_DATATYPES      = "All"
+	ALL_DATAACCESS     = "A"
+	DATATYPE_SEPARATOR = "/"
+)
+
+// Check the datatype and the access mode against the registry of the application,
+// an application without registered terms accepts any of them
+func (a *AppContext) checkVocabulary(applicationID, datatype, dataaccess string) error {
+	log.Trace(log.Here(), "checkVocabulary(", applicationID, ", ", datatype, ", ", dataaccess, ") : calling method -")
+	datatypes, err := a.AuthContext.SqlContext.GetApplicationDatatypes(applicationID)
+	if err != nil {
+		return err
+	}
+	if datatype != ALL_DATATYPES && len(datatypes) > 0 && !isKnownDatatype(datatypes, datatype) {
+		return common.NewValidationError("datatype " + datatype + " not registered for application " + applicationID)
+	}
+	accesses, err := a.AuthContext.SqlContext.GetApplicationDataaccess(applicationID)
+	if err != nil {
+		return err
+	}
+	if dataaccess != ALL_DATAACCESS && len(accesses) > 0 && !contains(accesses, dataaccess) {
+		return common.NewValidationError("dataaccess " + dataaccess + " not registered for application " + applicationID)
+	}
+	return nil
+}
+
+// A datatype is known when registered or when it is the parent of a registered datatype
+func isKnownDatatype(datatypes []string, datatype string) bool {
+	for _, known := range datatypes {
+		if known == datatype || strings.HasPrefix(known, datatype+DATATYPE_SEPARATOR) {
+			return true
+		}
+	}
+	return false
+}
+
+// Datatypes whose consent covers the given one: itself, its parents (health/heartrate, health) then All
+func coveringDatatypes(datatype string) []string {
+	covering := []string{datatype}
+	for i := strings.LastIndex(datatype, DATATYPE_SEPARATOR); i > 0; i = strings.LastIndex(datatype[:i], DATATYPE_SEPARATOR) {
+		covering = append(covering, datatype[:i])
+	}
+	if datatype != ALL_DATATYPES {
+		covering = append(covering, ALL_DATATYPES)
+	}
+	return covering
+}
+
+func coveringDataaccess(dataaccess string) []string {
+	if dataaccess == ALL_DATAACCESS {
+		return []string{dataaccess}
+	}
+	return []string{dataaccess, ALL_DATAACCESS}
+}
+
+func contains(terms []string, term string) bool {
+	for _, known := range terms {
+		if known == term {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,219 @@
+/*
+Copyright Pascal Limeux. 2017 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/pascallimeux/ocms2/model"
+	"github.com/pascallimeux/ocms2/modules/log"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	SIGNATURE_HEADER   = "X-OCMS-Signature"
+	EVENT_HEADER       = "X-OCMS-Event"
+	DELIVERY_HEADER    = "X-OCMS-Delivery"
+	SIGNATURE_PREFIX   = "sha256="
+	WEBHOOK_QUEUE_SIZE = 1000
+)
+
+// Deliver the consent events to the webhooks of their application.
+// A failed delivery is retried after Backoff, 2*Backoff, 4*Backoff... and goes to the dead letters after MaxAttempts.
+type Webhook_Dispatcher struct {
+	SqlContext  model.SqlContext
+	Client      *http.Client
+	MaxAttempts int
+	Backoff     time.Duration
+	queue       chan delivery
+	pending     sync.WaitGroup
+}
+
+type delivery struct {
+	webhook  model.Webhook
+	eventID  string
+	event    string
+	payload  []byte
+	attempts int
+}
+
+// Unless allowPrivate is set, the deliveries do not connect to loopback, link-local nor private addresses,
+// checked once the host is resolved so a webhook cannot reach the services next to OCMS
+func NewWebhookDispatcher(sqlContext model.SqlContext, workers, maxAttempts int, backoff, timeout time.Duration, allowPrivate bool) *Webhook_Dispatcher {
+	log.Trace(log.Here(), "NewWebhookDispatcher() : calling method -")
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	dialer := &net.Dialer{Timeout: timeout}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			return checkWebhookAddress(net.ParseIP(host))
+		}
+	}
+	client := &http.Client{Timeout: timeout, Transport: &http.Transport{DialContext: dialer.DialContext}}
+	d := &Webhook_Dispatcher{SqlContext: sqlContext, Client: client, MaxAttempts: maxAttempts, Backoff: backoff, queue: make(chan delivery, WEBHOOK_QUEUE_SIZE)}
+	for i := 0; i < workers; i++ {
+		go d.work()
+	}
+	return d
+}
+
+// Queue the event for the webhooks of its application subscribed to its type
+func (d *Webhook_Dispatcher) Publish(event model.WebhookEvent) {
+	log.Trace(log.Here(), "Publish(", event.Type, ", ", event.Consentid, ") : calling method -")
+	webhooks, err := d.SqlContext.GetWebhooks(event.Appid)
+	if err != nil {
+		log.Error(log.Here(), "could not read the webhooks of ", event.Appid, ": ", err.Error())
+		return
+	}
+	payload, err1 := json.Marshal(event)
+	if err1 != nil {
+		log.Error(log.Here(), "could not encode event ", event.Id, ": ", err1.Error())
+		return
+	}
+	for _, webhook := range webhooks {
+		if subscribed(webhook, event.Type) {
+			d.enqueue(delivery{webhook: webhook, eventID: event.Id, event: event.Type, payload: payload})
+		}
+	}
+}
+
+// Queue again a dead letter, it is removed from the dead letters
+func (d *Webhook_Dispatcher) Replay(deadLetter model.DeadLetter) error {
+	log.Trace(log.Here(), "Replay(", deadLetter.Id, ") : calling method -")
+	webhook, err := d.SqlContext.GetWebhook(deadLetter.Webhookid)
+	if err != nil {
+		return err
+	}
+	err = d.SqlContext.DeleteDeadLetter(deadLetter.Id)
+	if err != nil {
+		return err
+	}
+	d.enqueue(delivery{webhook: webhook, eventID: deadLetter.Eventid, event: deadLetter.Event, payload: []byte(deadLetter.Payload)})
+	return nil
+}
+
+// Block until every queued delivery succeeded or reached the dead letters
+func (d *Webhook_Dispatcher) Wait() {
+	d.pending.Wait()
+}
+
+// HMAC-SHA256 of the payload with the webhook secret, sent in the X-OCMS-Signature header
+func SignPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return SIGNATURE_PREFIX + hex.EncodeToString(mac.Sum(nil))
+}
+
+// The queue is bounded, an event which does not fit goes straight to the dead letters
+func (d *Webhook_Dispatcher) enqueue(delivery delivery) {
+	d.pending.Add(1)
+	d.requeue(delivery)
+}
+
+// Queue a delivery already counted as pending, a retry included
+func (d *Webhook_Dispatcher) requeue(delivery delivery) {
+	select {
+	case d.queue <- delivery:
+	default:
+		d.deadLetter(delivery, errors.New("webhook queue full"))
+		d.pending.Done()
+	}
+}
+
+func (d *Webhook_Dispatcher) work() {
+	for delivery := range d.queue {
+		delivery.attempts++
+		err := d.post(delivery)
+		if err == nil {
+			d.pending.Done()
+			continue
+		}
+		log.Warning(log.Here(), "delivery ", strconv.Itoa(delivery.attempts), " of event ", delivery.eventID, " to ", delivery.webhook.Url, " failed: ", err.Error())
+		if delivery.attempts < d.MaxAttempts {
+			retry := delivery
+			time.AfterFunc(d.Backoff<<uint(delivery.attempts-1), func() { d.requeue(retry) })
+			continue
+		}
+		d.deadLetter(delivery, err)
+		d.pending.Done()
+	}
+}
+
+func (d *Webhook_Dispatcher) post(delivery delivery) error {
+	request, err := http.NewRequest("POST", delivery.webhook.Url, bytes.NewReader(delivery.payload))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(EVENT_HEADER, delivery.event)
+	request.Header.Set(DELIVERY_HEADER, delivery.eventID)
+	request.Header.Set(SIGNATURE_HEADER, SignPayload(delivery.webhook.Secret, delivery.payload))
+	response, err1 := d.Client.Do(request)
+	if err1 != nil {
+		return err1
+	}
+	defer response.Body.Close()
+	io.Copy(ioutil.Discard, response.Body)
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return errors.New("webhook answered " + strconv.Itoa(response.StatusCode))
+	}
+	return nil
+}
+
+func (d *Webhook_Dispatcher) deadLetter(delivery delivery, err error) {
+	deadLetter := model.DeadLetter{Webhookid: delivery.webhook.Id, Eventid: delivery.eventID, Event: delivery.event, Payload: string(delivery.payload), Attempts: delivery.attempts, Error: err.Error(), FailedAt: time.Now()}
+	_, err1 := d.SqlContext.AddDeadLetter(deadLetter)
+	if err1 != nil {
+		log.Error(log.Here(), "could not keep event ", delivery.eventID, " for ", delivery.webhook.Url, ": ", err1.Error())
+	}
+}
+
+// Loopback, link-local, private and unspecified addresses are refused as webhook targets
+func checkWebhookAddress(ip net.IP) error {
+	if ip == nil {
+		return errors.New("webhook address is not an ip address")
+	}
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsPrivate() || ip.IsUnspecified() {
+		return errors.New("webhook address " + ip.String() + " is loopback, link-local or private")
+	}
+	return nil
+}
+
+func subscribed(webhook model.Webhook, event string) bool {
+	if len(webhook.Events) == 0 {
+		return true
+	}
+	for _, subscription := range webhook.Events {
+		if subscription == event {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,248 @@
+/*
+Copyright Pascal Limeux. 2017 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/pascallimeux/ocms2/model"
+	"github.com/pascallimeux/ocms2/modules/common"
+	"github.com/pascallimeux/ocms2/modules/log"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+//HTTP Post - /ocms/v2/webhooks
+func (a *AppContext) postWebhook(w http.ResponseWriter, r *http.Request) {
+	log.Trace(log.Here(), "postWebhook() : calling method -")
+
+	r, err1 := a.authorizeCaller(w, r)
+	if err1 != nil {
+		return
+	}
+
+	var webhook model.Webhook
+	err := json.NewDecoder(r.Body).Decode(&webhook)
+	if err != nil {
+		common.SendError(log.Here(), w, err)
+		return
+	}
+	webhook.Appid = a.getApplicationID(r, webhook.Appid)
+	webhook, err = a.createWebhook(r, webhook)
+	if err != nil {
+		common.SendError(log.Here(), w, err)
+		return
+	}
+	common.BuildHttp201Response(w, webhook)
+}
+
+// The secret is generated when none is given, it is only sent back here
+func (a *AppContext) createWebhook(r *http.Request, webhook model.Webhook) (model.Webhook, error) {
+	message := fmt.Sprintf("createWebhook(applicationID=%s, url=%s) : calling method -", webhook.Appid, webhook.Url)
+	log.Info(log.Here(), message)
+	err := a.checkWebhooks(r, webhook.Appid)
+	if err != nil {
+		return webhook, err
+	}
+	target, err := url.Parse(webhook.Url)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return webhook, common.NewValidationError("webhook url is not an http(s) url: " + webhook.Url)
+	}
+	if !a.Configuration.WebhookPrivate {
+		err = checkWebhookHost(target.Hostname())
+		if err != nil {
+			return webhook, err
+		}
+	}
+	for _, event := range webhook.Events {
+		if !isConsentEvent(event) {
+			return webhook, common.NewValidationError("unknown event: " + event)
+		}
+	}
+	if webhook.Secret == "" {
+		webhook.Secret, err = common.Generate_Token()
+		if err != nil {
+			return webhook, err
+		}
+	}
+	webhook.Id = ""
+	return a.SqlContext.CreateWebhook(webhook)
+}
+
+// Every address of the host must be public, the dispatcher checks them again when it connects
+func checkWebhookHost(host string) error {
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return common.NewValidationError("webhook host cannot be resolved: " + host)
+	}
+	for _, ip := range ips {
+		err = checkWebhookAddress(ip)
+		if err != nil {
+			return common.NewValidationError(err.Error())
+		}
+	}
+	return nil
+}
+
+//HTTP Get - /ocms/v2/webhooks
+func (a *AppContext) getWebhooks(w http.ResponseWriter, r *http.Request) {
+	log.Trace(log.Here(), "getWebhooks() : calling method -")
+
+	r, err1 := a.authorizeCaller(w, r)
+	if err1 != nil {
+		return
+	}
+
+	applicationID := a.getApplicationID(r, "")
+	err := a.checkWebhooks(r, applicationID)
+	if err != nil {
+		common.SendError(log.Here(), w, err)
+		return
+	}
+	webhooks, err := a.SqlContext.GetWebhooks(applicationID)
+	if err != nil {
+		common.SendError(log.Here(), w, err)
+		return
+	}
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+	bytes, _ := json.Marshal(webhooks)
+	sendBytes(w, http.StatusOK, bytes)
+}
+
+//HTTP Delete - /ocms/v2/webhooks/{id}
+func (a *AppContext) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	log.Trace(log.Here(), "deleteWebhook() : calling method -")
+
+	r, err1 := a.authorizeCaller(w, r)
+	if err1 != nil {
+		return
+	}
+
+	vars := mux.Vars(r)
+	webhook, err := a.getWebhook(r, vars["id"])
+	if err == nil {
+		err = a.SqlContext.DeleteWebhook(webhook.Id)
+	}
+	if err != nil {
+		common.SendError(log.Here(), w, err)
+		return
+	}
+	webhook.Secret = ""
+	bytes, _ := json.Marshal(webhook)
+	sendBytes(w, http.StatusOK, bytes)
+}
+
+//HTTP Get - /ocms/v2/webhooks/deadletters?webhook=
+func (a *AppContext) getDeadLetters(w http.ResponseWriter, r *http.Request) {
+	log.Trace(log.Here(), "getDeadLetters() : calling method -")
+
+	r, err1 := a.authorizeCaller(w, r)
+	if err1 != nil {
+		return
+	}
+
+	deadLetters, err := a.deadLetters(r)
+	if err != nil {
+		common.SendError(log.Here(), w, err)
+		return
+	}
+	bytes, _ := json.Marshal(deadLetters)
+	sendBytes(w, http.StatusOK, bytes)
+}
+
+//HTTP Post - /ocms/v2/webhooks/deadletters/replay?webhook=
+func (a *AppContext) replayDeadLetters(w http.ResponseWriter, r *http.Request) {
+	log.Trace(log.Here(), "replayDeadLetters() : calling method -")
+
+	r, err1 := a.authorizeCaller(w, r)
+	if err1 != nil {
+		return
+	}
+
+	deadLetters, err := a.deadLetters(r)
+	for i := 0; err == nil && i < len(deadLetters); i++ {
+		err = a.Webhooks.Replay(deadLetters[i])
+	}
+	if err != nil {
+		common.SendError(log.Here(), w, err)
+		return
+	}
+	bytes, _ := json.Marshal(deadLetters)
+	sendBytes(w, http.StatusAccepted, bytes)
+}
+
+//HTTP Post - /ocms/v2/webhooks/deadletters/{id}/replay
+func (a *AppContext) replayDeadLetter(w http.ResponseWriter, r *http.Request) {
+	log.Trace(log.Here(), "replayDeadLetter() : calling method -")
+
+	r, err1 := a.authorizeCaller(w, r)
+	if err1 != nil {
+		return
+	}
+
+	vars := mux.Vars(r)
+	deadLetter, err := a.SqlContext.GetDeadLetter(vars["id"])
+	if err == nil {
+		_, err = a.getWebhook(r, deadLetter.Webhookid)
+	}
+	if err == nil {
+		err = a.Webhooks.Replay(deadLetter)
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			err = common.NewNotFoundError("dead letter " + vars["id"] + " not found")
+		}
+		common.SendError(log.Here(), w, err)
+		return
+	}
+	bytes, _ := json.Marshal(deadLetter)
+	sendBytes(w, http.StatusAccepted, bytes)
+}
+
+// Dead letters of the application, of a single webhook with the webhook query parameter
+func (a *AppContext) deadLetters(r *http.Request) ([]model.DeadLetter, error) {
+	applicationID := a.getApplicationID(r, "")
+	webhookID := r.URL.Query().Get("webhook")
+	message := fmt.Sprintf("deadLetters(applicationID=%s, webhook=%s) : calling method -", applicationID, webhookID)
+	log.Info(log.Here(), message)
+	err := a.checkWebhooks(r, applicationID)
+	if err != nil {
+		return nil, err
+	}
+	return a.SqlContext.GetDeadLetters(applicationID, webhookID)
+}
+
+// A webhook of another application is not found
+func (a *AppContext) getWebhook(r *http.Request, webhookID string) (model.Webhook, error) {
+	webhook, err := a.SqlContext.GetWebhook(webhookID)
+	if err == sql.ErrNoRows || (err == nil && webhook.Appid != a.getApplicationID(r, "")) {
+		return webhook, common.NewNotFoundError("webhook " + webhookID + " not found")
+	}
+	if err != nil {
+		return webhook, err
+	}
+	return webhook, a.checkWebhooks(r, webhook.Appid)
+}
+
+func (a *AppContext) checkWebhooks(r *http.Request, applicationID string) error {
+	if a.Webhooks == nil {
+		return common.NewNotFoundError("webhooks are not enabled")
+	}
+	return a.checkConsentPermission(r, applicationID, "manageWebhooks")
+}
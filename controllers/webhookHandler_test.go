@@ -0,0 +1,196 @@
+/*
+Copyright Pascal Limeux. 2017 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"encoding/json"
+	"github.com/pascallimeux/ocms2/model"
+	"github.com/pascallimeux/ocms2/modules/common"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Webhook receiver, it answers status to every delivery
+type receiver struct {
+	mutex  sync.Mutex
+	status int
+	secret string
+	events []model.WebhookEvent
+	server *httptest.Server
+}
+
+func newReceiver(t *testing.T, secret string, status int) *receiver {
+	rec := &receiver{secret: secret, status: status}
+	rec.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		rec.mutex.Lock()
+		defer rec.mutex.Unlock()
+		if r.Header.Get(SIGNATURE_HEADER) != SignPayload(rec.secret, body) {
+			t.Error("bad signature for ", string(body))
+		}
+		event := model.WebhookEvent{}
+		json.Unmarshal(body, &event)
+		if r.Header.Get(EVENT_HEADER) != event.Type || r.Header.Get(DELIVERY_HEADER) != event.Id {
+			t.Error("bad headers for ", string(body))
+		}
+		if rec.status == http.StatusOK {
+			rec.events = append(rec.events, event)
+		}
+		w.WriteHeader(rec.status)
+	}))
+	return rec
+}
+
+func (rec *receiver) setStatus(status int) {
+	rec.mutex.Lock()
+	defer rec.mutex.Unlock()
+	rec.status = status
+}
+
+func (rec *receiver) setSecret(secret string) {
+	rec.mutex.Lock()
+	defer rec.mutex.Unlock()
+	rec.secret = secret
+}
+
+// Types of the events received for a consent
+func (rec *receiver) received(consentID string) map[string]int {
+	rec.mutex.Lock()
+	defer rec.mutex.Unlock()
+	types := map[string]int{}
+	for _, event := range rec.events {
+		if event.Consentid == consentID {
+			types[event.Type]++
+		}
+	}
+	return types
+}
+
+func TestWebhookNominal(t *testing.T) {
+	all := newReceiver(t, "s3cret", http.StatusOK)
+	defer all.server.Close()
+	revocations := newReceiver(t, "", http.StatusServiceUnavailable)
+	defer revocations.server.Close()
+
+	webhook := registerWebhook(t, model.Webhook{Url: all.server.URL, Secret: "s3cret"}, http.StatusCreated)
+	defer checkStatus(t, "DELETE", WEBHOOKSAPI+"/"+webhook.Id, tokenValue, http.StatusOK)
+	revocation := registerWebhook(t, model.Webhook{Url: revocations.server.URL, Events: []string{EVENT_CONSENT_REVOKED}}, http.StatusCreated)
+	defer checkStatus(t, "DELETE", WEBHOOKSAPI+"/"+revocation.Id, tokenValue, http.StatusOK)
+	revocations.setSecret(revocation.Secret)
+	if revocation.Secret == "" {
+		t.Error("no secret generated")
+	}
+
+	data, _ := json.Marshal(model.Consent{Ownerid: "W001", Consumerid: "W002", Datatype: "BP", Dataaccess: "R"})
+	request, _ := common.BuildRequestWithToken("POST", httpServerTest.URL+CONSENTSAPI, string(data), tokenValue)
+	_, body, _ := common.ExecuteRequest(request)
+	created := model.Consent{}
+	json.Unmarshal(body, &created)
+	checkConsentState(t, created.Consentid, "suspended", http.StatusOK)
+	checkStatus(t, "DELETE", CONSENTSAPI+"/"+created.Consentid, tokenValue, http.StatusOK)
+	appContext.Webhooks.Wait()
+
+	types := all.received(created.Consentid)
+	if len(types) != 3 || types[EVENT_CONSENT_CREATED] != 1 || types[EVENT_CONSENT_STATE_CHANGED] != 1 || types[EVENT_CONSENT_REVOKED] != 1 {
+		t.Error("bad events received: ", types)
+	}
+
+	deadLetters := getDeadLetters(t, revocation.Id)
+	if len(deadLetters) != 1 || deadLetters[0].Event != EVENT_CONSENT_REVOKED || deadLetters[0].Attempts != 3 {
+		t.Fatal("bad dead letters: ", deadLetters)
+	}
+	revocations.setStatus(http.StatusOK)
+	checkStatus(t, "POST", WEBHOOKSAPI+"/deadletters/replay?webhook="+revocation.Id, tokenValue, http.StatusAccepted)
+	appContext.Webhooks.Wait()
+	if types := revocations.received(created.Consentid); len(types) != 1 || types[EVENT_CONSENT_REVOKED] != 1 {
+		t.Error("dead letter not replayed: ", types)
+	}
+	if deadLetters := getDeadLetters(t, revocation.Id); len(deadLetters) != 0 {
+		t.Error("replayed dead letter kept: ", deadLetters)
+	}
+}
+
+func TestWebhookExpiry(t *testing.T) {
+	all := newReceiver(t, "s3cret", http.StatusOK)
+	defer all.server.Close()
+	webhook := registerWebhook(t, model.Webhook{Url: all.server.URL, Secret: "s3cret", Events: []string{EVENT_CONSENT_EXPIRED}}, http.StatusCreated)
+	defer checkStatus(t, "DELETE", WEBHOOKSAPI+"/"+webhook.Id, tokenValue, http.StatusOK)
+
+	data, _ := json.Marshal(model.Consent{Ownerid: "W003", Consumerid: "W002", Datatype: "BP", Dataaccess: "R", Dt_begin: common.GetStringDateNow(-2), Dt_end: common.GetStringDateNow(-1)})
+	request, _ := common.BuildRequestWithToken("POST", httpServerTest.URL+CONSENTSAPI, string(data), tokenValue)
+	_, body, _ := common.ExecuteRequest(request)
+	created := model.Consent{}
+	json.Unmarshal(body, &created)
+	appContext.sweepExpiredConsents()
+	appContext.sweepExpiredConsents()
+	appContext.Webhooks.Wait()
+	if types := all.received(created.Consentid); len(types) != 1 || types[EVENT_CONSENT_EXPIRED] != 1 {
+		t.Error("bad expiry events: ", types)
+	}
+}
+
+func TestWebhookValidation(t *testing.T) {
+	_, userToken := registerUser(t, "user7")
+	registerWebhook(t, model.Webhook{Url: "ftp://example.com/hook"}, http.StatusBadRequest)
+	registerWebhook(t, model.Webhook{Url: "http://example.com/hook", Events: []string{"consent.deleted"}}, http.StatusBadRequest)
+	checkStatus(t, "GET", WEBHOOKSAPI, userToken, http.StatusForbidden)
+	checkStatus(t, "DELETE", WEBHOOKSAPI+"/unknownwebhook", tokenValue, http.StatusNotFound)
+	checkStatus(t, "POST", WEBHOOKSAPI+"/deadletters/unknownletter/replay", tokenValue, http.StatusNotFound)
+}
+
+func TestWebhookPrivateAddress(t *testing.T) {
+	for _, host := range []string{"127.0.0.1", "::1", "169.254.169.254", "10.0.0.1", "192.168.1.1", "0.0.0.0"} {
+		if checkWebhookHost(host) == nil {
+			t.Error("private address accepted: ", host)
+		}
+	}
+	if err := checkWebhookHost("8.8.8.8"); err != nil {
+		t.Error("public address refused: ", err)
+	}
+
+	local := newReceiver(t, "s3cret", http.StatusOK)
+	defer local.server.Close()
+	dispatcher := NewWebhookDispatcher(appContext.SqlContext, 0, 1, time.Millisecond, time.Second, false)
+	err := dispatcher.post(delivery{webhook: model.Webhook{Url: local.server.URL, Secret: "s3cret"}, eventID: "private", event: EVENT_CONSENT_CREATED, payload: []byte("{}")})
+	if err == nil {
+		t.Error("delivery to a loopback address not refused")
+	}
+}
+
+func registerWebhook(t *testing.T, webhook model.Webhook, expectedStatus int) model.Webhook {
+	data, _ := json.Marshal(webhook)
+	request, _ := common.BuildRequestWithToken("POST", httpServerTest.URL+WEBHOOKSAPI, string(data), tokenValue)
+	status, body, _ := common.ExecuteRequest(request)
+	if status != expectedStatus {
+		t.Fatal("register webhook ", webhook.Url, ": ", status, " ", string(body))
+	}
+	created := model.Webhook{}
+	json.Unmarshal(body, &created)
+	return created
+}
+
+func getDeadLetters(t *testing.T, webhookID string) []model.DeadLetter {
+	request, _ := common.BuildRequestWithToken("GET", httpServerTest.URL+WEBHOOKSAPI+"/deadletters?webhook="+webhookID, " ", tokenValue)
+	status, body, _ := common.ExecuteRequest(request)
+	deadLetters := []model.DeadLetter{}
+	json.Unmarshal(body, &deadLetters)
+	if status != http.StatusOK {
+		t.Error("dead letters: ", status, " ", string(body))
+	}
+	return deadLetters
+}
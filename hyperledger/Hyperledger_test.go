@@ -11,22 +11,27 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package hyperledger
+package hyperledger_test
 
 import (
+	"github.com/pascallimeux/ocms2/hyperledger"
+	"github.com/pascallimeux/ocms2/hyperledger/peertest"
 	"github.com/pascallimeux/ocms2/modules/common"
 	"github.com/pascallimeux/ocms2/modules/log"
 	"github.com/pascallimeux/ocms2/setting"
+	"net/http"
 	"os"
 	"testing"
 	"time"
 )
 
 var logfile *os.File
-var consent_helper Consent_Helper
+var consent_helper hyperledger.Consent_Helper
 var config setting.Settings
+var peer *peertest.Peer
 
-const TransactionTimeout = 5000000000
+// Delay before reading a committed transaction, only needed with a real peer
+var TransactionTimeout time.Duration
 
 func TestMain(m *testing.M) {
 	setup()
@@ -42,15 +47,26 @@ func setup() {
 	// Init logger
 	logfile = log.Init_log(config.LogFileName, config.LogMode)
 
+	// Use a local fake peer unless OCMS_LEDGER=hyperledger
+	if os.Getenv("OCMS_LEDGER") == hyperledger.HYPERLEDGER_LEDGER {
+		TransactionTimeout = 5000000000
+	} else {
+		peer = peertest.NewPeer(config.ChainCodePath, config.ChainCodeName, config.EnrollID, config.EnrollSecret)
+		config.HttpHyperledger = peer.URL
+	}
+
 	// Init Hyperledger helpers
-	HP_helper := HP_Helper{HttpHyperledger: config.HttpHyperledger, HLTimeout: config.HLTimeout}
-	consent_helper = Consent_Helper{HP_helper: HP_helper, ChainCodePath: config.ChainCodePath, ChainCodeName: config.ChainCodeName, EnrollID: config.EnrollID, EnrollSecret: config.EnrollSecret}
+	HP_helper := hyperledger.HP_Helper{HttpHyperledger: config.HttpHyperledger, HLTimeout: config.HLTimeout}
+	consent_helper = hyperledger.Consent_Helper{HP_helper: HP_helper, ChainCodePath: config.ChainCodePath, ChainCodeName: config.ChainCodeName, EnrollID: config.EnrollID, EnrollSecret: config.EnrollSecret}
 
 }
 
 func shutdown() {
 	log.Trace(log.Here(), "End of tests..")
 	defer logfile.Close()
+	if peer != nil {
+		defer peer.Close()
+	}
 }
 
 func TestDeploySmartContractNominal(t *testing.T) {
@@ -260,7 +276,38 @@ func TestUnctivateConsentNominal(t *testing.T) {
 	time.Sleep(TransactionTimeout)
 	consent2, err3 := consent_helper.GetConsent(config.ApplicationID, tr_uuid)
 	if err3 == nil {
-		t.Error(err3)
+		t.Error("removed consent still readable: ", consent2.ToString())
+	}
+}
+
+func TestSetConsentStateNotImplemented(t *testing.T) {
+	tr_uuid, err := consent_helper.CreateConsent(config.ApplicationID, "CCCC", "DDDD", "BP", "R", common.GetStringDateNow(0), common.GetStringDateNow(1))
+	if err != nil {
+		t.Error(err)
+	}
+	time.Sleep(TransactionTimeout)
+	_, err1 := consent_helper.SetConsentState(config.ApplicationID, tr_uuid, hyperledger.STATE_SUSPENDED)
+	if common.ToHttpError(err1).Status != http.StatusNotImplemented {
+		t.Error("state change should not be implemented: ", err1)
+	}
+	consent, err2 := consent_helper.GetConsent(config.ApplicationID, tr_uuid)
+	if err2 != nil || hyperledger.NormalizeState(consent.State) != hyperledger.STATE_ACTIVE {
+		t.Error("consent changed: ", consent.ToString(), err2)
+	}
+}
+
+func TestEffectiveStateNominal(t *testing.T) {
+	today := time.Now()
+	consent := hyperledger.Consent{State: hyperledger.CONSENT_ACTIVE, Dt_begin: common.GetStringDateNow(-2), Dt_end: common.GetStringDateNow(-1)}
+	if consent.EffectiveState(today) != hyperledger.STATE_EXPIRED || consent.IsActive(today) {
+		t.Error("out of date consent not expired")
+	}
+	consent.State = hyperledger.CONSENT_INACTIVE
+	if consent.EffectiveState(today) != hyperledger.STATE_REVOKED {
+		t.Error("legacy removed consent not revoked")
+	}
+	if !hyperledger.CanTransition(hyperledger.STATE_SUSPENDED, hyperledger.STATE_ACTIVE) || hyperledger.CanTransition(hyperledger.STATE_REVOKED, hyperledger.STATE_ACTIVE) {
+		t.Error("bad transitions")
 	}
 }
 
@@ -343,3 +390,40 @@ func TestGetConsents4ConsumerNominal(t *testing.T) {
 		t.Error("3 expected but ", len(consents))
 	}
 }
+
+func TestCreateConsentPeerError(t *testing.T) {
+	if peer == nil {
+		t.Skip("peer errors can only be injected in the fake peer")
+	}
+	peer.FailNext("PostConsent", peertest.CHAINCODE_ERROR, "Invocation failure")
+	response, err := consent_helper.UnactivateConsent(config.ApplicationID, "unknown")
+	if err != nil || response.IsOK() {
+		t.Error("removing an unknown consent should fail: ", response.GetError())
+	}
+	tr_uuid, err1 := consent_helper.CreateConsent(config.ApplicationID, "FFFF", "1111", "BP", "R", "2016-09-04", "2016-12-24")
+	if err1 == nil && tr_uuid != "" {
+		t.Error("injected failure not returned")
+	}
+	tr_uuid, err2 := consent_helper.CreateConsent(config.ApplicationID, "FFFF", "1111", "BP", "R", "2016-09-04", "2016-12-24")
+	if err2 != nil {
+		t.Error(err2)
+	}
+	if tr_uuid == "" {
+		t.Error("transaction uuid expected after the injected failure")
+	}
+}
+
+func TestGetConsentPeerError(t *testing.T) {
+	if peer == nil {
+		t.Skip("peer errors can only be injected in the fake peer")
+	}
+	_, err := consent_helper.GetConsent(config.ApplicationID, "unknown")
+	if common.ToHttpError(err).Status != http.StatusNotFound {
+		t.Error("unknown consent should be not found: ", err)
+	}
+	peer.FailNext("GetConsent", peertest.CHAINCODE_ERROR, "Query failure")
+	_, err1 := consent_helper.GetConsent(config.ApplicationID, "unknown")
+	if common.ToHttpError(err1).Status != http.StatusBadGateway {
+		t.Error("peer failure should be a ledger error: ", err1)
+	}
+}
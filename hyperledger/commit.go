@@ -0,0 +1,73 @@
+/*
+Copyright Pascal Limeux. 2017 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hyperledger
+
+import (
+	"github.com/pascallimeux/ocms2/modules/log"
+	"time"
+)
+
+const (
+	TX_PENDING           = "pending"   // submitted, not yet visible on the ledger
+	TX_COMMITTED         = "committed" // visible on the ledger
+	TX_FAILED            = "failed"    // not visible on the ledger after the transaction timeout, a late commit turns it back to committed
+	COMMIT_POLL_INTERVAL = 200 * time.Millisecond
+)
+
+// True when the transaction is visible on the ledger, the peer answers an unknown transaction with an error message
+func IsCommitted(ledger ConsentLedger, transaction_uuid string) (bool, error) {
+	log.Trace(log.Here(), "IsCommitted(", transaction_uuid, ") : calling method -")
+	transaction, err := ledger.GetTransaction(transaction_uuid)
+	if err != nil {
+		return false, err
+	}
+	return transaction.Txid != "", nil
+}
+
+// Poll the ledger until the transaction is committed or the timeout elapses, false on timeout
+func WaitForCommit(ledger ConsentLedger, transaction_uuid string, timeout time.Duration) (bool, error) {
+	log.Trace(log.Here(), "WaitForCommit(", transaction_uuid, ") : calling method -")
+	deadline := time.Now().Add(timeout)
+	for {
+		committed, err := IsCommitted(ledger, transaction_uuid)
+		if err != nil || committed {
+			return committed, err
+		}
+		wait := deadline.Sub(time.Now())
+		if wait <= 0 {
+			return false, nil
+		}
+		if wait > COMMIT_POLL_INTERVAL {
+			wait = COMMIT_POLL_INTERVAL
+		}
+		time.Sleep(wait)
+	}
+}
+
+// Status of a transaction submitted at the given date. The peer tells committed transactions only, so failed
+// is a heuristic and a transaction committed after the timeout is reported committed again.
+func TransactionStatus(ledger ConsentLedger, transaction_uuid string, submittedAt time.Time, timeout time.Duration) (string, error) {
+	log.Trace(log.Here(), "TransactionStatus(", transaction_uuid, ") : calling method -")
+	committed, err := IsCommitted(ledger, transaction_uuid)
+	if err != nil {
+		return "", err
+	}
+	if committed {
+		return TX_COMMITTED, nil
+	}
+	if time.Since(submittedAt) < timeout {
+		return TX_PENDING, nil
+	}
+	return TX_FAILED, nil
+}
@@ -0,0 +1,56 @@
+/*
+Copyright Pascal Limeux. 2017 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hyperledger_test
+
+import (
+	"github.com/pascallimeux/ocms2/hyperledger"
+	"testing"
+	"time"
+)
+
+func TestWaitForCommitNominal(t *testing.T) {
+	ledger := hyperledger.NewMemoryLedger(config.ChainCodeName)
+	tr_uuid, err := ledger.CreateConsent(config.ApplicationID, "1111", "2222", "BP", "R", "2017-01-01", "2099-01-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	committed, err2 := hyperledger.WaitForCommit(ledger, tr_uuid, time.Second)
+	if err2 != nil || !committed {
+		t.Error("transaction ", tr_uuid, " not committed: ", err2)
+	}
+	status, err3 := hyperledger.TransactionStatus(ledger, tr_uuid, time.Now(), time.Second)
+	if err3 != nil || status != hyperledger.TX_COMMITTED {
+		t.Error("bad status ", status, " for ", tr_uuid, ": ", err3)
+	}
+}
+
+func TestWaitForCommitTimeout(t *testing.T) {
+	ledger := hyperledger.NewMemoryLedger(config.ChainCodeName)
+	start := time.Now()
+	committed, err := hyperledger.WaitForCommit(ledger, "unknown", 3*hyperledger.COMMIT_POLL_INTERVAL)
+	if err != nil || committed {
+		t.Error("unknown transaction committed: ", err)
+	}
+	if time.Since(start) < 3*hyperledger.COMMIT_POLL_INTERVAL {
+		t.Error("timeout not honoured: ", time.Since(start))
+	}
+	status, _ := hyperledger.TransactionStatus(ledger, "unknown", time.Now(), time.Minute)
+	if status != hyperledger.TX_PENDING {
+		t.Error("recent transaction should be pending, got ", status)
+	}
+	status, _ = hyperledger.TransactionStatus(ledger, "unknown", time.Now().Add(-2*time.Minute), time.Minute)
+	if status != hyperledger.TX_FAILED {
+		t.Error("old transaction should be failed, got ", status)
+	}
+}
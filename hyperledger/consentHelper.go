@@ -16,10 +16,17 @@ package hyperledger
 import (
 	"encoding/json"
 	"errors"
+	"github.com/pascallimeux/ocms2/modules/common"
 	"github.com/pascallimeux/ocms2/modules/log"
 	"strings"
 )
 
+const (
+	CHAINCODE_ERROR_CODE = -32003
+	QUERY_FAILURE        = "Error when querying chaincode: "
+	CONSENT_NOT_FOUND    = "not found"
+)
+
 type Consent_Helper struct {
 	HP_helper     HP_Helper
 	ChainCodePath string
@@ -93,6 +100,11 @@ func (c *Consent_Helper) GetTRConsent(consentID string) (Transaction, error) {
 	return response, err
 }
 
+func (c *Consent_Helper) GetTransaction(transaction_uuid string) (Transaction, error) {
+	log.Trace(log.Here(), "GetTransaction(", transaction_uuid, ") : calling method -")
+	return c.HP_helper.GetTransaction(transaction_uuid)
+}
+
 func (c *Consent_Helper) CreateConsent(appID, ownerID, consumerID, datatype, dataaccess, dt_begin, dt_end string) (string, error) {
 	log.Trace(log.Here(), "CreateConsent() : calling method -")
 	function := "PostConsent"
@@ -101,6 +113,12 @@ func (c *Consent_Helper) CreateConsent(appID, ownerID, consumerID, datatype, dat
 	return extractTransactionUUID(response, err)
 }
 
+// The deployed chaincode has no pending consents, a consent request cannot wait there for the owner approval
+func (c *Consent_Helper) CreateConsentRequest(appID, ownerID, consumerID, datatype, dataaccess, dt_begin, dt_end string) (string, error) {
+	log.Trace(log.Here(), "CreateConsentRequest() : calling method -")
+	return "", common.NewNotImplementedError("consent requests are not supported by the Hyperledger chaincode")
+}
+
 func (c *Consent_Helper) GetConsent(appID, consentID string) (Consent, error) {
 	log.Trace(log.Here(), "GetConsent(", consentID, ") : calling method -")
 	function := "GetConsent"
@@ -165,14 +183,35 @@ func (c *Consent_Helper) UnactivateConsent(appID, consentID string) (Response, e
 	return response, err
 }
 
+// The deployed chaincode only knows active and removed consents, it cannot suspend, resume, approve or reject one
+func (c *Consent_Helper) SetConsentState(appID, consentID, state string) (string, error) {
+	log.Trace(log.Here(), "SetConsentState() : calling method -")
+	return "", common.NewNotImplementedError("consent state changes are not supported by the Hyperledger chaincode")
+}
+
 func extractConsent(response Response, err error) (Consent, error) {
 	var consent Consent
 	if err != nil {
 		return consent, err
 	}
+	if isConsentNotFound(response) {
+		return consent, common.NewNotFoundError("consent not found: " + response.GetError())
+	}
+	if !response.IsOK() {
+		return consent, common.NewLedgerError(response.GetError())
+	}
 	dec := json.NewDecoder(strings.NewReader(response.GetMessage()))
 	err = dec.Decode(&consent)
-	return consent, err
+	if err != nil {
+		return consent, common.NewLedgerError("unreadable consent: " + err.Error())
+	}
+	return consent, nil
+}
+
+// The chaincode answers a query on an unknown consent with a query failure saying the consent is not found.
+// Every other failure (chaincode not deployed, unknown method, peer error) is a ledger error.
+func isConsentNotFound(response Response) bool {
+	return response.Error.Code == CHAINCODE_ERROR_CODE && strings.HasPrefix(response.Error.Data, QUERY_FAILURE) && strings.Contains(response.Error.Data, CONSENT_NOT_FOUND)
 }
 
 func extractConsents(response Response, err error) ([]Consent, error) {
@@ -180,9 +219,15 @@ func extractConsents(response Response, err error) ([]Consent, error) {
 	if err != nil {
 		return consents, err
 	}
+	if !response.IsOK() {
+		return consents, common.NewLedgerError(response.GetError())
+	}
 	dec := json.NewDecoder(strings.NewReader(response.GetMessage()))
 	err = dec.Decode(&consents)
-	return consents, err
+	if err != nil {
+		return consents, common.NewLedgerError("unreadable consents: " + err.Error())
+	}
+	return consents, nil
 }
 
 func extractTransactionUUID(response Response, err error) (string, error) {
@@ -190,6 +235,9 @@ func extractTransactionUUID(response Response, err error) (string, error) {
 	if err != nil {
 		return uuid, err
 	}
+	if !response.IsOK() {
+		return uuid, common.NewLedgerError(response.GetError())
+	}
 	uuid = response.GetMessage()
 	return uuid, err
 }
@@ -198,6 +246,9 @@ func extractIsConsent(response Response, err error) (bool, error) {
 	if err != nil {
 		return false, err
 	}
+	if !response.IsOK() {
+		return false, common.NewLedgerError(response.GetError())
+	}
 	if response.GetMessage() == "True" {
 		return true, nil
 	} else {
@@ -212,7 +263,6 @@ func extractStatusOK(response Response, err error) (bool, error) {
 	if response.IsOK() {
 		return true, nil
 	} else {
-		err = errors.New(response.GetError())
-		return false, err
+		return false, common.NewLedgerError(response.GetError())
 	}
 }
@@ -19,6 +19,7 @@ import (
 	"github.com/pascallimeux/ocms2/modules/common"
 	"github.com/pascallimeux/ocms2/modules/log"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"time"
 )
@@ -55,7 +56,7 @@ func (h *HP_Helper) Registar(enrollId, enrollSecret string) (SimpleResponse, err
 	log.Trace(log.Here(), "BODY: ", string(contentBytes))
 	resp, err2 := h.client.Post(url, CONTENTTYPE, bytes.NewBuffer(contentBytes))
 	if err2 != nil {
-		return response, err2
+		return response, transportError(err2)
 	}
 	defer resp.Body.Close()
 	err3 := BuildResponse(&response, resp)
@@ -72,7 +73,7 @@ func (h *HP_Helper) IsRegistar(enrollId string) (SimpleResponse, error) {
 	log.Trace(log.Here(), "URL: ", url)
 	resp, err2 := h.client.Get(url)
 	if err2 != nil {
-		return response, err2
+		return response, transportError(err2)
 	}
 	defer resp.Body.Close()
 	err3 := BuildResponse(&response, resp)
@@ -94,7 +95,7 @@ func (h *HP_Helper) DeployChainCode(smartcontract_path, hp_account, function str
 	log.Trace(log.Here(), "BODY: ", string(contentBytes))
 	resp, err2 := h.client.Post(url, CONTENTTYPE, bytes.NewBuffer(contentBytes))
 	if err2 != nil {
-		return response, err2
+		return response, transportError(err2)
 	}
 	defer resp.Body.Close()
 	err3 := BuildResponse(&response, resp)
@@ -116,7 +117,7 @@ func (h *HP_Helper) Invoke(chaincode_name, hp_account, function string, args []s
 	log.Trace(log.Here(), "BODY: ", string(contentBytes))
 	resp, err2 := h.client.Post(url, CONTENTTYPE, bytes.NewBuffer(contentBytes))
 	if err2 != nil {
-		return response, err2
+		return response, transportError(err2)
 	}
 	defer resp.Body.Close()
 	err3 := BuildResponse(&response, resp)
@@ -138,7 +139,7 @@ func (h *HP_Helper) Query(chaincode_name, hp_account, function string, args []st
 	log.Trace(log.Here(), "BODY: ", string(contentBytes))
 	resp, err2 := h.client.Post(url, CONTENTTYPE, bytes.NewBuffer(contentBytes))
 	if err2 != nil {
-		return response, err2
+		return response, transportError(err2)
 	}
 	defer resp.Body.Close()
 	err3 := BuildResponse(&response, resp)
@@ -155,7 +156,7 @@ func (h *HP_Helper) GetTransaction(transaction_uuid string) (Transaction, error)
 	log.Trace(log.Here(), "URL: ", url)
 	resp, err2 := h.client.Get(url)
 	if err2 != nil {
-		return response, err2
+		return response, transportError(err2)
 	}
 	defer resp.Body.Close()
 	err3 := BuildResponse(&response, resp)
@@ -167,12 +168,12 @@ func BuildResponse(response interface{}, resp *http.Response) error {
 	log.Trace(log.Here(), "BuildResponse() : calling method -")
 	bytes, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return err
+		return transportError(err)
 	}
 	log.Trace(log.Here(), "RAW RESPONSE: ", string(bytes))
 	err = json.Unmarshal(bytes, &response)
 	if err != nil {
-		return err
+		return common.NewLedgerError("unreadable ledger response: " + err.Error())
 	}
 	responseToString, err := common.StructToString(response)
 	if err == nil {
@@ -180,3 +181,12 @@ func BuildResponse(response interface{}, resp *http.Response) error {
 	}
 	return nil
 }
+
+// Peer unreachable or too slow
+func transportError(err error) error {
+	log.Error(log.Here(), "ledger transport error: ", err.Error())
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return common.NewTimeoutError("ledger timeout: " + err.Error())
+	}
+	return common.NewLedgerError("ledger unreachable: " + err.Error())
+}
@@ -0,0 +1,507 @@
+/*
+Copyright Pascal Limeux. 2017 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+       http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hyperledger
+
+import (
+	"database/sql"
+	"encoding/json"
+	"github.com/pascallimeux/ocms2/modules/common"
+	"github.com/pascallimeux/ocms2/modules/log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const INDEX_COLUMNS = "Consent_id, Application_id, Owner_id, Consumer_id, Datatype, Dataaccess, Dt_begin, Dt_end, State, Transitions"
+
+// Local SQLite index of the consents, reads are served from the index while
+// writes go to the ledger first and are applied to the index once committed.
+// An application index older than Staleness is reloaded from the ledger.
+type Index_Ledger struct {
+	Ledger        ConsentLedger
+	Db            *sql.DB
+	Staleness     time.Duration
+	CommitTimeout time.Duration
+	mutex         sync.Mutex // index updates
+	apps          map[string]*appIndex
+}
+
+// Reloads of an application index are serialized apart from the other applications, the
+// ledger is read without the index mutex. Writes counts the updates, a reload which saw it
+// change while reading the ledger holds an outdated snapshot.
+type appIndex struct {
+	reload sync.Mutex
+	writes int
+}
+
+// Differences between the index and the ledger for an application
+type Drift struct {
+	AppID      string    `json:"appid"`
+	Missing    []string  `json:"missing"`    // on the ledger, not in the index
+	Unknown    []string  `json:"unknown"`    // in the index, not on the ledger
+	Mismatched []string  `json:"mismatched"` // state or attributes differ
+	Repaired   bool      `json:"repaired"`
+	CheckedAt  time.Time `json:"checked_at"`
+}
+
+func (d *Drift) IsEmpty() bool {
+	return len(d.Missing) == 0 && len(d.Unknown) == 0 && len(d.Mismatched) == 0
+}
+
+func NewIndexLedger(ledger ConsentLedger, db *sql.DB, staleness, commitTimeout time.Duration) (*Index_Ledger, error) {
+	log.Trace(log.Here(), "NewIndexLedger() : calling method -")
+	index := &Index_Ledger{Ledger: ledger, Db: db, Staleness: staleness, CommitTimeout: commitTimeout, apps: make(map[string]*appIndex)}
+	return index, nil
+}
+
+func (x *Index_Ledger) CreateConsent(appID, ownerID, consumerID, datatype, dataaccess, dt_begin, dt_end string) (string, error) {
+	log.Trace(log.Here(), "CreateConsent() : calling method -")
+	consentID, err := x.Ledger.CreateConsent(appID, ownerID, consumerID, datatype, dataaccess, dt_begin, dt_end)
+	if err != nil {
+		return consentID, err
+	}
+	consent := Consent{AppID: appID, ConsentID: consentID, OwnerID: ownerID, ConsumerID: consumerID, Datatype: datatype, Dataaccess: dataaccess, Dt_begin: dt_begin, Dt_end: dt_end}
+	consent.addTransition(STATE_ACTIVE, time.Now())
+	x.onCommit(appID, consentID, func() { x.apply(consent) })
+	return consentID, nil
+}
+
+func (x *Index_Ledger) CreateConsentRequest(appID, ownerID, consumerID, datatype, dataaccess, dt_begin, dt_end string) (string, error) {
+	log.Trace(log.Here(), "CreateConsentRequest() : calling method -")
+	consentID, err := x.Ledger.CreateConsentRequest(appID, ownerID, consumerID, datatype, dataaccess, dt_begin, dt_end)
+	if err != nil {
+		return consentID, err
+	}
+	consent := Consent{AppID: appID, ConsentID: consentID, OwnerID: ownerID, ConsumerID: consumerID, Datatype: datatype, Dataaccess: dataaccess, Dt_begin: dt_begin, Dt_end: dt_end}
+	consent.addTransition(STATE_PENDING, time.Now())
+	x.onCommit(appID, consentID, func() { x.apply(consent) })
+	return consentID, nil
+}
+
+// A consent missing from the index may come from another OCMS instance, it is read from the ledger
+func (x *Index_Ledger) GetConsent(appID, consentID string) (Consent, error) {
+	log.Trace(log.Here(), "GetConsent(", consentID, ") : calling method -")
+	err := x.refresh(appID)
+	if err != nil {
+		return Consent{}, err
+	}
+	consents, err := x.load("Consent_id = ?", consentID)
+	if err != nil {
+		return Consent{}, err
+	}
+	if len(consents) == 0 {
+		consent, err1 := x.Ledger.GetConsent(appID, consentID)
+		if err1 != nil {
+			return consent, err1
+		}
+		x.apply(consent)
+		return consent, nil
+	}
+	consent := consents[0]
+	if consent.AppID != appID || NormalizeState(consent.State) == STATE_REVOKED {
+		return Consent{}, common.NewNotFoundError("consent " + consentID + " not found")
+	}
+	return consent, nil
+}
+
+func (x *Index_Ledger) GetAllConsents(appID string) ([]Consent, error) {
+	log.Trace(log.Here(), "GetAllConsents() : calling method -")
+	return x.find(appID, func(c Consent) bool { return true }, "")
+}
+
+func (x *Index_Ledger) GetActivesConsents(appID string) ([]Consent, error) {
+	log.Trace(log.Here(), "GetActivesConsents() : calling method -")
+	return x.find(appID, func(c Consent) bool { return NormalizeState(c.State) == STATE_ACTIVE }, "")
+}
+
+func (x *Index_Ledger) GetConsents4Owner(appID, ownerID string) ([]Consent, error) {
+	log.Trace(log.Here(), "GetConsents4Owner() : calling method -")
+	return x.find(appID, func(c Consent) bool { return NormalizeState(c.State) == STATE_ACTIVE }, " and Owner_id = ?", ownerID)
+}
+
+func (x *Index_Ledger) GetConsents4Consumer(appID, consumerID string) ([]Consent, error) {
+	log.Trace(log.Here(), "GetConsents4Consumer() : calling method -")
+	return x.find(appID, func(c Consent) bool { return NormalizeState(c.State) == STATE_ACTIVE }, " and Consumer_id = ?", consumerID)
+}
+
+func (x *Index_Ledger) IsConsent(appID, ownerID, consumerID, datatype, dataaccess string) (bool, error) {
+	log.Trace(log.Here(), "IsConsent() : calling method -")
+	now := time.Now()
+	consents, err := x.find(appID, func(c Consent) bool { return c.IsActive(now) }, " and Owner_id = ? and Consumer_id = ? and Datatype = ? and Dataaccess = ?", ownerID, consumerID, datatype, dataaccess)
+	return len(consents) > 0, err
+}
+
+// A revocation is indexed at once so the consent is no longer granted while it commits,
+// the reconciliation restores the consent if the revocation never commits
+func (x *Index_Ledger) UnactivateConsent(appID, consentID string) (Response, error) {
+	log.Trace(log.Here(), "UnactivateConsent() : calling method -")
+	response, err := x.Ledger.UnactivateConsent(appID, consentID)
+	if err != nil || !response.IsOK() {
+		return response, err
+	}
+	x.transition(appID, consentID, STATE_REVOKED, time.Now())
+	return response, nil
+}
+
+func (x *Index_Ledger) SetConsentState(appID, consentID, state string) (string, error) {
+	log.Trace(log.Here(), "SetConsentState(", consentID, ", ", state, ") : calling method -")
+	tr_uuid, err := x.Ledger.SetConsentState(appID, consentID, state)
+	if err != nil {
+		return tr_uuid, err
+	}
+	date := time.Now()
+	x.onCommit(appID, tr_uuid, func() { x.transition(appID, consentID, state, date) })
+	return tr_uuid, nil
+}
+
+func (x *Index_Ledger) RemoveConsents() (bool, error) {
+	log.Trace(log.Here(), "RemoveConsents() : calling method -")
+	ok, err := x.Ledger.RemoveConsents()
+	if err != nil || !ok {
+		return ok, err
+	}
+	err1 := x.Clear()
+	if err1 != nil {
+		log.Error(log.Here(), "could not clear the consent index: ", err1.Error())
+	}
+	return ok, nil
+}
+
+// Empty the index, every application is reloaded from the ledger at its next read
+func (x *Index_Ledger) Clear() error {
+	log.Trace(log.Here(), "Clear() : calling method -")
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	for _, app := range x.apps {
+		app.writes++
+	}
+	_, err := x.Db.Exec("delete from consent_index")
+	if err != nil {
+		return err
+	}
+	_, err = x.Db.Exec("delete from consent_index_sync")
+	return err
+}
+
+func (x *Index_Ledger) GetTransaction(transaction_uuid string) (Transaction, error) {
+	log.Trace(log.Here(), "GetTransaction(", transaction_uuid, ") : calling method -")
+	return x.Ledger.GetTransaction(transaction_uuid)
+}
+
+// Compare the index of an application with the ledger, the index is reloaded when repair is set
+func (x *Index_Ledger) Reconcile(appID string, repair bool) (Drift, error) {
+	log.Trace(log.Here(), "Reconcile(", appID, ") : calling method -")
+	app := x.app(appID)
+	var writes int
+	if repair {
+		app.reload.Lock()
+		defer app.reload.Unlock()
+		writes = x.writes(app)
+	}
+	drift := Drift{AppID: appID, Missing: []string{}, Unknown: []string{}, Mismatched: []string{}, CheckedAt: time.Now()}
+	ledgerConsents, err1 := x.Ledger.GetAllConsents(appID)
+	if err1 != nil {
+		return drift, err1
+	}
+	indexConsents, err2 := x.load("Application_id = ?", appID)
+	if err2 != nil {
+		return drift, err2
+	}
+	indexed := make(map[string]Consent)
+	for _, consent := range indexConsents {
+		indexed[consent.ConsentID] = consent
+	}
+	for _, consent := range ledgerConsents {
+		indexConsent, ok := indexed[consent.ConsentID]
+		if !ok {
+			drift.Missing = append(drift.Missing, consent.ConsentID)
+			continue
+		}
+		if !sameConsent(consent, indexConsent) {
+			drift.Mismatched = append(drift.Mismatched, consent.ConsentID)
+		}
+		delete(indexed, consent.ConsentID)
+	}
+	for consentID := range indexed {
+		drift.Unknown = append(drift.Unknown, consentID)
+	}
+	sort.Strings(drift.Missing)
+	sort.Strings(drift.Unknown)
+	sort.Strings(drift.Mismatched)
+	if repair && !drift.IsEmpty() {
+		repaired, err3 := x.replace(appID, app, writes, ledgerConsents)
+		if err3 != nil {
+			return drift, err3
+		}
+		drift.Repaired = repaired
+	}
+	return drift, nil
+}
+
+// Reconcile every indexed application each interval until stop is closed
+func (x *Index_Ledger) Reconciliation(interval time.Duration, stop <-chan struct{}) {
+	log.Trace(log.Here(), "Reconciliation() : calling method -")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			appIDs, err := x.applications()
+			if err != nil {
+				log.Error(log.Here(), "reconciliation: ", err.Error())
+				continue
+			}
+			for _, appID := range appIDs {
+				drift, err1 := x.Reconcile(appID, true)
+				if err1 != nil {
+					log.Error(log.Here(), "reconciliation of ", appID, ": ", err1.Error())
+				} else if !drift.IsEmpty() {
+					js, _ := json.Marshal(drift)
+					log.Warning(log.Here(), "consent index drift repaired: ", string(js))
+				}
+			}
+		}
+	}
+}
+
+// Reload the index of an application from the ledger when it is older than Staleness,
+// a read of a fresh index takes no lock and a reload only waits for those of its application
+func (x *Index_Ledger) refresh(appID string) error {
+	fresh, err := x.fresh(appID)
+	if err != nil || fresh {
+		return err
+	}
+	app := x.app(appID)
+	app.reload.Lock()
+	defer app.reload.Unlock()
+	fresh, err = x.fresh(appID)
+	if err != nil || fresh {
+		return err
+	}
+	log.Trace(log.Here(), "reload consent index of ", appID)
+	writes := x.writes(app)
+	consents, err1 := x.Ledger.GetAllConsents(appID)
+	if err1 != nil {
+		return err1
+	}
+	_, err2 := x.replace(appID, app, writes, consents)
+	return err2
+}
+
+func (x *Index_Ledger) fresh(appID string) (bool, error) {
+	var syncedAt time.Time
+	err := x.Db.QueryRow("select SyncedAt from consent_index_sync where Application_id = ?", appID).Scan(&syncedAt)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil && time.Since(syncedAt) < x.Staleness, err
+}
+
+func (x *Index_Ledger) app(appID string) *appIndex {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	app, ok := x.apps[appID]
+	if !ok {
+		app = &appIndex{}
+		x.apps[appID] = app
+	}
+	return app
+}
+
+func (x *Index_Ledger) writes(app *appIndex) int {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	return app.writes
+}
+
+// Replace the index of an application by the consents read from the ledger, unless the index was
+// updated meanwhile: the snapshot may miss the update, the index is kept and reloaded at the next read
+func (x *Index_Ledger) replace(appID string, app *appIndex, writes int, consents []Consent) (bool, error) {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	if app.writes != writes {
+		return false, nil
+	}
+	return true, x.store(appID, consents)
+}
+
+// Replace the index of an application, must be called with the mutex held
+func (x *Index_Ledger) store(appID string, consents []Consent) error {
+	tx, err := x.Db.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("delete from consent_index where Application_id = ?", appID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, consent := range consents {
+		err = insertConsent(tx, consent)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	_, err = tx.Exec("insert or replace into consent_index_sync (Application_id, SyncedAt) values (?, ?)", appID, time.Now())
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Run the index update of a write once its transaction is committed. A transaction still uncommitted
+// after CommitTimeout leaves the index untouched and the application is reloaded at its next read.
+func (x *Index_Ledger) onCommit(appID, transaction_uuid string, update func()) {
+	committed, err := IsCommitted(x.Ledger, transaction_uuid)
+	if err == nil && committed {
+		update()
+		return
+	}
+	go func() {
+		committed, err1 := WaitForCommit(x.Ledger, transaction_uuid, x.CommitTimeout)
+		if err1 != nil || !committed {
+			log.Warning(log.Here(), "transaction ", transaction_uuid, " not committed, the consent index of ", appID, " will be reloaded")
+			x.mutex.Lock()
+			x.invalidate(appID)
+			x.mutex.Unlock()
+			return
+		}
+		update()
+	}()
+}
+
+// Index a consent written on the ledger, on failure the application index is reloaded at the next read
+func (x *Index_Ledger) apply(consent Consent) {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	x.index(consent)
+}
+
+// Must be called with the mutex held
+func (x *Index_Ledger) index(consent Consent) {
+	x.updated(consent.AppID)
+	tx, err := x.Db.Begin()
+	if err == nil {
+		err = insertConsent(tx, consent)
+		if err == nil {
+			err = tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+	}
+	if err != nil {
+		log.Error(log.Here(), "could not index consent ", consent.ConsentID, ": ", err.Error())
+		x.invalidate(consent.AppID)
+	}
+}
+
+func (x *Index_Ledger) transition(appID, consentID, state string, date time.Time) {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	consents, err := x.load("Consent_id = ?", consentID)
+	if err != nil || len(consents) == 0 {
+		x.invalidate(appID)
+		return
+	}
+	consent := consents[0]
+	consent.addTransition(state, date)
+	x.index(consent)
+}
+
+// Reload the index of an application at its next read, must be called with the mutex held
+func (x *Index_Ledger) invalidate(appID string) {
+	x.updated(appID)
+	x.Db.Exec("delete from consent_index_sync where Application_id = ?", appID)
+}
+
+// Count an update of an application index, must be called with the mutex held
+func (x *Index_Ledger) updated(appID string) {
+	if app, ok := x.apps[appID]; ok {
+		app.writes++
+	}
+}
+
+func (x *Index_Ledger) find(appID string, match func(Consent) bool, where string, args ...interface{}) ([]Consent, error) {
+	err := x.refresh(appID)
+	if err != nil {
+		return nil, err
+	}
+	consents, err := x.load("Application_id = ?"+where, append([]interface{}{appID}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Consent, 0, len(consents))
+	for _, consent := range consents {
+		if match(consent) {
+			result = append(result, consent)
+		}
+	}
+	return result, nil
+}
+
+// Indexed consents matching the where clause
+func (x *Index_Ledger) load(where string, args ...interface{}) ([]Consent, error) {
+	consents := make([]Consent, 0)
+	rows, err := x.Db.Query("select "+INDEX_COLUMNS+" from consent_index where "+where+" order by Consent_id", args...)
+	if err != nil {
+		return consents, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		consent := Consent{}
+		var transitions string
+		err1 := rows.Scan(&consent.ConsentID, &consent.AppID, &consent.OwnerID, &consent.ConsumerID, &consent.Datatype, &consent.Dataaccess, &consent.Dt_begin, &consent.Dt_end, &consent.State, &transitions)
+		if err1 != nil {
+			return consents, err1
+		}
+		json.Unmarshal([]byte(transitions), &consent.Transitions)
+		consents = append(consents, consent)
+	}
+	return consents, rows.Err()
+}
+
+func (x *Index_Ledger) applications() ([]string, error) {
+	appIDs := make([]string, 0)
+	rows, err := x.Db.Query("select Application_id from consent_index_sync union select distinct Application_id from consent_index")
+	if err != nil {
+		return appIDs, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var appID string
+		err1 := rows.Scan(&appID)
+		if err1 != nil {
+			return appIDs, err1
+		}
+		appIDs = append(appIDs, appID)
+	}
+	return appIDs, rows.Err()
+}
+
+func insertConsent(tx *sql.Tx, consent Consent) error {
+	transitions, _ := json.Marshal(consent.Transitions)
+	_, err := tx.Exec("insert or replace into consent_index ("+INDEX_COLUMNS+") values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		consent.ConsentID, consent.AppID, consent.OwnerID, consent.ConsumerID, consent.Datatype, consent.Dataaccess, consent.Dt_begin, consent.Dt_end, consent.State, string(transitions))
+	return err
+}
+
+func sameConsent(c1, c2 Consent) bool {
+	return c1.AppID == c2.AppID && NormalizeState(c1.State) == NormalizeState(c2.State) && c1.OwnerID == c2.OwnerID &&
+		c1.ConsumerID == c2.ConsumerID && c1.Datatype == c2.Datatype && c1.Dataaccess == c2.Dataaccess &&
+		c1.Dt_begin == c2.Dt_begin && c1.Dt_end == c2.Dt_end
+}
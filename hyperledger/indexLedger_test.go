@@ -0,0 +1,246 @@
+/*
+Copyright Pascal Limeux. 2017 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hyperledger_test
+
+import (
+	"database/sql"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/pascallimeux/ocms2/hyperledger"
+	"github.com/pascallimeux/ocms2/model"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Database of the index tests, with the OCMS tables
+func openIndexDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", "/tmp/index_test.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlContext := model.SqlContext{Db: db}
+	err1 := sqlContext.MigrateUp()
+	if err1 != nil {
+		t.Fatal(err1)
+	}
+	return db
+}
+
+func newIndexLedger(t *testing.T, staleness time.Duration) (*hyperledger.Memory_Ledger, *hyperledger.Index_Ledger) {
+	db := openIndexDB(t)
+	ledger := hyperledger.NewMemoryLedger(config.ChainCodeName)
+	index, err2 := hyperledger.NewIndexLedger(ledger, db, staleness, time.Second)
+	if err2 != nil {
+		t.Fatal(err2)
+	}
+	err3 := index.Clear()
+	if err3 != nil {
+		t.Fatal(err3)
+	}
+	return ledger, index
+}
+
+func TestIndexLedgerNominal(t *testing.T) {
+	_, index := newIndexLedger(t, time.Hour)
+	defer index.Db.Close()
+	consentID, err := index.CreateConsent(config.ApplicationID, "I001", "I002", "BP", "R", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	isConsent, err2 := index.IsConsent(config.ApplicationID, "I001", "I002", "BP", "R")
+	if err2 != nil || !isConsent {
+		t.Error("indexed consent not granted: ", err2)
+	}
+	consents, _ := index.GetConsents4Owner(config.ApplicationID, "I001")
+	if len(consents) != 1 || consents[0].ConsentID != consentID {
+		t.Error("indexed consent not listed: ", consents)
+	}
+	_, err3 := index.SetConsentState(config.ApplicationID, consentID, hyperledger.STATE_SUSPENDED)
+	if err3 != nil {
+		t.Fatal(err3)
+	}
+	isConsent, _ = index.IsConsent(config.ApplicationID, "I001", "I002", "BP", "R")
+	if isConsent {
+		t.Error("suspended consent still granted")
+	}
+	response, err4 := index.UnactivateConsent(config.ApplicationID, consentID)
+	if err4 != nil || !response.IsOK() {
+		t.Fatal("revoke consent: ", err4, response.GetError())
+	}
+	_, err5 := index.GetConsent(config.ApplicationID, consentID)
+	if err5 == nil {
+		t.Error("revoked consent still readable")
+	}
+	consent, _ := index.GetAllConsents(config.ApplicationID)
+	if len(consent) != 1 || len(consent[0].Transitions) != 3 {
+		t.Error("bad transitions: ", consent)
+	}
+	drift, _ := index.Reconcile(config.ApplicationID, false)
+	if !drift.IsEmpty() {
+		t.Error("drift after writes through the index: ", drift)
+	}
+}
+
+func TestIndexLedgerDrift(t *testing.T) {
+	ledger, index := newIndexLedger(t, time.Hour)
+	defer index.Db.Close()
+	index.GetAllConsents(config.ApplicationID)
+	indexedID, _ := index.CreateConsent(config.ApplicationID, "D001", "D002", "BP", "R", "", "")
+	changedID, _ := index.CreateConsent(config.ApplicationID, "D001", "D003", "BP", "R", "", "")
+
+	// Writes which bypass the index, as another OCMS instance would do
+	missingID, _ := ledger.CreateConsent(config.ApplicationID, "D001", "D004", "BP", "R", "", "")
+	ledger.SetConsentState(config.ApplicationID, changedID, hyperledger.STATE_SUSPENDED)
+	consents, _ := index.GetConsents4Owner(config.ApplicationID, "D001")
+	if len(consents) != 2 {
+		t.Error("index reloaded before its staleness: ", consents)
+	}
+
+	drift, err := index.Reconcile(config.ApplicationID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drift.Missing) != 1 || drift.Missing[0] != missingID || len(drift.Mismatched) != 1 || drift.Mismatched[0] != changedID || len(drift.Unknown) != 0 || drift.Repaired {
+		t.Error("bad drift: ", drift)
+	}
+	drift, _ = index.Reconcile(config.ApplicationID, true)
+	if !drift.Repaired {
+		t.Error("drift not repaired: ", drift)
+	}
+	drift, _ = index.Reconcile(config.ApplicationID, false)
+	if !drift.IsEmpty() {
+		t.Error("drift after repair: ", drift)
+	}
+	consents, _ = index.GetConsents4Owner(config.ApplicationID, "D001")
+	if len(consents) != 2 || consents[0].ConsentID == changedID || consents[1].ConsentID == changedID {
+		t.Error("index not repaired: ", consents, indexedID)
+	}
+}
+
+func TestIndexLedgerStaleness(t *testing.T) {
+	ledger, index := newIndexLedger(t, 50*time.Millisecond)
+	defer index.Db.Close()
+	index.CreateConsent(config.ApplicationID, "S001", "S002", "BP", "R", "", "")
+	ledger.CreateConsent(config.ApplicationID, "S001", "S003", "BP", "R", "", "")
+	isConsent, _ := index.IsConsent(config.ApplicationID, "S001", "S003", "BP", "R")
+	if !isConsent {
+		t.Error("first read does not load the index from the ledger")
+	}
+	ledger.CreateConsent(config.ApplicationID, "S001", "S004", "BP", "R", "", "")
+	isConsent, _ = index.IsConsent(config.ApplicationID, "S001", "S004", "BP", "R")
+	if isConsent {
+		t.Error("index reloaded before its staleness")
+	}
+	time.Sleep(60 * time.Millisecond)
+	isConsent, _ = index.IsConsent(config.ApplicationID, "S001", "S004", "BP", "R")
+	if !isConsent {
+		t.Error("stale index not reloaded")
+	}
+}
+
+func TestIndexLedgerReadThrough(t *testing.T) {
+	ledger, index := newIndexLedger(t, time.Hour)
+	defer index.Db.Close()
+	index.GetAllConsents(config.ApplicationID)
+	consentID, _ := ledger.CreateConsent(config.ApplicationID, "T001", "T002", "BP", "R", "", "")
+	consent, err := index.GetConsent(config.ApplicationID, consentID)
+	if err != nil || consent.OwnerID != "T001" {
+		t.Error("consent not read from the ledger: ", err)
+	}
+	drift, _ := index.Reconcile(config.ApplicationID, false)
+	if !drift.IsEmpty() {
+		t.Error("consent read from the ledger not indexed: ", drift)
+	}
+}
+
+// Memory ledger whose transactions stay uncommitted until commit is called
+type uncommittedLedger struct {
+	*hyperledger.Memory_Ledger
+	committed int32
+}
+
+func (l *uncommittedLedger) GetTransaction(transaction_uuid string) (hyperledger.Transaction, error) {
+	if atomic.LoadInt32(&l.committed) == 0 {
+		return hyperledger.Transaction{}, nil
+	}
+	return l.Memory_Ledger.GetTransaction(transaction_uuid)
+}
+
+func (l *uncommittedLedger) commit() {
+	atomic.StoreInt32(&l.committed, 1)
+}
+
+func TestIndexLedgerIndexAfterCommit(t *testing.T) {
+	db := openIndexDB(t)
+	defer db.Close()
+	ledger := &uncommittedLedger{Memory_Ledger: hyperledger.NewMemoryLedger(config.ChainCodeName)}
+	index, err1 := hyperledger.NewIndexLedger(ledger, db, time.Hour, 2*time.Second)
+	if err1 != nil {
+		t.Fatal(err1)
+	}
+	index.Clear()
+	index.GetAllConsents(config.ApplicationID)
+	_, err2 := index.CreateConsent(config.ApplicationID, "U001", "U002", "BP", "R", "", "")
+	if err2 != nil {
+		t.Fatal(err2)
+	}
+	isConsent, _ := index.IsConsent(config.ApplicationID, "U001", "U002", "BP", "R")
+	if isConsent {
+		t.Error("uncommitted consent granted")
+	}
+	ledger.commit()
+	time.Sleep(2 * hyperledger.COMMIT_POLL_INTERVAL)
+	isConsent, _ = index.IsConsent(config.ApplicationID, "U001", "U002", "BP", "R")
+	if !isConsent {
+		t.Error("committed consent not indexed")
+	}
+}
+
+// Memory ledger whose full reads of one application are slow
+type slowLedger struct {
+	*hyperledger.Memory_Ledger
+	slowAppID string
+	delay     time.Duration
+}
+
+func (l *slowLedger) GetAllConsents(appID string) ([]hyperledger.Consent, error) {
+	if appID == l.slowAppID {
+		time.Sleep(l.delay)
+	}
+	return l.Memory_Ledger.GetAllConsents(appID)
+}
+
+func TestIndexLedgerSlowReload(t *testing.T) {
+	db := openIndexDB(t)
+	defer db.Close()
+	ledger := &slowLedger{Memory_Ledger: hyperledger.NewMemoryLedger(config.ChainCodeName), slowAppID: "SLOWAPP", delay: time.Second}
+	index, _ := hyperledger.NewIndexLedger(ledger, db, time.Hour, time.Second)
+	index.Clear()
+	ledger.CreateConsent(config.ApplicationID, "W001", "W002", "BP", "R", "", "")
+	done := make(chan struct{})
+	go func() {
+		index.GetAllConsents("SLOWAPP")
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	start := time.Now()
+	isConsent, err := index.IsConsent(config.ApplicationID, "W001", "W002", "BP", "R")
+	if err != nil || !isConsent {
+		t.Error("consent not granted: ", err)
+	}
+	if time.Since(start) > ledger.delay/2 {
+		t.Error("read waited for the reload of another application: ", time.Since(start))
+	}
+	<-done
+}
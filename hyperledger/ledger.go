@@ -0,0 +1,35 @@
+/*
+Copyright Pascal Limeux. 2017 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+       http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hyperledger
+
+const (
+	HYPERLEDGER_LEDGER = "hyperledger"
+	MEMORY_LEDGER      = "memory"
+)
+
+// Consent operations needed by the OCMS controllers, whatever the ledger backend
+type ConsentLedger interface {
+	CreateConsent(appID, ownerID, consumerID, datatype, dataaccess, dt_begin, dt_end string) (string, error)
+	CreateConsentRequest(appID, ownerID, consumerID, datatype, dataaccess, dt_begin, dt_end string) (string, error)
+	GetConsent(appID, consentID string) (Consent, error)
+	GetAllConsents(appID string) ([]Consent, error)
+	GetActivesConsents(appID string) ([]Consent, error)
+	GetConsents4Owner(appID, ownerID string) ([]Consent, error)
+	GetConsents4Consumer(appID, consumerID string) ([]Consent, error)
+	IsConsent(appID, ownerID, consumerID, datatype, dataaccess string) (bool, error)
+	UnactivateConsent(appID, consentID string) (Response, error)
+	SetConsentState(appID, consentID, state string) (string, error)
+	RemoveConsents() (bool, error)
+	GetTransaction(transaction_uuid string) (Transaction, error)
+}
@@ -0,0 +1,121 @@
+/*
+Copyright Pascal Limeux. 2017 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+       http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hyperledger
+
+import (
+	"github.com/pascallimeux/ocms2/modules/common"
+	"time"
+)
+
+// Consent lifecycle states
+const (
+	STATE_PENDING   = "pending"
+	STATE_ACTIVE    = "active"
+	STATE_SUSPENDED = "suspended"
+	STATE_EXPIRED   = "expired"
+	STATE_REVOKED   = "revoked"
+	STATE_REJECTED  = "rejected"
+)
+
+// Allowed transitions, expired is never set but computed from Dt_end
+var transitions = map[string][]string{
+	STATE_PENDING:   {STATE_ACTIVE, STATE_REJECTED, STATE_REVOKED},
+	STATE_ACTIVE:    {STATE_SUSPENDED, STATE_REVOKED},
+	STATE_SUSPENDED: {STATE_ACTIVE, STATE_REVOKED},
+	STATE_EXPIRED:   {STATE_REVOKED},
+}
+
+type Transition struct {
+	State string
+	Date  string
+}
+
+func CanTransition(from, to string) bool {
+	for _, state := range transitions[NormalizeState(from)] {
+		if state == to {
+			return true
+		}
+	}
+	return false
+}
+
+func IsState(state string) bool {
+	switch state {
+	case STATE_PENDING, STATE_ACTIVE, STATE_SUSPENDED, STATE_EXPIRED, STATE_REVOKED, STATE_REJECTED:
+		return true
+	}
+	return false
+}
+
+// Map the True/False states written by the first chaincode version
+func NormalizeState(state string) string {
+	switch state {
+	case CONSENT_ACTIVE, "":
+		return STATE_ACTIVE
+	case CONSENT_INACTIVE:
+		return STATE_REVOKED
+	}
+	return state
+}
+
+// State seen by the API at the given instant, a consent after its validity window is expired
+func (c *Consent) EffectiveState(at time.Time) string {
+	return c.windowState(c.State, at)
+}
+
+// State recorded by the transitions at the given instant, the current one when the consent has no transitions.
+// The second result is false when the consent did not exist yet.
+func (c *Consent) StateAt(at time.Time) (string, bool) {
+	if len(c.Transitions) == 0 {
+		return c.State, true
+	}
+	for i := len(c.Transitions) - 1; i >= 0; i-- {
+		date, err := time.Parse(time.RFC3339, c.Transitions[i].Date)
+		if err == nil && !date.After(at) {
+			return c.Transitions[i].State, true
+		}
+	}
+	return "", false
+}
+
+// Active and inside its validity window at the given instant, a past instant is evaluated
+// with the state the consent had then
+func (c *Consent) IsActive(at time.Time) bool {
+	state, existed := c.StateAt(at)
+	if !existed || c.windowState(state, at) != STATE_ACTIVE {
+		return false
+	}
+	if c.Dt_begin == "" {
+		return true
+	}
+	begin, err := common.DateTimeValue(c.Dt_begin)
+	return err == nil && !at.Before(begin)
+}
+
+func (c *Consent) windowState(state string, at time.Time) string {
+	state = NormalizeState(state)
+	if state == STATE_REVOKED || state == STATE_REJECTED || c.Dt_end == "" {
+		return state
+	}
+	end, err := common.WindowEnd(c.Dt_end)
+	if err == nil && !at.Before(end) {
+		return STATE_EXPIRED
+	}
+	return state
+}
+
+func (c *Consent) addTransition(state string, date time.Time) {
+	c.State = state
+	c.Transitions = append(c.Transitions, Transition{State: state, Date: date.Format(time.RFC3339)})
+}
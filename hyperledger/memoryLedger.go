@@ -0,0 +1,200 @@
+/*
+Copyright Pascal Limeux. 2017 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+       http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hyperledger
+
+import (
+	"crypto/rand"
+	b64 "encoding/base64"
+	"github.com/pascallimeux/ocms2/modules/common"
+	"github.com/pascallimeux/ocms2/modules/log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const CHAINCODE_INVOKE = 2
+
+// In-process ledger emulating the consent chaincode, commits are immediate
+type Memory_Ledger struct {
+	ChainCodeName string
+	mutex         sync.RWMutex
+	consents      map[string]Consent
+	transactions  map[string]Transaction
+}
+
+func NewMemoryLedger(chainCodeName string) *Memory_Ledger {
+	return &Memory_Ledger{ChainCodeName: chainCodeName, consents: make(map[string]Consent), transactions: make(map[string]Transaction)}
+}
+
+func (m *Memory_Ledger) CreateConsent(appID, ownerID, consumerID, datatype, dataaccess, dt_begin, dt_end string) (string, error) {
+	log.Trace(log.Here(), "CreateConsent() : calling method -")
+	return m.create("PostConsent", STATE_ACTIVE, []string{appID, ownerID, consumerID, datatype, dataaccess, dt_begin, dt_end})
+}
+
+func (m *Memory_Ledger) CreateConsentRequest(appID, ownerID, consumerID, datatype, dataaccess, dt_begin, dt_end string) (string, error) {
+	log.Trace(log.Here(), "CreateConsentRequest() : calling method -")
+	return m.create("PostConsentRequest", STATE_PENDING, []string{appID, ownerID, consumerID, datatype, dataaccess, dt_begin, dt_end})
+}
+
+func (m *Memory_Ledger) GetConsent(appID, consentID string) (Consent, error) {
+	log.Trace(log.Here(), "GetConsent(", consentID, ") : calling method -")
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	consent, ok := m.consents[consentID]
+	if !ok || consent.AppID != appID || NormalizeState(consent.State) == STATE_REVOKED {
+		return Consent{}, common.NewNotFoundError("consent " + consentID + " not found")
+	}
+	return consent, nil
+}
+
+func (m *Memory_Ledger) GetAllConsents(appID string) ([]Consent, error) {
+	log.Trace(log.Here(), "GetAllConsents() : calling method -")
+	return m.filter(func(c Consent) bool { return c.AppID == appID }), nil
+}
+
+func (m *Memory_Ledger) GetActivesConsents(appID string) ([]Consent, error) {
+	log.Trace(log.Here(), "GetActivesConsents() : calling method -")
+	return m.filter(func(c Consent) bool { return c.AppID == appID && NormalizeState(c.State) == STATE_ACTIVE }), nil
+}
+
+func (m *Memory_Ledger) GetConsents4Owner(appID, ownerID string) ([]Consent, error) {
+	log.Trace(log.Here(), "GetConsents4Owner() : calling method -")
+	return m.filter(func(c Consent) bool {
+		return c.AppID == appID && NormalizeState(c.State) == STATE_ACTIVE && c.OwnerID == ownerID
+	}), nil
+}
+
+func (m *Memory_Ledger) GetConsents4Consumer(appID, consumerID string) ([]Consent, error) {
+	log.Trace(log.Here(), "GetConsents4Consumer() : calling method -")
+	return m.filter(func(c Consent) bool {
+		return c.AppID == appID && NormalizeState(c.State) == STATE_ACTIVE && c.ConsumerID == consumerID
+	}), nil
+}
+
+func (m *Memory_Ledger) IsConsent(appID, ownerID, consumerID, datatype, dataaccess string) (bool, error) {
+	log.Trace(log.Here(), "IsConsent() : calling method -")
+	now := time.Now()
+	consents := m.filter(func(c Consent) bool {
+		return c.AppID == appID && c.IsActive(now) && c.OwnerID == ownerID && c.ConsumerID == consumerID &&
+			c.Datatype == datatype && c.Dataaccess == dataaccess
+	})
+	return len(consents) > 0, nil
+}
+
+func (m *Memory_Ledger) UnactivateConsent(appID, consentID string) (Response, error) {
+	log.Trace(log.Here(), "UnactivateConsent() : calling method -")
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	consent, ok := m.consents[consentID]
+	if !ok || consent.AppID != appID {
+		return Response{Jsonrpc: JSONRPC, Error: Error{Code: -32003, Message: "consent " + consentID + " not found"}}, nil
+	}
+	tr_uuid, err := m.record("RemoveConsent", []string{appID, consentID})
+	if err != nil {
+		return Response{}, err
+	}
+	consent.addTransition(STATE_REVOKED, time.Now())
+	m.consents[consentID] = consent
+	return Response{Jsonrpc: JSONRPC, Result: Result{Status: "OK", Message: tr_uuid}}, nil
+}
+
+func (m *Memory_Ledger) SetConsentState(appID, consentID, state string) (string, error) {
+	log.Trace(log.Here(), "SetConsentState(", consentID, ", ", state, ") : calling method -")
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	consent, ok := m.consents[consentID]
+	if !ok || consent.AppID != appID {
+		return "", common.NewNotFoundError("consent " + consentID + " not found")
+	}
+	current := consent.EffectiveState(time.Now())
+	if !CanTransition(current, state) {
+		return "", common.NewConflictError("consent " + consentID + " cannot move from " + current + " to " + state)
+	}
+	tr_uuid, err := m.record("SetConsentState", []string{appID, consentID, state})
+	if err != nil {
+		return "", err
+	}
+	consent.addTransition(state, time.Now())
+	m.consents[consentID] = consent
+	return tr_uuid, nil
+}
+
+func (m *Memory_Ledger) RemoveConsents() (bool, error) {
+	log.Trace(log.Here(), "RemoveConsents() : calling method -")
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	_, err := m.record("Reset", []string{})
+	if err != nil {
+		return false, err
+	}
+	m.consents = make(map[string]Consent)
+	return true, nil
+}
+
+func (m *Memory_Ledger) GetTransaction(transaction_uuid string) (Transaction, error) {
+	log.Trace(log.Here(), "GetTransaction(", transaction_uuid, ") : calling method -")
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	transaction, ok := m.transactions[transaction_uuid]
+	if !ok {
+		return Transaction{Error: "Error retrieving transaction " + transaction_uuid + ": Transaction not found"}, nil
+	}
+	return transaction, nil
+}
+
+func (m *Memory_Ledger) create(function, state string, args []string) (string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	tr_uuid, err := m.record(function, args)
+	if err != nil {
+		return "", err
+	}
+	consent := Consent{AppID: args[0], ConsentID: tr_uuid, OwnerID: args[1], ConsumerID: args[2], Datatype: args[3], Dataaccess: args[4], Dt_begin: args[5], Dt_end: args[6]}
+	consent.addTransition(state, time.Now())
+	m.consents[tr_uuid] = consent
+	return tr_uuid, nil
+}
+
+// Must be called with the write lock held
+func (m *Memory_Ledger) record(function string, args []string) (string, error) {
+	tr_uuid := common.Generate_uuid()
+	now := time.Now()
+	nonce := make([]byte, 24)
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+	m.transactions[tr_uuid] = Transaction{
+		Type:        CHAINCODE_INVOKE,
+		ChaincodeID: b64.StdEncoding.EncodeToString(Build_chaincodeID("", m.ChainCodeName)),
+		Payload:     b64.StdEncoding.EncodeToString(Build_invocation_payload(m.ChainCodeName, function, args)),
+		Txid:        tr_uuid,
+		Timestamp:   Timestamp{Seconds: int(now.Unix()), Nanos: now.Nanosecond()},
+		Nonce:       b64.StdEncoding.EncodeToString(nonce),
+	}
+	return tr_uuid, nil
+}
+
+func (m *Memory_Ledger) filter(match func(Consent) bool) []Consent {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	consents := make([]Consent, 0)
+	for _, consent := range m.consents {
+		if match(consent) {
+			consents = append(consents, consent)
+		}
+	}
+	sort.Slice(consents, func(i, j int) bool { return consents[i].ConsentID < consents[j].ConsentID })
+	return consents
+}
@@ -13,9 +13,16 @@ limitations under the License.
 
 package hyperledger
 
+import (
+	"crypto/x509"
+	b64 "encoding/base64"
+	"time"
+)
+
 type Error struct {
 	Code    int
 	Message string
+	Data    string
 }
 
 type Result struct {
@@ -66,17 +73,28 @@ type Timestamp struct {
 	Nanos   int
 }
 
+func (t Timestamp) Time() time.Time {
+	return time.Unix(int64(t.Seconds), int64(t.Nanos))
+}
+
 /***********************************************************/
+// States written by the first chaincode version
+const (
+	CONSENT_ACTIVE   = "True"
+	CONSENT_INACTIVE = "False"
+)
+
 type Consent struct {
-	AppID      string
-	State      string
-	ConsentID  string
-	OwnerID    string
-	ConsumerID string
-	Datatype   string
-	Dataaccess string
-	Dt_begin   string
-	Dt_end     string
+	AppID       string
+	State       string
+	ConsentID   string
+	OwnerID     string
+	ConsumerID  string
+	Datatype    string
+	Dataaccess  string
+	Dt_begin    string
+	Dt_end      string
+	Transitions []Transition
 }
 
 func (c *Consent) ToString() string {
@@ -120,6 +138,9 @@ func (r *Response) IsOK() bool {
 }
 
 func (r *Response) GetError() string {
+	if r.Error.Data != "" {
+		return r.Error.Message + ": " + r.Error.Data
+	}
 	return r.Error.Message
 }
 
@@ -144,6 +165,22 @@ func (t *Transaction) GetError() string {
 	return t.Error
 }
 
+// Subject of the certificate which signed the transaction, empty for unsigned transactions
+func (t *Transaction) GetSigner() (string, error) {
+	if t.Cert == "" {
+		return "", nil
+	}
+	der, err1 := b64.StdEncoding.DecodeString(t.Cert)
+	if err1 != nil {
+		return "", err1
+	}
+	cert, err2 := x509.ParseCertificate(der)
+	if err2 != nil {
+		return "", err2
+	}
+	return cert.Subject.String(), nil
+}
+
 func (t *Transaction) GetPayload() string {
 	return t.Payload
 }
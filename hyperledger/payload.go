@@ -0,0 +1,256 @@
+/*
+Copyright Pascal Limeux. 2017 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+       http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hyperledger
+
+import (
+	b64 "encoding/base64"
+	"fmt"
+	"github.com/pascallimeux/ocms2/modules/common"
+	"github.com/pascallimeux/ocms2/modules/log"
+)
+
+// Protobuf wire types and ChaincodeSpec constants used by Hyperledger 0.6
+const (
+	WIRE_VARINT  = 0
+	WIRE_FIXED64 = 1
+	WIRE_BYTES   = 2
+	WIRE_FIXED32 = 5
+	GOLANG       = 1
+)
+
+// Chaincode functions whose arguments describe a consent
+var consentCreations = []string{"PostConsent", "PostConsentRequest"}
+
+// Decoded content of a ChaincodeInvocationSpec
+type Invocation struct {
+	ChaincodeName string
+	Function      string
+	Args          []string
+}
+
+// One field of a protobuf message, Value holds the raw bytes of length delimited fields
+type protoField struct {
+	Number int
+	Wire   int
+	Varint uint64
+	Value  []byte
+}
+
+// Build the ChaincodeID protobuf message (path = 1, name = 2)
+func Build_chaincodeID(chaincode_path, chaincode_name string) []byte {
+	var buf []byte
+	if chaincode_path != "" {
+		buf = appendBytesField(buf, 1, []byte(chaincode_path))
+	}
+	if chaincode_name != "" {
+		buf = appendBytesField(buf, 2, []byte(chaincode_name))
+	}
+	return buf
+}
+
+// Build the ChaincodeInvocationSpec protobuf message carried by an invoke transaction
+func Build_invocation_payload(chaincode_name, function string, args []string) []byte {
+	var input []byte
+	input = appendBytesField(input, 1, []byte(function))
+	for _, arg := range args {
+		input = appendBytesField(input, 1, []byte(arg))
+	}
+	var spec []byte
+	spec = appendVarintField(spec, 1, GOLANG)
+	spec = appendBytesField(spec, 2, Build_chaincodeID("", chaincode_name))
+	spec = appendBytesField(spec, 3, input)
+	return appendBytesField(nil, 1, spec)
+}
+
+func appendVarint(buf []byte, value uint64) []byte {
+	for value >= 0x80 {
+		buf = append(buf, byte(value)|0x80)
+		value >>= 7
+	}
+	return append(buf, byte(value))
+}
+
+func appendVarintField(buf []byte, field int, value uint64) []byte {
+	buf = appendVarint(buf, uint64(field<<3|WIRE_VARINT))
+	return appendVarint(buf, value)
+}
+
+func appendBytesField(buf []byte, field int, value []byte) []byte {
+	buf = appendVarint(buf, uint64(field<<3|WIRE_BYTES))
+	buf = appendVarint(buf, uint64(len(value)))
+	return append(buf, value...)
+}
+
+// Decode the ChaincodeInvocationSpec protobuf message carried by an invoke transaction
+func Decode_invocation_payload(payload []byte) (Invocation, error) {
+	log.Trace(log.Here(), "Decode_invocation_payload() : calling method -")
+	invocation := Invocation{}
+	spec, err1 := findBytesField(payload, 1)
+	if err1 != nil {
+		return invocation, fmt.Errorf("ChaincodeInvocationSpec: %s", err1.Error())
+	}
+	if spec == nil {
+		return invocation, fmt.Errorf("ChaincodeInvocationSpec: no chaincodeSpec")
+	}
+	fields, err2 := readFields(spec)
+	if err2 != nil {
+		return invocation, fmt.Errorf("ChaincodeSpec: %s", err2.Error())
+	}
+	var input []byte
+	for _, field := range fields {
+		switch {
+		case field.Number == 2 && field.Wire == WIRE_BYTES:
+			name, err3 := findBytesField(field.Value, 2)
+			if err3 != nil {
+				return invocation, fmt.Errorf("ChaincodeID: %s", err3.Error())
+			}
+			invocation.ChaincodeName = string(name)
+		case field.Number == 3 && field.Wire == WIRE_BYTES:
+			input = field.Value
+		}
+	}
+	if input == nil {
+		return invocation, fmt.Errorf("ChaincodeSpec: no ctorMsg")
+	}
+	inputs, err4 := readFields(input)
+	if err4 != nil {
+		return invocation, fmt.Errorf("ChaincodeInput: %s", err4.Error())
+	}
+	// 0.6 peers send the function as the first of the repeated args (field 1),
+	// 0.5 peers send it alone in field 1 followed by repeated args in field 2
+	var args []string
+	for _, field := range inputs {
+		if field.Wire == WIRE_BYTES && (field.Number == 1 || field.Number == 2) {
+			args = append(args, string(field.Value))
+		}
+	}
+	if len(args) == 0 {
+		return invocation, fmt.Errorf("ChaincodeInput: no function")
+	}
+	invocation.Function = args[0]
+	invocation.Args = args[1:]
+	return invocation, nil
+}
+
+// Decode the payload of an invoke transaction
+func (t *Transaction) GetInvocation() (Invocation, error) {
+	log.Trace(log.Here(), "GetInvocation(", t.Txid, ") : calling method -")
+	payload, err1 := b64.StdEncoding.DecodeString(t.Payload)
+	if err1 != nil {
+		return Invocation{}, common.NewLedgerError("transaction " + t.Txid + " has an unreadable payload: " + err1.Error())
+	}
+	invocation, err2 := Decode_invocation_payload(payload)
+	if err2 != nil {
+		return invocation, common.NewLedgerError("transaction " + t.Txid + " has an unreadable payload: " + err2.Error())
+	}
+	return invocation, nil
+}
+
+// Decode the payload of a transaction which created a consent
+func (t *Transaction) GetConsentInvocation() (Invocation, error) {
+	log.Trace(log.Here(), "GetConsentInvocation(", t.Txid, ") : calling method -")
+	invocation, err := t.GetInvocation()
+	if err != nil {
+		return invocation, err
+	}
+	isCreation := false
+	for _, function := range consentCreations {
+		if invocation.Function == function {
+			isCreation = true
+		}
+	}
+	if !isCreation {
+		return invocation, common.NewValidationError(fmt.Sprintf("transaction %s is a %s invocation, not a consent creation", t.Txid, invocation.Function))
+	}
+	if len(invocation.Args) != 7 {
+		return invocation, common.NewLedgerError(fmt.Sprintf("transaction %s has %d arguments for %s, 7 expected", t.Txid, len(invocation.Args), invocation.Function))
+	}
+	return invocation, nil
+}
+
+// Return the value of the first length delimited field with this number, nil if absent
+func findBytesField(buf []byte, number int) ([]byte, error) {
+	fields, err := readFields(buf)
+	if err != nil {
+		return nil, err
+	}
+	for _, field := range fields {
+		if field.Number == number && field.Wire == WIRE_BYTES {
+			return field.Value, nil
+		}
+	}
+	return nil, nil
+}
+
+// Split a protobuf message in its fields, unknown fields are kept so callers may skip them
+func readFields(buf []byte) ([]protoField, error) {
+	var fields []protoField
+	for i := 0; i < len(buf); {
+		key, n := readVarint(buf[i:])
+		if n == 0 {
+			return nil, fmt.Errorf("truncated field key at offset %d", i)
+		}
+		i += n
+		field := protoField{Number: int(key >> 3), Wire: int(key & 7)}
+		if field.Number == 0 {
+			return nil, fmt.Errorf("invalid field number 0 at offset %d", i-n)
+		}
+		switch field.Wire {
+		case WIRE_VARINT:
+			value, m := readVarint(buf[i:])
+			if m == 0 {
+				return nil, fmt.Errorf("truncated varint for field %d", field.Number)
+			}
+			field.Varint = value
+			i += m
+		case WIRE_FIXED64, WIRE_FIXED32:
+			size := 8
+			if field.Wire == WIRE_FIXED32 {
+				size = 4
+			}
+			if len(buf)-i < size {
+				return nil, fmt.Errorf("truncated fixed value for field %d", field.Number)
+			}
+			field.Value = buf[i : i+size]
+			i += size
+		case WIRE_BYTES:
+			size, m := readVarint(buf[i:])
+			if m == 0 {
+				return nil, fmt.Errorf("truncated length for field %d", field.Number)
+			}
+			i += m
+			if uint64(len(buf)-i) < size {
+				return nil, fmt.Errorf("field %d needs %d bytes, %d left", field.Number, size, len(buf)-i)
+			}
+			field.Value = buf[i : i+int(size)]
+			i += int(size)
+		default:
+			return nil, fmt.Errorf("unsupported wire type %d for field %d", field.Wire, field.Number)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// Read a base 128 varint, returns the number of bytes read or 0 if buf is truncated
+func readVarint(buf []byte) (uint64, int) {
+	var value uint64
+	for i := 0; i < len(buf) && i < 10; i++ {
+		value |= uint64(buf[i]&0x7F) << uint(7*i)
+		if buf[i] < 0x80 {
+			return value, i + 1
+		}
+	}
+	return 0, 0
+}
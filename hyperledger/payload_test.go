@@ -0,0 +1,167 @@
+/*
+Copyright Pascal Limeux. 2017 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hyperledger_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	b64 "encoding/base64"
+	"github.com/pascallimeux/ocms2/hyperledger"
+	"github.com/pascallimeux/ocms2/modules/common"
+	"math/big"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Payloads built by hand in the ChaincodeInvocationSpec layout of PostConsent, PostConsentRequest and RemoveConsent transactions
+const (
+	POSTCONSENT_PAYLOAD        = "CtQBCAESgwESgAE0MTE0OWY1MDg5ZTc2YjNiOTVmY2YyMGUyNWE2NGZkZTdiZTA3NDUyYjYwMmRhZmU3OGYxOTJiZjgyNmMxNGRhMjVhMzc2N2MzYTI4MTI1NWY0ZjM1NTg0MmE1YTg3YTM2NmFjYTY1ZjQ0ZjVhMDJhZmMxNzg0NDE3MDc5Yjc2ZRpKCgtQb3N0Q29uc2VudAoQMjgwMzk5QTIwMTYyOTA4WgoEMTExMQoEMjIyMgoCQlAKAVIKCjIwMTctMDItMDEKCjIwMTctMTItMzE="
+	POSTCONSENTREQUEST_PAYLOAD = "CrEDCAESgwESgAE0MTE0OWY1MDg5ZTc2YjNiOTVmY2YyMGUyNWE2NGZkZTdiZTA3NDUyYjYwMmRhZmU3OGYxOTJiZjgyNmMxNGRhMjVhMzc2N2MzYTI4MTI1NWY0ZjM1NTg0MmE1YTg3YTM2NmFjYTY1ZjQ0ZjVhMDJhZmMxNzg0NDE3MDc5Yjc2ZRqTAgoSUG9zdENvbnNlbnRSZXF1ZXN0ChAyODAzOTlBMjAxNjI5MDhaCpYBb3duZXItb3duZXItb3duZXItb3duZXItb3duZXItb3duZXItb3duZXItb3duZXItb3duZXItb3duZXItb3duZXItb3duZXItb3duZXItb3duZXItb3duZXItb3duZXItb3duZXItb3duZXItb3duZXItb3duZXItb3duZXItb3duZXItb3duZXItb3duZXItb3duZXItCgQyMjIyChBoZWFsdGgvaGVhcnRyYXRlCgRyZWFkChkyMDE3LTAyLTAxVDA4OjAwOjAwKzAxOjAwChkyMDE3LTEyLTMxVDE4OjAwOjAwKzAxOjAwILDqASoFYWRtaW4wAEIEcm9sZRIMc2hhMjU2YmFzZTY0"
+	REMOVECONSENT_PAYLOAD      = "CrUBCAESgwESgAE0MTE0OWY1MDg5ZTc2YjNiOTVmY2YyMGUyNWE2NGZkZTdiZTA3NDUyYjYwMmRhZmU3OGYxOTJiZjgyNmMxNGRhMjVhMzc2N2MzYTI4MTI1NWY0ZjM1NTg0MmE1YTg3YTM2NmFjYTY1ZjQ0ZjVhMDJhZmMxNzg0NDE3MDc5Yjc2ZRorCg1SZW1vdmVDb25zZW50ChAyODAzOTlBMjAxNjI5MDhaCgg0ZjZlM2ExYw=="
+)
+
+func decodePayload(t *testing.T, payload string) []byte {
+	bytes, err := b64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes
+}
+
+func checkInvocation(t *testing.T, invocation hyperledger.Invocation, function string, args []string) {
+	if invocation.ChaincodeName != config.ChainCodeName {
+		t.Error("bad chaincode name: ", invocation.ChaincodeName)
+	}
+	if invocation.Function != function {
+		t.Error("bad function: ", invocation.Function)
+	}
+	if len(invocation.Args) != len(args) {
+		t.Fatalf("%d args decoded, %d expected", len(invocation.Args), len(args))
+	}
+	for i, arg := range args {
+		if invocation.Args[i] != arg {
+			t.Errorf("arg %d: %s, expected %s", i, invocation.Args[i], arg)
+		}
+	}
+}
+
+func TestDecodePostConsentPayloadNominal(t *testing.T) {
+	invocation, err := hyperledger.Decode_invocation_payload(decodePayload(t, POSTCONSENT_PAYLOAD))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkInvocation(t, invocation, "PostConsent", []string{config.ApplicationID, "1111", "2222", "BP", "R", "2017-02-01", "2017-12-31"})
+}
+
+func TestDecodeLongArgPayloadNominal(t *testing.T) {
+	// ownerID is 150 bytes long and the spec carries timeout, secureContext and attributes fields
+	invocation, err := hyperledger.Decode_invocation_payload(decodePayload(t, POSTCONSENTREQUEST_PAYLOAD))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkInvocation(t, invocation, "PostConsentRequest", []string{config.ApplicationID, strings.Repeat("owner-", 25), "2222", "health/heartrate", "read", "2017-02-01T08:00:00+01:00", "2017-12-31T18:00:00+01:00"})
+}
+
+func TestDecodeBuiltPayloadNominal(t *testing.T) {
+	args := []string{config.ApplicationID, strings.Repeat("o", 300), "2222", "BP", "R", "2017-02-01", "2017-12-31"}
+	payload := hyperledger.Build_invocation_payload("shortname", "PostConsent", args)
+	invocation, err := hyperledger.Decode_invocation_payload(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if invocation.ChaincodeName != "shortname" {
+		t.Error("bad chaincode name: ", invocation.ChaincodeName)
+	}
+	if invocation.Function != "PostConsent" || len(invocation.Args) != 7 || invocation.Args[1] != args[1] {
+		t.Errorf("bad invocation: %#v", invocation)
+	}
+}
+
+func TestDecodeTruncatedPayload(t *testing.T) {
+	payload := decodePayload(t, POSTCONSENT_PAYLOAD)
+	for _, size := range []int{0, 1, 2, 10, 139, len(payload) - 1} {
+		_, err := hyperledger.Decode_invocation_payload(payload[:size])
+		if err == nil {
+			t.Error("no error for a payload truncated to ", size, " bytes")
+		}
+	}
+}
+
+func TestGetConsentInvocationNominal(t *testing.T) {
+	transaction := hyperledger.Transaction{Txid: "tx1", Payload: POSTCONSENT_PAYLOAD}
+	invocation, err := transaction.GetConsentInvocation()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if invocation.Args[1] != "1111" {
+		t.Error("bad ownerID: ", invocation.Args[1])
+	}
+}
+
+func TestGetConsentInvocationNotAConsent(t *testing.T) {
+	transaction := hyperledger.Transaction{Txid: "tx2", Payload: REMOVECONSENT_PAYLOAD}
+	_, err := transaction.GetConsentInvocation()
+	if err == nil {
+		t.Fatal("a RemoveConsent transaction is decoded as a consent")
+	}
+	if common.ToHttpError(err).Status != http.StatusBadRequest || !strings.Contains(err.Error(), "RemoveConsent") {
+		t.Error("bad error: ", err.Error())
+	}
+}
+
+func TestGetConsentInvocationFromLedgerNominal(t *testing.T) {
+	tr_uuid, err := consent_helper.CreateConsent(config.ApplicationID, strings.Repeat("9", 200), "1010", "BP", "R", "2016-09-04", "2016-12-24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(TransactionTimeout)
+	transaction, err2 := consent_helper.GetTransaction(tr_uuid)
+	if err2 != nil {
+		t.Fatal(err2)
+	}
+	invocation, err3 := transaction.GetConsentInvocation()
+	if err3 != nil {
+		t.Fatal(err3)
+	}
+	if invocation.Args[1] != strings.Repeat("9", 200) || invocation.Args[6] != "2016-12-24" {
+		t.Errorf("bad invocation: %#v", invocation)
+	}
+}
+
+func TestGetSignerNominal(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: config.EnrollID, Organization: []string{"Orange"}}, NotBefore: time.Now(), NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transaction := hyperledger.Transaction{Txid: "tx3", Cert: b64.StdEncoding.EncodeToString(der)}
+	signer, err2 := transaction.GetSigner()
+	if err2 != nil {
+		t.Fatal(err2)
+	}
+	if signer != "CN="+config.EnrollID+",O=Orange" {
+		t.Error("bad signer: ", signer)
+	}
+	transaction.Cert = ""
+	signer, _ = transaction.GetSigner()
+	if signer != "" {
+		t.Error("signer for an unsigned transaction: ", signer)
+	}
+}
@@ -0,0 +1,316 @@
+/*
+Copyright Pascal Limeux. 2017 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+       http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package peertest provides a fake Hyperledger 0.6 REST peer for integration tests.
+package peertest
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/pascallimeux/ocms2/hyperledger"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+)
+
+// JSON-RPC error codes returned by a Hyperledger 0.6 peer
+const (
+	PARSE_ERROR     = -32700
+	INVALID_REQUEST = -32600
+	METHOD_NOTFOUND = -32601
+	INVALID_PARAMS  = -32602
+	CHAINCODE_ERROR = -32003
+	VERSION         = "1.0"
+)
+
+// Fake peer serving /chaincode, /registrar and /transactions
+type Peer struct {
+	*httptest.Server
+	ChainCodePath string
+	ChainCodeName string
+	mutex         sync.Mutex
+	users         map[string]string
+	logged        map[string]bool
+	ledger        *hyperledger.Memory_Ledger
+	failures      map[string][]hyperledger.Error
+}
+
+// Start a fake peer knowing one chaincode and one enrolled user
+func NewPeer(chainCodePath, chainCodeName, enrollID, enrollSecret string) *Peer {
+	p := &Peer{ChainCodePath: chainCodePath, ChainCodeName: chainCodeName}
+	p.users = map[string]string{enrollID: enrollSecret}
+	p.logged = map[string]bool{enrollID: true}
+	p.ledger = hyperledger.NewMemoryLedger(chainCodeName)
+	p.failures = make(map[string][]hyperledger.Error)
+	mux := http.NewServeMux()
+	mux.HandleFunc(hyperledger.CHAINCODE, p.chaincode)
+	mux.HandleFunc(hyperledger.REGISTAR, p.registrar)
+	mux.HandleFunc(hyperledger.REGISTAR+"/", p.registrar)
+	mux.HandleFunc(hyperledger.TRANSACTION+"/", p.transaction)
+	p.Server = httptest.NewServer(mux)
+	return p
+}
+
+// Make the next call of a chaincode function (or "deploy") answer with a JSON-RPC error
+func (p *Peer) FailNext(function string, code int, message string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.failures[function] = append(p.failures[function], hyperledger.Error{Code: code, Message: message})
+}
+
+func (p *Peer) nextFailure(function string) (hyperledger.Error, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	failures := p.failures[function]
+	if len(failures) == 0 {
+		return hyperledger.Error{}, false
+	}
+	p.failures[function] = failures[1:]
+	return failures[0], true
+}
+
+//HTTP Post - /chaincode
+func (p *Peer) chaincode(w http.ResponseWriter, r *http.Request) {
+	var request hyperledger.Invoke
+	if r.Method != "POST" {
+		sendRPCError(w, 0, INVALID_REQUEST, "Invalid request", "only POST is allowed")
+		return
+	}
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		sendRPCError(w, 0, PARSE_ERROR, "Parse error", err.Error())
+		return
+	}
+	if request.Jsonrpc != hyperledger.JSONRPC {
+		sendRPCError(w, request.Id, INVALID_REQUEST, "Invalid request", "JSON RPC version must be "+hyperledger.JSONRPC)
+		return
+	}
+	params := request.Params
+	if params.SecureContext != "" && !p.isLogged(params.SecureContext) {
+		sendRPCError(w, request.Id, INVALID_REQUEST, "Invalid request", "User not logged in. Use the '/registrar' endpoint to obtain a security token.")
+		return
+	}
+	function := params.CtorMsg.Function
+	if request.Method == "deploy" {
+		function = "deploy"
+	}
+	if failure, ok := p.nextFailure(function); ok {
+		sendRPCError(w, request.Id, failure.Code, failure.Message, "")
+		return
+	}
+
+	switch request.Method {
+	case "deploy":
+		if params.ChaincodeID.Path != p.ChainCodePath {
+			sendRPCError(w, request.Id, CHAINCODE_ERROR, "Deployment failure", "Error when deploying chaincode: path "+params.ChaincodeID.Path+" not found")
+			return
+		}
+		sendRPCResult(w, request.Id, p.ChainCodeName)
+	case "invoke":
+		if params.ChaincodeID.Name != p.ChainCodeName {
+			sendRPCError(w, request.Id, CHAINCODE_ERROR, "Invocation failure", "chaincode "+params.ChaincodeID.Name+" not deployed")
+			return
+		}
+		message, err := p.invoke(function, params.CtorMsg.Args)
+		if err != nil {
+			sendRPCError(w, request.Id, CHAINCODE_ERROR, "Invocation failure", "Error when invoking chaincode: "+err.Error())
+			return
+		}
+		sendRPCResult(w, request.Id, message)
+	case "query":
+		if params.ChaincodeID.Name != p.ChainCodeName {
+			sendRPCError(w, request.Id, CHAINCODE_ERROR, "Query failure", "chaincode "+params.ChaincodeID.Name+" not deployed")
+			return
+		}
+		message, err := p.query(function, params.CtorMsg.Args)
+		if err != nil {
+			sendRPCError(w, request.Id, CHAINCODE_ERROR, "Query failure", "Error when querying chaincode: "+err.Error())
+			return
+		}
+		sendRPCResult(w, request.Id, message)
+	default:
+		sendRPCError(w, request.Id, METHOD_NOTFOUND, "Method not found", "The requested method "+request.Method+" does not exist")
+	}
+}
+
+//HTTP Post - /registrar, HTTP Get - /registrar/{enrollmentID}
+func (p *Peer) registrar(w http.ResponseWriter, r *http.Request) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if r.Method == "GET" {
+		enrollID := strings.TrimPrefix(r.URL.Path, hyperledger.REGISTAR+"/")
+		if p.logged[enrollID] {
+			sendJSON(w, http.StatusOK, map[string]string{"OK": "User " + enrollID + " is already logged in."})
+		} else {
+			sendJSON(w, http.StatusUnauthorized, map[string]string{"Error": "User " + enrollID + " must log in."})
+		}
+		return
+	}
+	var login struct {
+		EnrollID     string `json:"enrollId"`
+		EnrollSecret string `json:"enrollSecret"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&login)
+	if err != nil {
+		sendJSON(w, http.StatusBadRequest, map[string]string{"Error": "Error unmarshalling login request payload: " + err.Error()})
+		return
+	}
+	secret, ok := p.users[login.EnrollID]
+	if !ok || secret != login.EnrollSecret {
+		sendJSON(w, http.StatusUnauthorized, map[string]string{"Error": "rpc error: code = 2 desc = Identity or token does not match."})
+		return
+	}
+	p.logged[login.EnrollID] = true
+	sendJSON(w, http.StatusOK, map[string]string{"OK": "Login successful for user '" + login.EnrollID + "'."})
+}
+
+//HTTP Get - /transactions/{UUID}
+func (p *Peer) transaction(w http.ResponseWriter, r *http.Request) {
+	tr_uuid := strings.TrimPrefix(r.URL.Path, hyperledger.TRANSACTION+"/")
+	transaction, _ := p.ledger.GetTransaction(tr_uuid)
+	if !transaction.IsOK() {
+		sendJSON(w, http.StatusNotFound, map[string]string{"Error": transaction.Error})
+		return
+	}
+	type timestamp struct {
+		Seconds int `json:"seconds"`
+		Nanos   int `json:"nanos"`
+	}
+	type restTransaction struct {
+		Type        int       `json:"type"`
+		ChaincodeID string    `json:"chaincodeID"`
+		Payload     string    `json:"payload"`
+		Txid        string    `json:"txid"`
+		Timestamp   timestamp `json:"timestamp"`
+		Nonce       string    `json:"nonce"`
+		Cert        string    `json:"cert,omitempty"`
+		Signature   string    `json:"signature,omitempty"`
+	}
+	sendJSON(w, http.StatusOK, restTransaction{Type: transaction.Type, ChaincodeID: transaction.ChaincodeID, Payload: transaction.Payload, Txid: transaction.Txid,
+		Timestamp: timestamp{Seconds: transaction.Timestamp.Seconds, Nanos: transaction.Timestamp.Nanos}, Nonce: transaction.Nonce, Cert: transaction.Cert, Signature: transaction.Signature})
+}
+
+func (p *Peer) isLogged(enrollID string) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.logged[enrollID]
+}
+
+// Consent chaincode invoke functions
+func (p *Peer) invoke(function string, args []string) (string, error) {
+	switch function {
+	case "PostConsent":
+		if len(args) != 7 {
+			return "", errors.New("Incorrect number of arguments. Expecting 7")
+		}
+		return p.ledger.CreateConsent(args[0], args[1], args[2], args[3], args[4], args[5], args[6])
+	case "RemoveConsent":
+		if len(args) != 2 {
+			return "", errors.New("Incorrect number of arguments. Expecting 2")
+		}
+		response, err := p.ledger.UnactivateConsent(args[0], args[1])
+		if err != nil {
+			return "", err
+		}
+		if !response.IsOK() {
+			return "", errors.New(response.GetError())
+		}
+		return response.GetMessage(), nil
+	case "Reset":
+		_, err := p.ledger.RemoveConsents()
+		return "", err
+	}
+	return "", errors.New("Received unknown function invocation: " + function)
+}
+
+// Consent chaincode query functions
+func (p *Peer) query(function string, args []string) (string, error) {
+	var result interface{}
+	var err error
+	switch function {
+	case "GetVersion":
+		return VERSION, nil
+	case "GetConsent":
+		if len(args) != 2 {
+			return "", errors.New("Incorrect number of arguments. Expecting 2")
+		}
+		result, err = p.ledger.GetConsent(args[0], args[1])
+	case "GetConsents":
+		if len(args) == 2 && args[1] == "ALLM" {
+			result, err = p.ledger.GetAllConsents(args[0])
+		} else if len(args) == 1 {
+			result, err = p.ledger.GetActivesConsents(args[0])
+		} else {
+			return "", errors.New("Incorrect number of arguments. Expecting 1 or 2")
+		}
+	case "GetOwnerConsents":
+		if len(args) != 2 {
+			return "", errors.New("Incorrect number of arguments. Expecting 2")
+		}
+		result, err = p.ledger.GetConsents4Owner(args[0], args[1])
+	case "GetConsumerConsents":
+		if len(args) != 2 {
+			return "", errors.New("Incorrect number of arguments. Expecting 2")
+		}
+		result, err = p.ledger.GetConsents4Consumer(args[0], args[1])
+	case "IsConsent":
+		if len(args) != 5 {
+			return "", errors.New("Incorrect number of arguments. Expecting 5")
+		}
+		isconsent, err := p.ledger.IsConsent(args[0], args[1], args[2], args[3], args[4])
+		if err != nil || !isconsent {
+			return "False", err
+		}
+		return "True", nil
+	default:
+		return "", errors.New("Received unknown function query: " + function)
+	}
+	if err != nil {
+		return "", err
+	}
+	bytes, err := json.Marshal(result)
+	return string(bytes), err
+}
+
+type rpcResult struct {
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    string `json:"data,omitempty"`
+}
+
+type rpcResponse struct {
+	Jsonrpc string     `json:"jsonrpc"`
+	Result  *rpcResult `json:"result,omitempty"`
+	Error   *rpcError  `json:"error,omitempty"`
+	Id      int        `json:"id"`
+}
+
+func sendRPCResult(w http.ResponseWriter, id int, message string) {
+	sendJSON(w, http.StatusOK, rpcResponse{Jsonrpc: hyperledger.JSONRPC, Result: &rpcResult{Status: "OK", Message: message}, Id: id})
+}
+
+func sendRPCError(w http.ResponseWriter, id, code int, message, data string) {
+	sendJSON(w, http.StatusOK, rpcResponse{Jsonrpc: hyperledger.JSONRPC, Error: &rpcError{Code: code, Message: message, Data: data}, Id: id})
+}
+
+func sendJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
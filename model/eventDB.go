@@ -0,0 +1,99 @@
+/*
+Copyright Pascal Limeux. 2017 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package model
+
+import (
+	"encoding/json"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/pascallimeux/ocms2/modules/log"
+	"time"
+)
+
+// Append an event to the event log, returns its rank
+func (s *SqlContext) AddConsentEvent(event WebhookEvent) (int64, error) {
+	log.Trace(log.Here(), "AddConsentEvent(", event.Type, ", ", event.Consentid, ") : calling method -")
+	sql := "insert into consent_events (Id, Type, Application_id, Consent_id, Owner_id, Consumer_id, Payload, OccurredAt) values (?, ?, ?, ?, ?, ?, ?, ?)"
+	event.Seq = 0
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return 0, err
+	}
+	stmt, err1 := s.Db.Prepare(sql)
+	if err1 != nil {
+		return 0, err1
+	}
+	defer stmt.Close()
+	result, err2 := stmt.Exec(event.Id, event.Type, event.Appid, event.Consentid, event.Consent.Ownerid, event.Consent.Consumerid, string(payload), time.Now())
+	if err2 != nil {
+		return 0, err2
+	}
+	return result.LastInsertId()
+}
+
+// Events of an application logged after the given rank, oldest first, an empty owner or consumer matches every event
+func (s *SqlContext) GetConsentEvents(applicationID, ownerID, consumerID string, after int64, limit int) ([]WebhookEvent, error) {
+	log.Trace(log.Here(), "GetConsentEvents(", applicationID, ") : calling method -")
+	sql := "select Seq, Payload from consent_events where Application_id = ? and Seq > ? and (? = '' or Owner_id = ?) and (? = '' or Consumer_id = ?) order by Seq limit ?"
+	var result = make([]WebhookEvent, 0)
+	rows, err1 := s.Db.Query(sql, applicationID, after, ownerID, ownerID, consumerID, consumerID, limit)
+	if err1 != nil {
+		return result, err1
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var seq int64
+		var payload string
+		err2 := rows.Scan(&seq, &payload)
+		if err2 != nil {
+			return result, err2
+		}
+		event := WebhookEvent{}
+		err3 := json.Unmarshal([]byte(payload), &event)
+		if err3 != nil {
+			return result, err3
+		}
+		event.Seq = seq
+		result = append(result, event)
+	}
+	return result, nil
+}
+
+// Rank of the last logged event, 0 when the log is empty
+func (s *SqlContext) GetLastConsentEventSeq() (int64, error) {
+	log.Trace(log.Here(), "GetLastConsentEventSeq() : calling method -")
+	var seq int64
+	err := s.Db.QueryRow("select coalesce(max(Seq), 0) from consent_events").Scan(&seq)
+	return seq, err
+}
+
+// Drop the events logged before the given date, returns the number of dropped events
+func (s *SqlContext) PurgeConsentEvents(before time.Time) (int64, error) {
+	log.Trace(log.Here(), "PurgeConsentEvents() : calling method -")
+	result, err1 := s.Db.Exec("delete from consent_events where OccurredAt < ?", before)
+	if err1 != nil {
+		return 0, err1
+	}
+	return result.RowsAffected()
+}
+
+// Remember an expired consent was notified, false when it already was
+func (s *SqlContext) AddConsentExpiration(applicationID, consentID string) (bool, error) {
+	log.Trace(log.Here(), "AddConsentExpiration(", consentID, ") : calling method -")
+	result, err1 := s.Db.Exec("insert or ignore into consent_expirations (Consent_id, Application_id, NotifiedAt) values (?, ?, ?)", consentID, applicationID, time.Now())
+	if err1 != nil {
+		return false, err1
+	}
+	rowAffected, err2 := result.RowsAffected()
+	return rowAffected == 1, err2
+}
@@ -0,0 +1,88 @@
+/*
+Copyright Pascal Limeux. 2017 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+       http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package model
+
+import (
+	"errors"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/pascallimeux/ocms2/modules/log"
+)
+
+func (s *SqlContext) AddConsentTransaction(consentTransaction ConsentTransaction) error {
+	log.Trace(log.Here(), "AddConsentTransaction() : calling method -")
+	sql := "insert or replace into consent_transactions (Consent_id, Transaction_id, Application_id, Function, CreatedAt) values (?, ?, ?, ?, ?)"
+
+	stmt, err1 := s.Db.Prepare(sql)
+	if err1 != nil {
+		return err1
+	}
+	defer stmt.Close()
+
+	result, err2 := stmt.Exec(consentTransaction.Consentid, consentTransaction.Txuuid, consentTransaction.Appid, consentTransaction.Function, consentTransaction.CreatedAt)
+	if err2 != nil {
+		return err2
+	}
+	rowAffected, err3 := result.RowsAffected()
+	if err3 != nil {
+		return err3
+	}
+	if rowAffected != 1 {
+		return errors.New("row not created")
+	}
+	return nil
+}
+
+// Transactions recorded for a consent, oldest first
+func (s *SqlContext) GetConsentTransactions(consentID string) ([]ConsentTransaction, error) {
+	log.Trace(log.Here(), "GetConsentTransactions(", consentID, ") : calling method -")
+	sql := "select Consent_id, Transaction_id, Application_id, Function, CreatedAt from consent_transactions where Consent_id = ? order by CreatedAt"
+	var result = make([]ConsentTransaction, 0)
+
+	stmt, err := s.Db.Prepare(sql)
+	if err != nil {
+		return result, err
+	}
+	defer stmt.Close()
+
+	rows, err1 := stmt.Query(consentID)
+	if err1 != nil {
+		return result, err1
+	}
+	defer rows.Close()
+	for rows.Next() {
+		consentTransaction := ConsentTransaction{}
+		err2 := rows.Scan(&consentTransaction.Consentid, &consentTransaction.Txuuid, &consentTransaction.Appid, &consentTransaction.Function, &consentTransaction.CreatedAt)
+		if err2 != nil {
+			return result, err2
+		}
+		result = append(result, consentTransaction)
+	}
+	return result, nil
+}
+
+// Transaction submitted by OCMS, sql.ErrNoRows when it is unknown
+func (s *SqlContext) GetSubmittedTransaction(tr_uuid string) (ConsentTransaction, error) {
+	log.Trace(log.Here(), "GetSubmittedTransaction(", tr_uuid, ") : calling method -")
+	sql := "select Consent_id, Transaction_id, Application_id, Function, CreatedAt from consent_transactions where Transaction_id = ? limit 1"
+	consentTransaction := ConsentTransaction{}
+
+	stmt, err := s.Db.Prepare(sql)
+	if err != nil {
+		return consentTransaction, err
+	}
+	defer stmt.Close()
+
+	err1 := stmt.QueryRow(tr_uuid).Scan(&consentTransaction.Consentid, &consentTransaction.Txuuid, &consentTransaction.Appid, &consentTransaction.Function, &consentTransaction.CreatedAt)
+	return consentTransaction, err1
+}
@@ -0,0 +1,40 @@
+/*
+Copyright Pascal Limeux. 2017 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+       http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package model
+
+import (
+	"database/sql"
+	"embed"
+	authmodel "github.com/pascallimeux/ocms2/modules/auth/model"
+	"github.com/pascallimeux/ocms2/modules/log"
+)
+
+const OCMS_SCHEMA = "ocms"
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Tables of OCMS, versioned apart from the auth tables of the same database
+var Schema = authmodel.Schema{Name: OCMS_SCHEMA, Table: "ocms_schema_version", Files: migrationFiles, Hooks: map[int]func(tx *sql.Tx) error{}}
+
+// Apply the pending migrations of the OCMS tables, run at startup after the auth ones
+func (s *SqlContext) MigrateUp() error {
+	log.Trace(log.Here(), "MigrateUp() : calling method -")
+	authContext := authmodel.SqlContext{Db: s.Db}
+	err := authContext.MigrateUpOf(Schema)
+	if err != nil {
+		log.Error(log.Here(), err.Error())
+	}
+	return err
+}
@@ -0,0 +1,8 @@
+drop table if exists consent_expirations;
+drop table if exists consent_events;
+drop table if exists webhook_dead_letters;
+drop table if exists webhook_subscriptions;
+drop table if exists webhooks;
+drop table if exists consent_index_sync;
+drop table if exists consent_index;
+drop table if exists consent_transactions;
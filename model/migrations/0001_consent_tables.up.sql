@@ -0,0 +1,10 @@
+create table if not exists consent_transactions (Consent_id varchar(255), Transaction_id varchar(255), Application_id varchar(255), Function varchar(50), CreatedAt datetime, primary key (Consent_id, Transaction_id));
+create index if not exists consent_transactions_tx on consent_transactions (Transaction_id);
+create table if not exists consent_index (Consent_id varchar(255), Application_id varchar(255), Owner_id varchar(255), Consumer_id varchar(255), Datatype varchar(255), Dataaccess varchar(50), Dt_begin varchar(50), Dt_end varchar(50), State varchar(50), Transitions text, primary key (Consent_id));
+create table if not exists consent_index_sync (Application_id varchar(255), SyncedAt datetime, primary key (Application_id));
+create table if not exists webhooks (Id varchar(255) primary key, Application_id varchar(255), Url varchar(1024), Secret varchar(255), CreatedAt datetime);
+create table if not exists webhook_subscriptions (Webhook_id varchar(255), Event varchar(50), primary key (Webhook_id, Event));
+create table if not exists webhook_dead_letters (Id varchar(255) primary key, Webhook_id varchar(255), Event_id varchar(255), Event varchar(50), Payload text, Attempts integer, Error text, FailedAt datetime);
+create table if not exists consent_events (Seq integer primary key autoincrement, Id varchar(255), Type varchar(50), Application_id varchar(255), Consent_id varchar(255), Owner_id varchar(255), Consumer_id varchar(255), Payload text, OccurredAt datetime);
+create index if not exists consent_events_app on consent_events (Application_id, Seq);
+create table if not exists consent_expirations (Consent_id varchar(255) primary key, Application_id varchar(255), NotifiedAt datetime);
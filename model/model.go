@@ -14,19 +14,148 @@ limitations under the License.
 package model
 
 import (
+	"database/sql"
 	"fmt"
+	_ "github.com/mattn/go-sqlite3"
+	"time"
 )
 
 type Consent struct {
-	Consentid  string
-	Action     string
-	Appid      string
+	Consentid        string
+	Action           string
+	Appid            string
+	State            string
+	Ownerid          string
+	Consumerid       string
+	Datatype         string
+	Dataaccess       string
+	Dt_begin         string
+	Dt_end           string
+	Transitions      []Transition `json:",omitempty"`
+	At               string       `json:",omitempty"` // isconsent instant, now when empty
+	From             string       `json:",omitempty"` // listing filters, sort and page
+	To               string       `json:",omitempty"`
+	Sort             string       `json:",omitempty"`
+	Limit            int          `json:",omitempty"`
+	Cursor           string       `json:",omitempty"`
+	Include_inactive bool         `json:",omitempty"` // list revoked, expired... consents too
+	Wait             bool         `json:",omitempty"` // answer once the write transaction is committed
+}
+
+// Page of a consent listing, NextCursor is empty on the last page
+type ConsentPage struct {
+	Total      int       `json:"total"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+	Consents   []Consent `json:"consents"`
+}
+
+type Transition struct {
+	State string
+	Date  string
+}
+
+type DecodedConsent struct {
+	Txuuid     string `json:"txuuid"`
+	Appid      string `json:"appid"`
+	Ownerid    string `json:"ownerid"`
+	Consumerid string `json:"consumerid"`
+	Datatype   string `json:"datatype"`
+	Dataaccess string `json:"dataaccess"`
+	Dt_begin   string `json:"dt_begin"`
+	Dt_end     string `json:"dt_end"`
+	Ccid       string `json:"ccid"`
+}
+
+// Ledger transaction which touched a consent, kept in the local index
+type ConsentTransaction struct {
+	Consentid string
+	Txuuid    string
+	Appid     string
+	Function  string
+	CreatedAt time.Time
+}
+
+// One entry of a consent history
+type ConsentEvent struct {
+	Txuuid    string   `json:"txuuid"`
+	Timestamp string   `json:"timestamp"`
+	Function  string   `json:"function"`
+	Signer    string   `json:"signer,omitempty"`
+	Args      []string `json:"args"`
+}
+
+// Answer to one tuple of a batch check, Code and Error are set when the tuple could not be evaluated
+type ConsentDecision struct {
 	Ownerid    string
 	Consumerid string
 	Datatype   string
 	Dataaccess string
-	Dt_begin   string
-	Dt_end     string
+	At         string `json:",omitempty"`
+	Consent    string
+	Consentid  string `json:",omitempty"`
+	Code       string `json:",omitempty"`
+	Error      string `json:",omitempty"`
+}
+
+// Outcome of one item of a batch write, Status is the HTTP status the item would have got alone
+type BatchResult struct {
+	Index     int
+	Consentid string `json:",omitempty"`
+	Txuuid    string `json:",omitempty"`
+	Status    int
+	Code      string `json:",omitempty"`
+	Error     string `json:",omitempty"`
+}
+
+type BatchResponse struct {
+	Succeeded int
+	Failed    int
+	Results   []BatchResult
+}
+
+// Commit status of a submitted transaction, Function and Consentid are empty for a transaction not submitted by OCMS
+type TransactionStatus struct {
+	Txuuid      string `json:"txuuid"`
+	Status      string `json:"status"`
+	Function    string `json:"function,omitempty"`
+	Consentid   string `json:"consentid,omitempty"`
+	SubmittedAt string `json:"submitted_at,omitempty"`
+	Note        string `json:"note,omitempty"`
+}
+
+// Subscription of an application to consent events, no Events means every event.
+// The secret signs the payloads, it is only sent back when the webhook is registered.
+type Webhook struct {
+	Id        string
+	Appid     string
+	Url       string
+	Events    []string
+	Secret    string `json:",omitempty"`
+	CreatedAt time.Time
+}
+
+// Consent event posted to the webhooks and streamed to the SSE clients, Seq is its rank in the event log
+type WebhookEvent struct {
+	Seq        int64   `json:"seq,omitempty"`
+	Id         string  `json:"id"`
+	Type       string  `json:"type"`
+	Appid      string  `json:"appid"`
+	Consentid  string  `json:"consentid"`
+	Txuuid     string  `json:"txuuid,omitempty"`
+	OccurredAt string  `json:"occurred_at"`
+	Consent    Consent `json:"consent"`
+}
+
+// Delivery given up after the last retry, kept until it is replayed
+type DeadLetter struct {
+	Id        string
+	Webhookid string
+	Eventid   string
+	Event     string
+	Payload   string
+	Attempts  int
+	Error     string
+	FailedAt  time.Time
 }
 
 type IsConsent struct {
@@ -34,6 +163,11 @@ type IsConsent struct {
 }
 
 func (c *Consent) Print() string {
-	consentStr := fmt.Sprintf("ConsentID:%s ConsumerID:%s OwnerID:%s Datatype:%s Dataaccess:%s Dt_begin:%s Dt_end:%s", c.Consentid, c.Consumerid, c.Ownerid, c.Datatype, c.Dataaccess, c.Dt_begin, c.Dt_end)
+	consentStr := fmt.Sprintf("ConsentID:%s State:%s ConsumerID:%s OwnerID:%s Datatype:%s Dataaccess:%s Dt_begin:%s Dt_end:%s", c.Consentid, c.State, c.Consumerid, c.Ownerid, c.Datatype, c.Dataaccess, c.Dt_begin, c.Dt_end)
 	return consentStr
 }
+
+// Local storage of the OCMS indexes, shares the database of the auth module
+type SqlContext struct {
+	Db *sql.DB
+}
@@ -0,0 +1,192 @@
+/*
+Copyright Pascal Limeux. 2017 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package model
+
+import (
+	"errors"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/pascallimeux/ocms2/modules/common"
+	"github.com/pascallimeux/ocms2/modules/log"
+	"time"
+)
+
+func (s *SqlContext) CreateWebhook(webhook Webhook) (Webhook, error) {
+	log.Trace(log.Here(), "CreateWebhook(", webhook.Url, ") : calling method -")
+	sql := "insert into webhooks (Id, Application_id, Url, Secret, CreatedAt) values (?, ?, ?, ?, ?)"
+	if webhook.Id == "" {
+		webhook.Id = common.Generate_uuid()
+	}
+	webhook.CreatedAt = time.Now()
+
+	tx, err1 := s.Db.Begin()
+	if err1 != nil {
+		return webhook, err1
+	}
+	result, err2 := tx.Exec(sql, webhook.Id, webhook.Appid, webhook.Url, webhook.Secret, webhook.CreatedAt)
+	if err2 != nil {
+		tx.Rollback()
+		return webhook, err2
+	}
+	rowAffected, err3 := result.RowsAffected()
+	if err3 != nil {
+		tx.Rollback()
+		return webhook, err3
+	}
+	if rowAffected != 1 {
+		tx.Rollback()
+		return webhook, errors.New("row not created")
+	}
+	for _, event := range webhook.Events {
+		_, err4 := tx.Exec("insert or replace into webhook_subscriptions (Webhook_id, Event) values (?, ?)", webhook.Id, event)
+		if err4 != nil {
+			tx.Rollback()
+			return webhook, err4
+		}
+	}
+	if webhook.Events == nil {
+		webhook.Events = make([]string, 0)
+	}
+	return webhook, tx.Commit()
+}
+
+func (s *SqlContext) GetWebhook(id string) (Webhook, error) {
+	log.Trace(log.Here(), "GetWebhook(", id, ") : calling method -")
+	sql := "select Id, Application_id, Url, Secret, CreatedAt from webhooks where Id = ?"
+	var webhook Webhook
+	stmt, err := s.Db.Prepare(sql)
+	if err != nil {
+		return webhook, err
+	}
+	defer stmt.Close()
+	err1 := stmt.QueryRow(id).Scan(&webhook.Id, &webhook.Appid, &webhook.Url, &webhook.Secret, &webhook.CreatedAt)
+	if err1 != nil {
+		return webhook, err1
+	}
+	webhook.Events, err1 = s.getWebhookEvents(id)
+	return webhook, err1
+}
+
+// Webhooks of an application, with their secret
+func (s *SqlContext) GetWebhooks(applicationID string) ([]Webhook, error) {
+	log.Trace(log.Here(), "GetWebhooks(", applicationID, ") : calling method -")
+	sql := "select Id, Application_id, Url, Secret, CreatedAt from webhooks where Application_id = ? order by CreatedAt"
+	var result = make([]Webhook, 0)
+	rows, err1 := s.Db.Query(sql, applicationID)
+	if err1 != nil {
+		return result, err1
+	}
+	defer rows.Close()
+	for rows.Next() {
+		webhook := Webhook{}
+		err2 := rows.Scan(&webhook.Id, &webhook.Appid, &webhook.Url, &webhook.Secret, &webhook.CreatedAt)
+		if err2 != nil {
+			return result, err2
+		}
+		result = append(result, webhook)
+	}
+	rows.Close()
+	for i := range result {
+		events, err3 := s.getWebhookEvents(result[i].Id)
+		if err3 != nil {
+			return result, err3
+		}
+		result[i].Events = events
+	}
+	return result, nil
+}
+
+// Delete a webhook, its subscriptions and its dead letters
+func (s *SqlContext) DeleteWebhook(id string) error {
+	log.Trace(log.Here(), "DeleteWebhook(", id, ") : calling method -")
+	tx, err1 := s.Db.Begin()
+	if err1 != nil {
+		return err1
+	}
+	for _, sql := range []string{"delete from webhook_subscriptions where Webhook_id = ?", "delete from webhook_dead_letters where Webhook_id = ?", "delete from webhooks where Id = ?"} {
+		_, err2 := tx.Exec(sql, id)
+		if err2 != nil {
+			tx.Rollback()
+			return err2
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SqlContext) getWebhookEvents(webhookID string) ([]string, error) {
+	var result = make([]string, 0)
+	rows, err1 := s.Db.Query("select Event from webhook_subscriptions where Webhook_id = ? order by Event", webhookID)
+	if err1 != nil {
+		return result, err1
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var event string
+		err2 := rows.Scan(&event)
+		if err2 != nil {
+			return result, err2
+		}
+		result = append(result, event)
+	}
+	return result, nil
+}
+
+func (s *SqlContext) AddDeadLetter(deadLetter DeadLetter) (DeadLetter, error) {
+	log.Trace(log.Here(), "AddDeadLetter(", deadLetter.Eventid, ") : calling method -")
+	sql := "insert into webhook_dead_letters (Id, Webhook_id, Event_id, Event, Payload, Attempts, Error, FailedAt) values (?, ?, ?, ?, ?, ?, ?, ?)"
+	if deadLetter.Id == "" {
+		deadLetter.Id = common.Generate_uuid()
+	}
+	stmt, err1 := s.Db.Prepare(sql)
+	if err1 != nil {
+		return deadLetter, err1
+	}
+	defer stmt.Close()
+	_, err2 := stmt.Exec(deadLetter.Id, deadLetter.Webhookid, deadLetter.Eventid, deadLetter.Event, deadLetter.Payload, deadLetter.Attempts, deadLetter.Error, deadLetter.FailedAt)
+	return deadLetter, err2
+}
+
+// Dead letters of the webhooks of an application, of a single webhook when webhookID is set
+func (s *SqlContext) GetDeadLetters(applicationID, webhookID string) ([]DeadLetter, error) {
+	log.Trace(log.Here(), "GetDeadLetters(", applicationID, ", ", webhookID, ") : calling method -")
+	sql := "select d.Id, d.Webhook_id, d.Event_id, d.Event, d.Payload, d.Attempts, d.Error, d.FailedAt from webhook_dead_letters d join webhooks w on w.Id = d.Webhook_id where w.Application_id = ? and (? = '' or d.Webhook_id = ?) order by d.FailedAt"
+	var result = make([]DeadLetter, 0)
+	rows, err1 := s.Db.Query(sql, applicationID, webhookID, webhookID)
+	if err1 != nil {
+		return result, err1
+	}
+	defer rows.Close()
+	for rows.Next() {
+		deadLetter := DeadLetter{}
+		err2 := rows.Scan(&deadLetter.Id, &deadLetter.Webhookid, &deadLetter.Eventid, &deadLetter.Event, &deadLetter.Payload, &deadLetter.Attempts, &deadLetter.Error, &deadLetter.FailedAt)
+		if err2 != nil {
+			return result, err2
+		}
+		result = append(result, deadLetter)
+	}
+	return result, nil
+}
+
+func (s *SqlContext) GetDeadLetter(id string) (DeadLetter, error) {
+	log.Trace(log.Here(), "GetDeadLetter(", id, ") : calling method -")
+	sql := "select Id, Webhook_id, Event_id, Event, Payload, Attempts, Error, FailedAt from webhook_dead_letters where Id = ?"
+	deadLetter := DeadLetter{}
+	err := s.Db.QueryRow(sql, id).Scan(&deadLetter.Id, &deadLetter.Webhookid, &deadLetter.Eventid, &deadLetter.Event, &deadLetter.Payload, &deadLetter.Attempts, &deadLetter.Error, &deadLetter.FailedAt)
+	return deadLetter, err
+}
+
+func (s *SqlContext) DeleteDeadLetter(id string) error {
+	log.Trace(log.Here(), "DeleteDeadLetter(", id, ") : calling method -")
+	_, err := s.Db.Exec("delete from webhook_dead_letters where Id = ?", id)
+	return err
+}
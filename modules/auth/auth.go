@@ -14,11 +14,14 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"github.com/pascallimeux/ocms2/modules/auth/initialize"
 	"github.com/pascallimeux/ocms2/modules/auth/setting"
 	"github.com/pascallimeux/ocms2/modules/log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
@@ -36,8 +39,24 @@ func main() {
 		panic(err.Error())
 	}
 
+	// Init logger
+	f := log.Init_log(configuration.LogFileName, configuration.LogMode)
+	defer f.Close()
+
+	// Migrate the database schema and exit
+	if len(args) >= 1 && args[0] == "migrate" {
+		err1 := initialize.Migrate(args[1:], configuration)
+		if err1 != nil {
+			panic(err1.Error())
+		}
+		return
+	}
+
+	// Closed on shutdown to stop the background jobs
+	stop := make(chan struct{})
+
 	// Init application
-	router, authContext, err2 := initialize.Init(initDB, configuration)
+	router, authContext, err2 := initialize.Init(initDB, configuration, stop)
 	if err2 != nil {
 		panic(err2.Error())
 	}
@@ -50,5 +69,25 @@ func main() {
 		ReadTimeout:  configuration.ReadTimeout * time.Nanosecond,
 		WriteTimeout: configuration.WriteTimeout * time.Nanosecond,
 	}
-	log.Fatal(log.Here(), s.ListenAndServe().Error())
+	done := make(chan struct{})
+	go shutdown(s, stop, done)
+	err3 := s.ListenAndServe()
+	if err3 != http.ErrServerClosed {
+		log.Fatal(log.Here(), err3.Error())
+	}
+	<-done
+	if authContext.Maintenance != nil {
+		<-authContext.Maintenance.Stopped()
+	}
+}
+
+// Stop the background jobs and the http server on SIGINT or SIGTERM, done is closed once the requests are served
+func shutdown(s *http.Server, stop, done chan struct{}) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
+	log.Info(log.Here(), "Shutting down")
+	close(stop)
+	s.Shutdown(context.Background())
+	close(done)
 }
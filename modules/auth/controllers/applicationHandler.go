@@ -0,0 +1,254 @@
+/*
+Copyright Pascal Limeux. 2017 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+       http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/pascallimeux/ocms2/modules/auth/model"
+	"github.com/pascallimeux/ocms2/modules/common"
+	"github.com/pascallimeux/ocms2/modules/log"
+	"net/http"
+	"strings"
+)
+
+//HTTP Post - /o/application
+func (a *AppContext) postApplication(w http.ResponseWriter, r *http.Request) {
+	log.Trace(log.Here(), "postApplication() : calling method -")
+
+	err1 := a.CheckPermissionFromToken(w, r, "postApplication", "")
+	if err1 != nil {
+		return
+	}
+
+	var application model.Application
+	err := json.NewDecoder(r.Body).Decode(&application)
+	if err != nil {
+		common.SendError(log.Here(), w, err)
+		return
+	}
+	if application.Name == "" {
+		common.SendError(log.Here(), w, common.NewValidationError("no name given"))
+		return
+	}
+	application, err = a.SqlContext.CreateApplication(application)
+	if err != nil {
+		common.SendError(log.Here(), w, err)
+		return
+	}
+	applicationString, _ := json.Marshal(application)
+	log.Trace(log.Here(), "register application:", string(applicationString))
+	common.BuildHttp201Response(w, application)
+}
+
+//HTTP Get - /o/application/{id}
+func (a *AppContext) getApplication(w http.ResponseWriter, r *http.Request) {
+	log.Trace(log.Here(), "getApplication() : calling method -")
+
+	err1 := a.CheckPermissionFromToken(w, r, "getApplication", "")
+	if err1 != nil {
+		return
+	}
+
+	vars := mux.Vars(r)
+	application, err2 := a.SqlContext.GetApplication(vars["id"])
+	if err2 != nil {
+		common.SendError(log.Here(), w, err2)
+		return
+	}
+	common.BuildHttp200Response(w, application)
+}
+
+//HTTP Get - /o/application
+func (a *AppContext) getApplications(w http.ResponseWriter, r *http.Request) {
+	log.Trace(log.Here(), "getApplications() : calling method -")
+
+	err1 := a.CheckPermissionFromToken(w, r, "getApplications", "")
+	if err1 != nil {
+		return
+	}
+
+	applications, err := a.SqlContext.GetApplications()
+	if err != nil {
+		common.SendError(log.Here(), w, err)
+		return
+	}
+	common.BuildHttp200Response(w, applications)
+}
+
+//HTTP Post - /o/application/{id}/user
+func (a *AppContext) postApplicationUser(w http.ResponseWriter, r *http.Request) {
+	log.Trace(log.Here(), "postApplicationUser() : calling method -")
+
+	err1 := a.CheckPermissionFromToken(w, r, "postApplicationUser", "")
+	if err1 != nil {
+		return
+	}
+
+	type ApplicationUser struct {
+		User_id string `json:"user_id"`
+	}
+	var applicationUser ApplicationUser
+	err := json.NewDecoder(r.Body).Decode(&applicationUser)
+	if err != nil {
+		common.SendError(log.Here(), w, err)
+		return
+	}
+	vars := mux.Vars(r)
+	application, err2 := a.SqlContext.GetApplication(vars["id"])
+	if err2 != nil {
+		common.SendError(log.Here(), w, err2)
+		return
+	}
+	_, err3 := a.SqlContext.GetUser(applicationUser.User_id)
+	if err3 != nil {
+		common.SendError(log.Here(), w, err3)
+		return
+	}
+	err4 := a.SqlContext.AddApplicationUser(application.Id, applicationUser.User_id)
+	if err4 != nil {
+		common.SendError(log.Here(), w, err4)
+		return
+	}
+	users, _ := a.SqlContext.GetApplicationUsers(application.Id)
+	common.BuildHttp201Response(w, users)
+}
+
+//HTTP Delete - /o/application/{id}/user/{userid}
+func (a *AppContext) deleteApplicationUser(w http.ResponseWriter, r *http.Request) {
+	log.Trace(log.Here(), "deleteApplicationUser() : calling method -")
+
+	err1 := a.CheckPermissionFromToken(w, r, "deleteApplicationUser", "")
+	if err1 != nil {
+		return
+	}
+
+	vars := mux.Vars(r)
+	err := a.SqlContext.DeleteApplicationUser(vars["id"], vars["userid"])
+	if err != nil {
+		common.SendError(log.Here(), w, err)
+		return
+	}
+	users, _ := a.SqlContext.GetApplicationUsers(vars["id"])
+	common.BuildHttp200Response(w, users)
+}
+
+//HTTP Post - /o/application/{id}/permission
+func (a *AppContext) postApplicationPermission(w http.ResponseWriter, r *http.Request) {
+	log.Trace(log.Here(), "postApplicationPermission() : calling method -")
+
+	err1 := a.CheckPermissionFromToken(w, r, "postApplicationPermission", "")
+	if err1 != nil {
+		return
+	}
+
+	var permission model.ApplicationPermission
+	err := json.NewDecoder(r.Body).Decode(&permission)
+	if err != nil {
+		common.SendError(log.Here(), w, err)
+		return
+	}
+	if permission.Resource_name == "" {
+		common.SendError(log.Here(), w, common.NewValidationError("no resource_name given"))
+		return
+	}
+	vars := mux.Vars(r)
+	_, err2 := a.SqlContext.GetApplication(vars["id"])
+	if err2 != nil {
+		common.SendError(log.Here(), w, err2)
+		return
+	}
+	permission.Application_id = vars["id"]
+	permission, err = a.SqlContext.CreateApplicationPermission(permission)
+	if err != nil {
+		common.SendError(log.Here(), w, err)
+		return
+	}
+	common.BuildHttp201Response(w, permission)
+}
+
+//HTTP Post - /o/application/{id}/datatype, /o/application/{id}/dataaccess
+func (a *AppContext) postApplicationVocabulary(w http.ResponseWriter, r *http.Request) {
+	log.Trace(log.Here(), "postApplicationVocabulary() : calling method -")
+
+	err1 := a.CheckPermissionFromToken(w, r, "postApplicationVocabulary", "")
+	if err1 != nil {
+		return
+	}
+
+	type Term struct {
+		Name string `json:"name"`
+	}
+	var term Term
+	err := json.NewDecoder(r.Body).Decode(&term)
+	if err != nil {
+		common.SendError(log.Here(), w, err)
+		return
+	}
+	name := strings.Trim(term.Name, "/")
+	if name == "" {
+		common.SendError(log.Here(), w, common.NewValidationError("no name given"))
+		return
+	}
+	vars := mux.Vars(r)
+	_, err2 := a.SqlContext.GetApplication(vars["id"])
+	if err2 != nil {
+		common.SendError(log.Here(), w, err2)
+		return
+	}
+	if vars["vocabulary"] == "datatype" {
+		err = a.SqlContext.AddApplicationDatatype(vars["id"], name)
+	} else {
+		err = a.SqlContext.AddApplicationDataaccess(vars["id"], name)
+	}
+	if err != nil {
+		common.SendError(log.Here(), w, err)
+		return
+	}
+	application, _ := a.SqlContext.GetApplication(vars["id"])
+	common.BuildHttp201Response(w, application)
+}
+
+//HTTP Delete - /o/application/{id}/datatype?name=, /o/application/{id}/dataaccess?name=
+func (a *AppContext) deleteApplicationVocabulary(w http.ResponseWriter, r *http.Request) {
+	log.Trace(log.Here(), "deleteApplicationVocabulary() : calling method -")
+
+	err1 := a.CheckPermissionFromToken(w, r, "deleteApplicationVocabulary", "")
+	if err1 != nil {
+		return
+	}
+
+	vars := mux.Vars(r)
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		common.SendError(log.Here(), w, common.NewValidationError("no name given"))
+		return
+	}
+	var err error
+	if vars["vocabulary"] == "datatype" {
+		err = a.SqlContext.DeleteApplicationDatatype(vars["id"], name)
+	} else {
+		err = a.SqlContext.DeleteApplicationDataaccess(vars["id"], name)
+	}
+	if err != nil {
+		common.SendError(log.Here(), w, err)
+		return
+	}
+	application, err2 := a.SqlContext.GetApplication(vars["id"])
+	if err2 != nil {
+		common.SendError(log.Here(), w, err2)
+		return
+	}
+	common.BuildHttp200Response(w, application)
+}
@@ -0,0 +1,75 @@
+/*
+Copyright Pascal Limeux. 2017 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+       http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"encoding/json"
+	"github.com/pascallimeux/ocms2/modules/auth/model"
+	"github.com/pascallimeux/ocms2/modules/auth/setting"
+	"github.com/pascallimeux/ocms2/modules/common"
+	"net/http"
+	"testing"
+)
+
+func TestApplicationNominal(t *testing.T) {
+	token, err0 := getToken(setting.ADMINLOGIN, setting.ADMINPWD)
+	if err0 != nil {
+		t.Fatal(err0)
+	}
+	request, _ := common.BuildRequestWithToken("POST", httpServerTest.URL+APPLIURI, "{\"name\":\"appli1\",\"datatypes\":[\"BP\",\"HR\"]}", token.Token)
+	status, body, _ := common.ExecuteRequest(request)
+	application := model.Application{}
+	json.Unmarshal(body, &application)
+	if status != http.StatusCreated || application.Id == "" {
+		t.Fatal("register application: ", status, " ", string(body))
+	}
+
+	request, _ = common.BuildRequestWithToken("POST", httpServerTest.URL+APPLIURI, "{\"name\":\"appli1\"}", token.Token)
+	status, _, _ = common.ExecuteRequest(request)
+	if status != http.StatusConflict {
+		t.Error("duplicated application name: ", status)
+	}
+
+	user, _, _ := createUser(token.Token, HttpUser{Username: "appliuser1", Email: "appliuser1@orange.com", Password: "password", Role_id: 3})
+	request, _ = common.BuildRequestWithToken("POST", httpServerTest.URL+APPLIURI+"/"+application.Id+"/user", "{\"user_id\":\""+user.Id+"\"}", token.Token)
+	status, body, _ = common.ExecuteRequest(request)
+	if status != http.StatusCreated {
+		t.Error("add application user: ", status, " ", string(body))
+	}
+	member, _ := sqlContext.IsApplicationUser(application.Id, user.Id)
+	if !member {
+		t.Error("user not member of the application")
+	}
+
+	request, _ = common.BuildRequestWithToken("GET", httpServerTest.URL+APPLIURI+"/"+application.Id, " ", token.Token)
+	status, body, _ = common.ExecuteRequest(request)
+	read := model.Application{}
+	json.Unmarshal(body, &read)
+	if status != http.StatusOK || read.Name != "appli1" || len(read.Datatypes) != 2 {
+		t.Error("get application: ", status, " ", string(body))
+	}
+
+	request, _ = common.BuildRequestWithToken("GET", httpServerTest.URL+APPLIURI+"/unknown", " ", token.Token)
+	status, _, _ = common.ExecuteRequest(request)
+	if status != http.StatusNotFound {
+		t.Error("unknown application: ", status)
+	}
+
+	userToken, _ := getToken("appliuser1", "password")
+	request, _ = common.BuildRequestWithToken("GET", httpServerTest.URL+APPLIURI, " ", userToken.Token)
+	status, _, _ = common.ExecuteRequest(request)
+	if status != http.StatusForbidden {
+		t.Error("applications listed by a user: ", status)
+	}
+}
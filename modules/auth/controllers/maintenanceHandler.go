@@ -0,0 +1,223 @@
+/*
+Copyright Pascal Limeux. 2017 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"compress/gzip"
+	"github.com/pascallimeux/ocms2/modules/auth/model"
+	"github.com/pascallimeux/ocms2/modules/auth/setting"
+	"github.com/pascallimeux/ocms2/modules/common"
+	"github.com/pascallimeux/ocms2/modules/log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ARCHIVE_PREFIX = "auth-logs-"
+	ARCHIVE_SUFFIX = ".ndjson.gz"
+)
+
+// Housekeeping of the auth database, every Interval: purge of the expired sessions and refresh tokens,
+// retention policy of the logs, VACUUM and ANALYZE every OptimizeInterval.
+type Maintenance_Scheduler struct {
+	SqlContext       model.SqlContext
+	Interval         time.Duration
+	LogRetention     time.Duration
+	LogMaxRows       int
+	LogArchiveDir    string
+	OptimizeInterval time.Duration
+	running          sync.Mutex // one run at a time
+	mutex            sync.Mutex
+	status           model.MaintenanceStatus
+	lastOptimize     time.Time
+	stopped          chan struct{}
+}
+
+// Gzipped NDJSON file of the purged logs, written under a temporary name and renamed once the logs are deleted
+type logArchive struct {
+	path   string
+	file   *os.File
+	writer *gzip.Writer
+	closed bool
+}
+
+func NewMaintenanceScheduler(sqlContext model.SqlContext, configuration *setting.Settings) *Maintenance_Scheduler {
+	log.Trace(log.Here(), "NewMaintenanceScheduler() : calling method -")
+	return &Maintenance_Scheduler{SqlContext: sqlContext, Interval: configuration.MaintenanceInterval, LogRetention: configuration.LogRetention, LogMaxRows: configuration.LogMaxRows, LogArchiveDir: configuration.LogArchiveDir, OptimizeInterval: configuration.OptimizeInterval, stopped: make(chan struct{})}
+}
+
+// Run the housekeeping each interval until stop is closed, a run in progress is completed first
+func (m *Maintenance_Scheduler) Start(stop <-chan struct{}) {
+	log.Trace(log.Here(), "Start() : calling method -")
+	defer close(m.stopped)
+	ticker := time.NewTicker(m.Interval)
+	defer ticker.Stop()
+	m.mutex.Lock()
+	m.status.NextRun = time.Now().Add(m.Interval)
+	m.mutex.Unlock()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			m.Run()
+		}
+	}
+}
+
+// Closed once Start returns, the database may then be closed
+func (m *Maintenance_Scheduler) Stopped() <-chan struct{} {
+	return m.stopped
+}
+
+// A failed step does not stop the following ones, the errors are reported in the status
+func (m *Maintenance_Scheduler) Run() model.MaintenanceStatus {
+	log.Trace(log.Here(), "Run() : calling method -")
+	m.running.Lock()
+	defer m.running.Unlock()
+	status := model.MaintenanceStatus{StartedAt: time.Now()}
+	var errs []string
+
+	var err error
+	status.SessionsPurged, status.TokensPurged, err = m.SqlContext.PurgeExpiredSessions()
+	if err != nil {
+		errs = append(errs, "sessions: "+err.Error())
+	}
+	status.LogsPurged, status.Archive, err = m.purgeLogs(status.StartedAt)
+	if err != nil {
+		errs = append(errs, "logs: "+err.Error())
+	}
+	if m.OptimizeInterval > 0 && status.StartedAt.Sub(m.lastOptimize) >= m.OptimizeInterval {
+		err = m.SqlContext.Optimize()
+		if err != nil {
+			errs = append(errs, "optimize: "+err.Error())
+		} else {
+			status.Optimized = true
+			m.lastOptimize = status.StartedAt
+		}
+	}
+
+	status.Error = strings.Join(errs, "; ")
+	status.FinishedAt = time.Now()
+	if m.Interval > 0 {
+		status.NextRun = status.StartedAt.Add(m.Interval)
+	}
+	log.Info(log.Here(), "housekeeping: ", strconv.FormatInt(status.SessionsPurged, 10), " sessions, ", strconv.FormatInt(status.TokensPurged, 10), " refresh tokens, ", strconv.FormatInt(status.LogsPurged, 10), " logs purged")
+	if status.Error != "" {
+		log.Error(log.Here(), "housekeeping: ", status.Error)
+	}
+	m.mutex.Lock()
+	m.status = status
+	m.mutex.Unlock()
+	return status
+}
+
+func (m *Maintenance_Scheduler) Status() model.MaintenanceStatus {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.status
+}
+
+// Logs purged and their archive
+func (m *Maintenance_Scheduler) purgeLogs(now time.Time) (int64, string, error) {
+	if m.LogRetention <= 0 && m.LogMaxRows <= 0 {
+		return 0, "", nil
+	}
+	var before time.Time
+	if m.LogRetention > 0 {
+		before = now.Add(-m.LogRetention)
+	}
+	if m.LogArchiveDir == "" {
+		purged, err := m.SqlContext.PurgeLogs(before, m.LogMaxRows, nil)
+		return purged, "", err
+	}
+	archive, err1 := newLogArchive(m.LogArchiveDir, now)
+	if err1 != nil {
+		return 0, "", err1
+	}
+	purged, err2 := m.SqlContext.PurgeLogs(before, m.LogMaxRows, archive)
+	if purged == 0 {
+		archive.discard()
+		return 0, "", err2
+	}
+	if err2 != nil {
+		// The logs are deleted, their archive keeps its temporary name
+		return purged, archive.file.Name(), err2
+	}
+	return purged, archive.path, nil
+}
+
+func newLogArchive(dir string, now time.Time) (*logArchive, error) {
+	err := os.MkdirAll(dir, 0750)
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, ARCHIVE_PREFIX+now.UTC().Format("20060102T150405.000")+ARCHIVE_SUFFIX)
+	file, err1 := os.OpenFile(path+".tmp", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0640)
+	if err1 != nil {
+		return nil, err1
+	}
+	return &logArchive{path: path, file: file, writer: gzip.NewWriter(file)}, nil
+}
+
+func (a *logArchive) Write(p []byte) (int, error) {
+	return a.writer.Write(p)
+}
+
+func (a *logArchive) Close() error {
+	a.closed = true
+	err := a.writer.Close()
+	if err == nil {
+		err = a.file.Sync()
+	}
+	err1 := a.file.Close()
+	if err == nil {
+		err = err1
+	}
+	return err
+}
+
+func (a *logArchive) Publish() error {
+	return os.Rename(a.file.Name(), a.path)
+}
+
+// Remove an archive without log or of which logs were not purged
+func (a *logArchive) discard() {
+	if !a.closed {
+		a.writer.Close()
+		a.file.Close()
+	}
+	os.Remove(a.file.Name())
+	os.Remove(a.path)
+}
+
+//HTTP Get - /o/maintenance
+func (a *AppContext) getMaintenance(w http.ResponseWriter, r *http.Request) {
+	log.Trace(log.Here(), "getMaintenance() : calling method -")
+
+	err1 := a.CheckPermissionFromToken(w, r, "getMaintenance", "")
+	if err1 != nil {
+		return
+	}
+	if a.Maintenance == nil {
+		common.SendError(log.Here(), w, common.NewNotFoundError("housekeeping is not enabled"))
+		return
+	}
+	common.BuildHttp200Response(w, a.Maintenance.Status())
+}
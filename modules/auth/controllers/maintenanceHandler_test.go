@@ -0,0 +1,160 @@
+/*
+Copyright Pascal Limeux. 2017 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/pascallimeux/ocms2/modules/auth/model"
+	"github.com/pascallimeux/ocms2/modules/auth/setting"
+	"github.com/pascallimeux/ocms2/modules/common"
+)
+
+func TestMaintenanceNominal(t *testing.T) {
+	adminToken, _ := getToken(setting.ADMINLOGIN, setting.ADMINPWD)
+	_, status, _ := getMaintenance(adminToken.Token)
+	if status != http.StatusNotFound {
+		t.Error("housekeeping status without housekeeping: ", status)
+	}
+
+	archiveDir := t.TempDir()
+	scheduler := &Maintenance_Scheduler{SqlContext: sqlContext, Interval: time.Hour, LogRetention: 24 * time.Hour, LogArchiveDir: archiveDir, OptimizeInterval: time.Hour}
+	appContext.Maintenance = scheduler
+	defer func() { appContext.Maintenance = nil }()
+	for i := 0; i < 3; i++ {
+		_, err := sqlContext.CreateLog(model.Logg{Timestamp: time.Now().Add(-48 * time.Hour), Resource_name: "oldResource", User_id: "maintenance"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	session, _, _ := sqlContext.CreateSession("maintenance", "test", "127.0.0.1", time.Now().Add(-time.Hour), -1)
+
+	result := scheduler.Run()
+	if result.Error != "" || result.LogsPurged != 3 || result.SessionsPurged < 1 || result.TokensPurged < 1 || !result.Optimized {
+		t.Fatal("bad housekeeping: ", result)
+	}
+	_, err1 := sqlContext.GetSession(session.Id)
+	if err1 == nil {
+		t.Error("expired session not purged")
+	}
+	archived := readArchive(t, result.Archive)
+	if len(archived) != 3 || archived[0].Resource_name != "oldResource" {
+		t.Error("bad archive: ", archived)
+	}
+
+	reported, status1, err2 := getMaintenance(adminToken.Token)
+	if err2 != nil || status1 != http.StatusOK || reported.LogsPurged != 3 || reported.Archive != result.Archive {
+		t.Error("bad housekeeping status: ", status1, " ", reported, " ", err2)
+	}
+
+	// Nothing left to purge, no archive
+	result = scheduler.Run()
+	if result.LogsPurged != 0 || result.Archive != "" || result.Optimized {
+		t.Error("bad second housekeeping: ", result)
+	}
+}
+
+func TestMaintenanceMaxRows(t *testing.T) {
+	for i := 0; i < 10; i++ {
+		sqlContext.CreateLog(model.Logg{Timestamp: time.Now(), Resource_name: "recentResource", User_id: "maintenance"})
+	}
+	scheduler := &Maintenance_Scheduler{SqlContext: sqlContext, LogMaxRows: 5}
+	result := scheduler.Run()
+	if result.Error != "" || result.Archive != "" {
+		t.Fatal("bad housekeeping: ", result)
+	}
+	logs, _ := sqlContext.GetLogs()
+	if len(logs) != 5 {
+		t.Error("logs beyond the limit kept: ", len(logs))
+	}
+}
+
+func TestMaintenanceFailedPurge(t *testing.T) {
+	archiveDir := t.TempDir()
+	sqlContext.CreateLog(model.Logg{Timestamp: time.Now().Add(-48 * time.Hour), Resource_name: "keptResource", User_id: "maintenance"})
+	_, err := sqlContext.Db.Exec("create trigger keep_logs before delete on logs begin select raise(abort, 'logs kept'); end")
+	if err != nil {
+		t.Fatal(err)
+	}
+	scheduler := &Maintenance_Scheduler{SqlContext: sqlContext, LogRetention: 24 * time.Hour, LogArchiveDir: archiveDir}
+	result := scheduler.Run()
+	sqlContext.Db.Exec("drop trigger keep_logs")
+	if result.Error == "" || result.LogsPurged != 0 || result.Archive != "" {
+		t.Error("bad failed housekeeping: ", result)
+	}
+	files, _ := os.ReadDir(archiveDir)
+	if len(files) != 0 {
+		t.Error("archive left after a failed delete: ", files)
+	}
+	result = scheduler.Run()
+	if result.Error != "" || result.LogsPurged < 1 || len(readArchive(t, result.Archive)) != int(result.LogsPurged) {
+		t.Error("logs not purged after the failure: ", result)
+	}
+}
+
+func getMaintenance(tokenValue string) (model.MaintenanceStatus, int, error) {
+	var status model.MaintenanceStatus
+	request, err := common.BuildRequestWithToken("GET", httpServerTest.URL+MAINTENURI, " ", tokenValue)
+	if err != nil {
+		return status, 0, err
+	}
+	code, body_bytes, err2 := common.ExecuteRequest(request)
+	if err2 != nil || code != http.StatusOK {
+		return status, code, err2
+	}
+	err = json.Unmarshal(body_bytes, &status)
+	return status, code, err
+}
+
+func readArchive(t *testing.T, path string) []model.Logg {
+	var logs []model.Logg
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	reader, err1 := gzip.NewReader(file)
+	if err1 != nil {
+		t.Fatal(err1)
+	}
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		var logg model.Logg
+		err2 := json.Unmarshal(scanner.Bytes(), &logg)
+		if err2 != nil {
+			t.Fatal(err2)
+		}
+		logs = append(logs, logg)
+	}
+	return logs
+}
+
+func TestMaintenanceStop(t *testing.T) {
+	scheduler := NewMaintenanceScheduler(sqlContext, &setting.Settings{MaintenanceInterval: 10 * time.Millisecond})
+	stop := make(chan struct{})
+	go scheduler.Start(stop)
+	time.Sleep(30 * time.Millisecond)
+	close(stop)
+	select {
+	case <-scheduler.Stopped():
+	case <-time.After(time.Second):
+		t.Error("housekeeping not stopped")
+	}
+}
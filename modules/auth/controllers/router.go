@@ -17,6 +17,7 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/pascallimeux/ocms2/modules/auth/model"
 	"github.com/pascallimeux/ocms2/modules/auth/setting"
+	"github.com/pascallimeux/ocms2/modules/common"
 	"github.com/pascallimeux/ocms2/modules/log"
 	"net/http"
 )
@@ -26,19 +27,26 @@ const (
 	REGISTERURI = "/o/register"
 	ROLEURI     = "/o/role"
 	AUTHURI     = "/o/auth"
+	REFRESHURI  = "/o/auth/refresh"
+	LOGOUTURI   = "/o/auth/logout"
 	LOGURI      = "/o/log"
+	APPLIURI    = "/o/application"
+	MAINTENURI  = "/o/maintenance"
 )
 
 type AppContext struct {
-	HttpServer *http.Server
-	Settings   *setting.Settings
-	SqlContext model.SqlContext
+	HttpServer  *http.Server
+	Settings    *setting.Settings
+	SqlContext  model.SqlContext
+	Signer      *model.Jwt_Signer
+	Maintenance *Maintenance_Scheduler
 }
 
 // Initialize API
 func (appContext *AppContext) CreateAUTHRoutes() *mux.Router {
 	log.Trace(log.Here(), "CreateAUTHRoutes() : calling method -")
 	router := mux.NewRouter().StrictSlash(false)
+	router.Use(common.RequestID)
 
 	router.HandleFunc(REGISTERURI, appContext.registerUser).Methods("POST")     // create user
 	router.HandleFunc(USERURI+"/{id}", appContext.getUser).Methods("GET")       // read a user
@@ -46,13 +54,30 @@ func (appContext *AppContext) CreateAUTHRoutes() *mux.Router {
 	router.HandleFunc(USERURI+"/{id}", appContext.deleteUser).Methods("DELETE") // delete a user
 	router.HandleFunc(USERURI, appContext.getUsers).Methods("GET")              // get liste of users
 
+	router.HandleFunc(USERURI+"/{id}/session", appContext.getSessions).Methods("GET")                  // get the active sessions of a user
+	router.HandleFunc(USERURI+"/{id}/session", appContext.revokeSessions).Methods("DELETE")            // revoke every session of a user
+	router.HandleFunc(USERURI+"/{id}/session/{sessionid}", appContext.revokeSession).Methods("DELETE") // revoke a session of a user
+
 	router.HandleFunc(ROLEURI, appContext.postRole).Methods("POST")       // create a role
 	router.HandleFunc(ROLEURI+"/{id}", appContext.getRole).Methods("GET") // read a role
 	router.HandleFunc(ROLEURI, appContext.getRoles).Methods("GET")        // get liste of roles
 
-	router.HandleFunc(AUTHURI, appContext.getToken).Methods("POST") // get a token
+	router.HandleFunc(AUTHURI, appContext.getToken).Methods("POST")        // get a token
+	router.HandleFunc(REFRESHURI, appContext.refreshToken).Methods("POST") // renew a token
+	router.HandleFunc(LOGOUTURI, appContext.logout).Methods("POST")        // revoke the session of a token
 
 	router.HandleFunc(LOGURI+"/{from}/{to}", appContext.getLogs4dates).Methods("GET") // get logs for a periode
 	router.HandleFunc(LOGURI, appContext.getLogs).Methods("GET")                      // get all logs
+
+	router.HandleFunc(MAINTENURI, appContext.getMaintenance).Methods("GET") // status of the last housekeeping run
+
+	router.HandleFunc(APPLIURI, appContext.postApplication).Methods("POST")                                                        // register an application
+	router.HandleFunc(APPLIURI+"/{id}", appContext.getApplication).Methods("GET")                                                  // read an application
+	router.HandleFunc(APPLIURI, appContext.getApplications).Methods("GET")                                                         // get liste of applications
+	router.HandleFunc(APPLIURI+"/{id}/user", appContext.postApplicationUser).Methods("POST")                                       // add a user to an application
+	router.HandleFunc(APPLIURI+"/{id}/user/{userid}", appContext.deleteApplicationUser).Methods("DELETE")                          // remove a user from an application
+	router.HandleFunc(APPLIURI+"/{id}/permission", appContext.postApplicationPermission).Methods("POST")                           // grant a permission for an application
+	router.HandleFunc(APPLIURI+"/{id}/{vocabulary:datatype|dataaccess}", appContext.postApplicationVocabulary).Methods("POST")     // register a datatype or an access mode
+	router.HandleFunc(APPLIURI+"/{id}/{vocabulary:datatype|dataaccess}", appContext.deleteApplicationVocabulary).Methods("DELETE") // unregister a datatype or an access mode
 	return router
 }
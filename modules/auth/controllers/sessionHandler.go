@@ -0,0 +1,82 @@
+/*
+Copyright Pascal Limeux. 2017 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"database/sql"
+	"github.com/gorilla/mux"
+	"github.com/pascallimeux/ocms2/modules/common"
+	"github.com/pascallimeux/ocms2/modules/log"
+	"net/http"
+)
+
+//HTTP Get - /o/user/{id}/session
+func (a *AppContext) getSessions(w http.ResponseWriter, r *http.Request) {
+	log.Trace(log.Here(), "getSessions() : calling method -")
+
+	vars := mux.Vars(r)
+	userid := vars["id"]
+	err1 := a.CheckPermissionFromToken(w, r, "getSessions", userid)
+	if err1 != nil {
+		return
+	}
+	sessions, err := a.SqlContext.GetActiveSessions(userid)
+	if err != nil {
+		common.SendError(log.Here(), w, err)
+		return
+	}
+	common.BuildHttp200Response(w, sessions)
+}
+
+//HTTP Delete - /o/user/{id}/session
+func (a *AppContext) revokeSessions(w http.ResponseWriter, r *http.Request) {
+	log.Trace(log.Here(), "revokeSessions() : calling method -")
+
+	vars := mux.Vars(r)
+	userid := vars["id"]
+	err1 := a.CheckPermissionFromToken(w, r, "revokeSessions", userid)
+	if err1 != nil {
+		return
+	}
+	err := a.SqlContext.RevokeSessions(userid)
+	if err != nil {
+		common.SendError(log.Here(), w, err)
+		return
+	}
+	common.BuildHttp204Response(w)
+}
+
+//HTTP Delete - /o/user/{id}/session/{sessionid}
+func (a *AppContext) revokeSession(w http.ResponseWriter, r *http.Request) {
+	log.Trace(log.Here(), "revokeSession() : calling method -")
+
+	vars := mux.Vars(r)
+	userid := vars["id"]
+	err1 := a.CheckPermissionFromToken(w, r, "revokeSessions", userid)
+	if err1 != nil {
+		return
+	}
+	session, err := a.SqlContext.GetSession(vars["sessionid"])
+	if err == sql.ErrNoRows || (err == nil && session.User_id != userid) {
+		err = common.NewNotFoundError("session " + vars["sessionid"] + " not found")
+	}
+	if err == nil {
+		err = a.SqlContext.RevokeSession(session.Id)
+	}
+	if err != nil {
+		common.SendError(log.Here(), w, err)
+		return
+	}
+	common.BuildHttp204Response(w)
+}
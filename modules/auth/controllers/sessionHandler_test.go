@@ -0,0 +1,189 @@
+/*
+Copyright Pascal Limeux. 2017 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/pascallimeux/ocms2/modules/auth/model"
+	"github.com/pascallimeux/ocms2/modules/auth/setting"
+	"github.com/pascallimeux/ocms2/modules/common"
+)
+
+func TestLogoutNominal(t *testing.T) {
+	adminToken, _ := getToken(setting.ADMINLOGIN, setting.ADMINPWD)
+	user, _, err := createUser(adminToken.Token, HttpUser{Username: "session1", Email: "session1@orange.fr", Password: "session1pwd", Role_id: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token1, _ := getToken("session1", "session1pwd")
+	token2, _ := getToken("session1", "session1pwd")
+
+	sessions, status, err1 := getSessions(token1.Token, user.Id)
+	if err1 != nil || status != http.StatusOK || len(sessions) != 2 {
+		t.Fatal("bad sessions: ", status, " ", sessions, " ", err1)
+	}
+	if sessions[0].Client == "" || sessions[0].Address == "" || sessions[0].CreatedAt.IsZero() {
+		t.Error("no client info: ", sessions[0])
+	}
+
+	status2, _ := sendWithToken("POST", LOGOUTURI, token1.Token)
+	if status2 != http.StatusNoContent {
+		t.Error("logout failed: ", status2)
+	}
+	_, status3, _ := getUser(token1.Token, user.Id)
+	if status3 != http.StatusUnauthorized {
+		t.Error("access token accepted after logout: ", status3)
+	}
+	_, status4, _ := refreshToken(token1.Refresh_token)
+	if status4 != http.StatusUnauthorized {
+		t.Error("refresh token accepted after logout: ", status4)
+	}
+	_, status5, _ := getUser(token2.Token, user.Id)
+	if status5 != http.StatusOK {
+		t.Error("other session closed by logout: ", status5)
+	}
+	sessions, _, _ = getSessions(token2.Token, user.Id)
+	if len(sessions) != 1 {
+		t.Error("logged out session still listed: ", sessions)
+	}
+}
+
+func TestRevokeSessionsNominal(t *testing.T) {
+	adminToken, _ := getToken(setting.ADMINLOGIN, setting.ADMINPWD)
+	user, _, err := createUser(adminToken.Token, HttpUser{Username: "session2", Email: "session2@orange.fr", Password: "session2pwd", Role_id: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, _ := createUser(adminToken.Token, HttpUser{Username: "session3", Email: "session3@orange.fr", Password: "session3pwd", Role_id: 3})
+	token1, _ := getToken("session2", "session2pwd")
+	token2, _ := getToken("session2", "session2pwd")
+	otherToken, _ := getToken("session3", "session3pwd")
+
+	// A user only sees and revokes their own sessions
+	_, status, _ := getSessions(otherToken.Token, user.Id)
+	if status != http.StatusForbidden {
+		t.Error("sessions of another user listed: ", status)
+	}
+	status1, _ := sendWithToken("DELETE", USERURI+"/"+user.Id+"/session", otherToken.Token)
+	if status1 != http.StatusForbidden {
+		t.Error("sessions of another user revoked: ", status1)
+	}
+
+	// The administrator kills a single session
+	sessions, _, _ := getSessions(adminToken.Token, user.Id)
+	if len(sessions) != 2 {
+		t.Fatal("bad sessions: ", sessions)
+	}
+	status2, _ := sendWithToken("DELETE", USERURI+"/"+other.Id+"/session/"+sessions[0].Id, adminToken.Token)
+	if status2 != http.StatusNotFound {
+		t.Error("session revoked through another user: ", status2)
+	}
+	status3, _ := sendWithToken("DELETE", USERURI+"/"+user.Id+"/session/"+sessions[0].Id, adminToken.Token)
+	if status3 != http.StatusNoContent {
+		t.Error("session not revoked: ", status3)
+	}
+	_, status4, _ := getUser(token1.Token, user.Id)
+	if status4 != http.StatusUnauthorized {
+		t.Error("access token of a killed session accepted: ", status4)
+	}
+
+	// Then every session of the user
+	status5, _ := sendWithToken("DELETE", USERURI+"/"+user.Id+"/session", adminToken.Token)
+	if status5 != http.StatusNoContent {
+		t.Error("sessions not revoked: ", status5)
+	}
+	_, status6, _ := getUser(token2.Token, user.Id)
+	if status6 != http.StatusUnauthorized {
+		t.Error("access token accepted after revocation: ", status6)
+	}
+	_, status7, _ := getUser(otherToken.Token, other.Id)
+	if status7 != http.StatusOK {
+		t.Error("session of another user revoked: ", status7)
+	}
+}
+
+func TestDeactivationRevokesSessions(t *testing.T) {
+	adminToken, _ := getToken(setting.ADMINLOGIN, setting.ADMINPWD)
+	user, _, err := createUser(adminToken.Token, HttpUser{Username: "session4", Email: "session4@orange.fr", Password: "session4pwd", Role_id: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _ := getToken("session4", "session4pwd")
+	err1 := deleteUser(adminToken.Token, user.Id)
+	if err1 != nil {
+		t.Fatal(err1)
+	}
+	_, status, _ := getUser(token.Token, user.Id)
+	if status != http.StatusUnauthorized {
+		t.Error("access token of a deactivated user accepted: ", status)
+	}
+	_, status1, _ := refreshToken(token.Refresh_token)
+	if status1 != http.StatusUnauthorized {
+		t.Error("refresh token of a deactivated user accepted: ", status1)
+	}
+}
+
+func TestPasswordChangeRevokesSessions(t *testing.T) {
+	adminToken, _ := getToken(setting.ADMINLOGIN, setting.ADMINPWD)
+	user, _, err := createUser(adminToken.Token, HttpUser{Username: "session5", Email: "session5@orange.fr", Password: "session5pwd", Role_id: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _ := getToken("session5", "session5pwd")
+	_, status, _ := updateUser(adminToken.Token, "{\"id\":\""+user.Id+"\",\"lastname\":\"renamed\"}")
+	if status != http.StatusOK {
+		t.Fatal("update failed: ", status)
+	}
+	_, status1, _ := getUser(token.Token, user.Id)
+	if status1 != http.StatusOK {
+		t.Error("session revoked without password change: ", status1)
+	}
+	_, status2, _ := updateUser(adminToken.Token, "{\"id\":\""+user.Id+"\",\"password\":\"session5new\"}")
+	if status2 != http.StatusOK {
+		t.Fatal("update failed: ", status2)
+	}
+	_, status3, _ := getUser(token.Token, user.Id)
+	if status3 != http.StatusUnauthorized {
+		t.Error("access token accepted after a password change: ", status3)
+	}
+}
+
+func getSessions(tokenValue, userID string) ([]model.Session, int, error) {
+	sessions := []model.Session{}
+	request, err := common.BuildRequestWithToken("GET", httpServerTest.URL+USERURI+"/"+userID+"/session", " ", tokenValue)
+	if err != nil {
+		return sessions, 0, err
+	}
+	status, body_bytes, err2 := common.ExecuteRequest(request)
+	if err2 != nil {
+		return sessions, status, err2
+	}
+	if status != http.StatusOK {
+		return sessions, status, nil
+	}
+	err = json.Unmarshal(body_bytes, &sessions)
+	return sessions, status, err
+}
+
+func sendWithToken(method, uri, tokenValue string) (int, error) {
+	request, err := common.BuildRequestWithToken(method, httpServerTest.URL+uri, " ", tokenValue)
+	if err != nil {
+		return 0, err
+	}
+	status, _, err2 := common.ExecuteRequest(request)
+	return status, err2
+}
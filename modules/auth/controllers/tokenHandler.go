@@ -14,14 +14,19 @@ limitations under the License.
 package controllers
 
 import (
+	"database/sql"
 	"encoding/json"
-	"errors"
+	"github.com/pascallimeux/ocms2/modules/auth/model"
 	"github.com/pascallimeux/ocms2/modules/common"
 	"github.com/pascallimeux/ocms2/modules/log"
+	"net"
 	"net/http"
 	"strings"
+	"time"
 )
 
+const TOKEN_TYPE = "Bearer"
+
 // create a token for a user
 //HTTP Post - /o/auth
 func (a *AppContext) getToken(w http.ResponseWriter, r *http.Request) {
@@ -40,64 +45,198 @@ func (a *AppContext) getToken(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if authent.Username == "" {
-		common.SendError(log.Here(), w, errors.New("no username given"))
+		common.SendError(log.Here(), w, common.NewValidationError("no username given"))
 		return
 	}
 	if authent.Password == "" {
-		common.SendError(log.Here(), w, errors.New("no password given"))
+		common.SendError(log.Here(), w, common.NewValidationError("no password given"))
 		return
 	}
 
 	user, err2 := a.SqlContext.GetUserByCredentials(authent.Username, authent.Password)
 	if err2 != nil {
-		common.SendError(log.Here(), w, err2)
+		log.Trace(log.Here(), "bad credentials for ", authent.Username, ": ", err2.Error())
+		common.SendError(log.Here(), w, common.NewUnauthorizedError("bad credentials"))
 		return
 	}
 
-	if !user.Activated {
-		common.SendError(log.Here(), w, errors.New("user not activated"))
+	err3 := a.checkTokenUser(user)
+	if err3 != nil {
+		common.SendError(log.Here(), w, err3)
 		return
 	}
+	expires := time.Now().Add(a.Settings.ExpireInToken * time.Hour)
+	session, refreshValue, err4 := a.SqlContext.CreateSession(user.Id, r.UserAgent(), clientAddress(r), expires, a.Settings.ExpireInRefreshToken)
+	if err4 != nil {
+		common.SendError(log.Here(), w, err4)
+		return
+	}
+	token, err5 := a.issueToken(user, session, refreshValue)
+	if err5 != nil {
+		common.SendError(log.Here(), w, err5)
+		return
+	}
+	log.Trace(log.Here(), "create token for:", user.Username)
+	common.BuildHttp201Response(w, token)
+}
 
-	userRole, _ := a.SqlContext.GetRole(user.Role_id)
-	authorized := a.SqlContext.IsPermitted4User(userRole, user.Id, user.Username, "getToken", "")
-	if authorized {
-		log.Trace(log.Here(), "The user: ", user.Username, " is granted to get a token")
-	} else {
-		log.Trace(log.Here(), "The user: ", user.Username, " is not authorized get a token")
-		common.SendError(log.Here(), w, errors.New("User not authorized for this resource!"))
+// renew a token, the refresh token is consumed and replaced
+//HTTP Post - /o/auth/refresh
+func (a *AppContext) refreshToken(w http.ResponseWriter, r *http.Request) {
+	log.Trace(log.Here(), "refreshToken() : calling method -")
+
+	type Refresh struct {
+		Refresh_token string `json:"refresh_token"`
+	}
+
+	var refresh Refresh
+	err := json.NewDecoder(r.Body).Decode(&refresh)
+	if err != nil {
+		common.SendError(log.Here(), w, err)
 		return
 	}
-	expire_in := a.Settings.ExpireInToken
-	token, err3 := a.SqlContext.CreateToken(user, expire_in)
+	if refresh.Refresh_token == "" {
+		common.SendError(log.Here(), w, common.NewValidationError("no refresh token given"))
+		return
+	}
+
+	expires := time.Now().Add(a.Settings.ExpireInToken * time.Hour)
+	session, refreshValue, err1 := a.SqlContext.RotateRefreshToken(refresh.Refresh_token, expires, a.Settings.ExpireInRefreshToken)
+	if err1 != nil {
+		common.SendError(log.Here(), w, err1)
+		return
+	}
+	user, err2 := a.SqlContext.GetUser(session.User_id)
+	if err2 != nil {
+		if err2 == sql.ErrNoRows {
+			err2 = common.NewUnauthorizedError("Unknown user!")
+		}
+		common.SendError(log.Here(), w, err2)
+		return
+	}
+	err3 := a.checkTokenUser(user)
 	if err3 != nil {
+		a.SqlContext.RevokeSession(session.Id)
 		common.SendError(log.Here(), w, err3)
 		return
 	}
-	tokenString, _ := json.Marshal(token)
-	log.Trace(log.Here(), "create token:", string(tokenString))
+	token, err4 := a.issueToken(user, session, refreshValue)
+	if err4 != nil {
+		common.SendError(log.Here(), w, err4)
+		return
+	}
+	log.Trace(log.Here(), "refresh token for:", user.Username)
 	common.BuildHttp201Response(w, token)
 }
 
+// revoke the session of the token
+//HTTP Post - /o/auth/logout
+func (a *AppContext) logout(w http.ResponseWriter, r *http.Request) {
+	log.Trace(log.Here(), "logout() : calling method -")
+
+	claims, err := a.ClaimsFromHeader(r)
+	if err != nil {
+		common.SendError(log.Here(), w, err)
+		return
+	}
+	if claims.Session == "" {
+		common.SendError(log.Here(), w, common.NewValidationError("no session in token"))
+		return
+	}
+	err1 := a.SqlContext.RevokeSession(claims.Session)
+	if err1 != nil {
+		common.SendError(log.Here(), w, err1)
+		return
+	}
+	log.Trace(log.Here(), "logout of:", claims.Username)
+	common.BuildHttp204Response(w)
+}
+
+// Only the activated users granted with getToken obtain tokens
+func (a *AppContext) checkTokenUser(user model.User) error {
+	if !user.Activated {
+		return common.NewForbiddenError("user not activated")
+	}
+	userRole, _ := a.SqlContext.GetRole(user.Role_id)
+	authorized := a.SqlContext.IsPermitted4User(userRole, user.Id, user.Username, "getToken", "")
+	if !authorized {
+		log.Trace(log.Here(), "The user: ", user.Username, " is not authorized get a token")
+		return common.NewForbiddenError("User not authorized for this resource!")
+	}
+	log.Trace(log.Here(), "The user: ", user.Username, " is granted to get a token")
+	return nil
+}
+
+// Access token carrying the user id, role and session, checked without database lookup until it expires
+func (a *AppContext) issueToken(user model.User, session model.Session, refreshValue string) (model.Token, error) {
+	token := model.Token{Token_type: TOKEN_TYPE, Expires_in: session.Access_expires_in, Refresh_token: refreshValue, Refresh_expires_in: session.Expires_in}
+	claims := model.Claims{Subject: user.Id, Username: user.Username, Role: user.Role_id, IssuedAt: time.Now().Unix(), ExpiresAt: token.Expires_in.Unix(), Id: common.Generate_uuid(), Session: session.Id}
+	var err error
+	token.Token, err = a.Signer.Sign(claims)
+	return token, err
+}
+
+// Address of the client, without port
+func clientAddress(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
 func (a *AppContext) CheckPermissionFromToken(w http.ResponseWriter, r *http.Request, resourceName, resourceId string) error {
 	log.Trace(log.Here(), "checkPermissionFromToken() : calling method -")
-	tokenValue, err1 := extractTokenFromHeader(r)
+	err := a.IsPermittedFromToken(r, resourceName, resourceId)
+	if err != nil {
+		common.SendError(log.Here(), w, err)
+	}
+	return err
+}
+
+// Same check as CheckPermissionFromToken but the caller sends the error
+func (a *AppContext) IsPermittedFromToken(r *http.Request, resourceName, resourceId string) error {
+	log.Trace(log.Here(), "IsPermittedFromToken() : calling method -")
+	claims, err1 := a.ClaimsFromHeader(r)
+	if err1 != nil {
+		return err1
+	}
+	return a.SqlContext.IsAuthorized4Claims(claims, resourceName, resourceId)
+}
+
+// Same check as IsPermittedFromToken, scoped to an application
+func (a *AppContext) IsPermittedFromToken4Application(r *http.Request, applicationID, resourceName, resourceId string) error {
+	log.Trace(log.Here(), "IsPermittedFromToken4Application() : calling method -")
+	claims, err1 := a.ClaimsFromHeader(r)
 	if err1 != nil {
-		log.Trace(log.Here(), err1.Error())
 		return err1
 	}
-	err := a.SqlContext.IsAuthorized4Token(tokenValue, resourceName, resourceId)
+	return a.SqlContext.IsAuthorized4Application(claims, applicationID, resourceName, resourceId)
+}
+
+// Claims of the access token of the authorization header
+func (a *AppContext) ClaimsFromHeader(r *http.Request) (model.Claims, error) {
+	tokenValue, err := extractTokenFromHeader(r)
 	if err != nil {
-		common.BuildHttp401Response(w)
+		log.Trace(log.Here(), err.Error())
+		return model.Claims{}, err
 	}
-	return err
+	claims, err1 := a.Signer.Verify(tokenValue)
+	if err1 != nil {
+		return claims, err1
+	}
+	if a.SqlContext.IsRevokedSession(claims.Session) {
+		log.Trace(log.Here(), "revoked session ", claims.Session)
+		return claims, common.NewUnauthorizedError("Revoked token!")
+	}
+	return claims, nil
 }
 
 func extractTokenFromHeader(r *http.Request) (string, error) {
 	log.Trace(log.Here(), "extractTokenFromHeader() : calling method -")
 	tokenValue := r.Header.Get("authorization")
 	if tokenValue == "" {
-		return "", errors.New("no token in header")
+		return "", common.NewUnauthorizedError("no token in header")
 	}
 	space := strings.LastIndex(tokenValue, " ")
 	return tokenValue[space+1:], nil
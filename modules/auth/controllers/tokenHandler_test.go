@@ -16,9 +16,12 @@ package controllers
 import (
 	"encoding/json"
 	"net/http"
+	"strings"
 	"testing"
 
+	"github.com/pascallimeux/ocms2/modules/auth/model"
 	"github.com/pascallimeux/ocms2/modules/auth/setting"
+	"github.com/pascallimeux/ocms2/modules/common"
 )
 
 func TestCreateTokenNominal(t *testing.T) {
@@ -70,3 +73,67 @@ func TestUserTokenNominal(t *testing.T) {
 		t.Error("Non-expected status code: %v\n\tbody: %v\n", http.StatusOK, statusCode)
 	}
 }
+
+func TestRefreshTokenNominal(t *testing.T) {
+	token, err0 := getToken(setting.ADMINLOGIN, setting.ADMINPWD)
+	if err0 != nil {
+		t.Fatal(err0)
+	}
+	if token.Refresh_token == "" || token.Token_type != "Bearer" {
+		t.Fatal("no refresh token: ", token)
+	}
+	refreshed, status, err := refreshToken(token.Refresh_token)
+	if err != nil || status != http.StatusCreated {
+		t.Fatal("refresh failed: ", status, " ", err)
+	}
+	if refreshed.Refresh_token == token.Refresh_token {
+		t.Error("refresh token not rotated")
+	}
+	_, status2, _ := getListOfUsers(refreshed.Token)
+	if status2 != http.StatusOK {
+		t.Error("refreshed access token rejected: ", status2)
+	}
+
+	// The reuse of a consumed refresh token revokes its successors
+	_, status3, _ := refreshToken(token.Refresh_token)
+	if status3 != http.StatusUnauthorized {
+		t.Error("consumed refresh token accepted: ", status3)
+	}
+	_, status4, _ := refreshToken(refreshed.Refresh_token)
+	if status4 != http.StatusUnauthorized {
+		t.Error("refresh token not revoked after a reuse: ", status4)
+	}
+}
+
+func TestAccessTokenValidation(t *testing.T) {
+	token, err0 := getToken(setting.ADMINLOGIN, setting.ADMINPWD)
+	if err0 != nil {
+		t.Fatal(err0)
+	}
+	parts := strings.Split(token.Token, ".")
+	tampered := parts[0] + "." + parts[1] + "x." + parts[2]
+	for _, tokenValue := range []string{"", "bad token", tampered, token.Refresh_token} {
+		_, status, _ := getListOfUsers(tokenValue)
+		if status != http.StatusUnauthorized {
+			t.Error("invalid access token accepted: ", tokenValue, " ", status)
+		}
+	}
+	_, status, _ := refreshToken("unknown")
+	if status != http.StatusUnauthorized {
+		t.Error("unknown refresh token accepted: ", status)
+	}
+}
+
+func refreshToken(refreshValue string) (model.Token, int, error) {
+	token := model.Token{}
+	request, err1 := common.BuildRequest("POST", httpServerTest.URL+REFRESHURI, "{\"refresh_token\":\""+refreshValue+"\"}")
+	if err1 != nil {
+		return token, 0, err1
+	}
+	status, body_bytes, err2 := common.ExecuteRequest(request)
+	if err2 != nil {
+		return token, status, err2
+	}
+	err3 := json.Unmarshal(body_bytes, &token)
+	return token, status, err3
+}
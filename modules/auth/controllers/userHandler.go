@@ -15,7 +15,6 @@ package controllers
 
 import (
 	"encoding/json"
-	"errors"
 	"github.com/gorilla/mux"
 	"github.com/pascallimeux/ocms2/modules/common"
 	"github.com/pascallimeux/ocms2/modules/log"
@@ -46,15 +45,15 @@ func (a *AppContext) registerUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if httpUser.Username == "" {
-		common.SendError(log.Here(), w, errors.New("no username given"))
+		common.SendError(log.Here(), w, common.NewValidationError("no username given"))
 		return
 	}
 	if httpUser.Email == "" {
-		common.SendError(log.Here(), w, errors.New("no email given"))
+		common.SendError(log.Here(), w, common.NewValidationError("no email given"))
 		return
 	}
 	if httpUser.Password == "" {
-		common.SendError(log.Here(), w, errors.New("no password given"))
+		common.SendError(log.Here(), w, common.NewValidationError("no password given"))
 		return
 	}
 	user, err := a.SqlContext.CreateUser(httpUser.Username, httpUser.Lastname, httpUser.Firstname, httpUser.Email, httpUser.Password, httpUser.Role_id)
@@ -37,18 +37,22 @@ var logfile *os.File
 var DeployTimeout time.Duration
 var TransactionTimeout time.Duration
 var sqlContext model.SqlContext
+var appContext AppContext
 
 func setup() {
 	var err error
 
 	// Init config
-	config := setting.Settings{DataSourceName: "/tmp/auth_test.db", LogFileName: "/tmp/test.log", LogMode: "Trace", ExpireInToken: 24}
+	config := setting.Settings{DataSourceName: "/tmp/auth_test.db", LogFileName: "/tmp/test.log", LogMode: "Trace", ExpireInToken: 24, ExpireInRefreshToken: 720, TokenSecret: "test-secret"}
 
 	// Init logger
 	logfile = log.Init_log(config.LogFileName, config.LogMode)
 
 	// Init application context
-	appContext := AppContext{Settings: &config}
+	appContext = AppContext{Settings: &config}
+
+	// Start from an empty database
+	os.Remove(config.DataSourceName)
 
 	// Init sqliteDB
 	sqlContext, err = model.GetSqlContext(config.DataSourceName, true)
@@ -57,6 +61,12 @@ func setup() {
 	}
 	appContext.SqlContext = sqlContext
 
+	// Init access token signer
+	appContext.Signer, err = model.NewJwtSigner(config.TokenAlgorithm, config.TokenSecret, config.TokenPrivateKey)
+	if err != nil {
+		log.Fatal(log.Here(), err.Error())
+	}
+
 	// Init http server
 	router := appContext.CreateAUTHRoutes()
 	httpServerTest = httptest.NewServer(router)
@@ -86,6 +96,16 @@ func TestCreateUserNominal(t *testing.T) {
 	}
 }
 
+func TestCreateUserDuplicated(t *testing.T) {
+	token, _ := getToken(setting.ADMINLOGIN, setting.ADMINPWD)
+	httpUser := HttpUser{Username: "usernamedup", Email: "emaildup", Password: "passworddup", Role_id: 3}
+	createUser(token.Token, httpUser)
+	_, statusCode, _ := createUser(token.Token, httpUser)
+	if statusCode != http.StatusConflict {
+		t.Error("duplicated username: ", statusCode)
+	}
+}
+
 func TestGetUserNominal(t *testing.T) {
 	token, err0 := getToken(setting.ADMINLOGIN, setting.ADMINPWD)
 	if err0 != nil {
@@ -205,8 +225,8 @@ func TestCreateUserWithBadRole(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	if statusCode != http.StatusUnauthorized {
-		t.Error("Non-expected status code: ", http.StatusUnauthorized, " ", statusCode)
+	if statusCode != http.StatusForbidden {
+		t.Error("Non-expected status code: ", http.StatusForbidden, " ", statusCode)
 	}
 }
 
@@ -20,7 +20,8 @@ import (
 	"github.com/pascallimeux/ocms2/modules/auth/setting"
 )
 
-func Init(initDB bool, configuration *setting.Settings) (*mux.Router, controllers.AppContext, error) {
+// The background jobs run until stop is closed
+func Init(initDB bool, configuration *setting.Settings, stop <-chan struct{}) (*mux.Router, controllers.AppContext, error) {
 
 	if configuration == nil {
 		var err error
@@ -40,8 +41,23 @@ func Init(initDB bool, configuration *setting.Settings) (*mux.Router, controller
 		return router, appContext, err
 	}
 
+	// Reload the revoked sessions, some are revoked by the other instances
+	sqlContext.Revocations.Refresh = configuration.RevocationRefresh
+
+	// Init access token signer
+	signer, err1 := model.NewJwtSigner(configuration.TokenAlgorithm, configuration.TokenSecret, configuration.TokenPrivateKey)
+	if err1 != nil {
+		return router, appContext, err1
+	}
+
 	// Init application context
-	appContext = controllers.AppContext{Settings: configuration, SqlContext: sqlContext}
+	appContext = controllers.AppContext{Settings: configuration, SqlContext: sqlContext, Signer: signer}
+
+	// Init housekeeping of the database
+	if configuration.MaintenanceInterval > 0 {
+		appContext.Maintenance = controllers.NewMaintenanceScheduler(sqlContext, configuration)
+		go appContext.Maintenance.Start(stop)
+	}
 
 	// Init http server
 	router = appContext.CreateAUTHRoutes()
@@ -0,0 +1,160 @@
+/*
+Copyright Pascal Limeux. 2017 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package initialize
+
+import (
+	"errors"
+	"fmt"
+	"github.com/pascallimeux/ocms2/modules/auth/model"
+	"github.com/pascallimeux/ocms2/modules/auth/setting"
+	"strconv"
+)
+
+const MIGRATE_USAGE = "usage: migrate [schema] [status | up [version] | down <version>]"
+
+// Schema migrations of the auth tables from the command line, see MigrateSchemas
+func Migrate(args []string, configuration *setting.Settings) error {
+	return MigrateSchemas(args, configuration, model.AuthSchema)
+}
+
+// Schema migrations from the command line: status lists the migrations, up applies the pending ones
+// or those up to a version, down reverts the migrations above a version. The schemas are given in the
+// order they are applied, the first argument may name one of them. Without it, status and up act on every
+// schema, down 0 reverts them all in reverse order and other versions need the schema. The logger must be initialized.
+func MigrateSchemas(args []string, configuration *setting.Settings, schemas ...model.Schema) error {
+
+	if configuration == nil {
+		var err error
+		// Init settings
+		configuration, err = setting.GetSettings(".", "auth")
+		if err != nil {
+			return err
+		}
+	}
+
+	sqlContext, err := model.OpenSqlContext(configuration.DataSourceName)
+	if err != nil {
+		return err
+	}
+	defer sqlContext.Db.Close()
+
+	if len(args) > 0 {
+		for _, schema := range schemas {
+			if args[0] == schema.Name {
+				schemas = []model.Schema{schema}
+				args = args[1:]
+				break
+			}
+		}
+	}
+	command := "status"
+	if len(args) > 0 {
+		command = args[0]
+	}
+	switch command {
+	case "status":
+	case "up":
+		if len(args) == 1 {
+			for _, schema := range schemas {
+				err = sqlContext.MigrateUpOf(schema)
+				if err != nil {
+					return err
+				}
+			}
+			break
+		}
+		err = migrateSchema(sqlContext, schemas, args, true)
+	case "down":
+		target, err1 := migrateTarget(args)
+		if err1 != nil {
+			return err1
+		}
+		if target == 0 {
+			for i := len(schemas) - 1; i >= 0; i-- {
+				err = sqlContext.MigrateOf(schemas[i], 0)
+				if err != nil {
+					return err
+				}
+			}
+			break
+		}
+		err = migrateSchema(sqlContext, schemas, args, false)
+	default:
+		return errors.New(MIGRATE_USAGE)
+	}
+	if err != nil {
+		return err
+	}
+	for _, schema := range schemas {
+		err = printMigrations(sqlContext, schema)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Bring the single schema selected to the version of the arguments, in the given direction
+func migrateSchema(sqlContext model.SqlContext, schemas []model.Schema, args []string, up bool) error {
+	if len(schemas) != 1 {
+		return errors.New("several schemas, give the one to migrate: " + MIGRATE_USAGE)
+	}
+	schema := schemas[0]
+	target, err := migrateTarget(args)
+	if err != nil {
+		return err
+	}
+	current, err1 := sqlContext.SchemaVersionOf(schema)
+	if err1 != nil {
+		return err1
+	}
+	if up && target < current {
+		return errors.New(schema.Name + " schema version " + strconv.Itoa(current) + " is above " + args[1] + ", use down")
+	}
+	if !up && target > current {
+		return errors.New(schema.Name + " schema version " + strconv.Itoa(current) + " is below " + args[1] + ", use up")
+	}
+	return sqlContext.MigrateOf(schema, target)
+}
+
+func migrateTarget(args []string) (int, error) {
+	if len(args) != 2 {
+		return 0, errors.New(MIGRATE_USAGE)
+	}
+	target, err := strconv.Atoi(args[1])
+	if err != nil {
+		return 0, errors.New("bad schema version: " + args[1])
+	}
+	return target, nil
+}
+
+func printMigrations(sqlContext model.SqlContext, schema model.Schema) error {
+	migrations, err := sqlContext.MigrationsOf(schema)
+	if err != nil {
+		return err
+	}
+	version, err1 := sqlContext.SchemaVersionOf(schema)
+	if err1 != nil {
+		return err1
+	}
+	fmt.Println(schema.Name, "schema version:", version)
+	for _, migration := range migrations {
+		status := "pending"
+		if migration.Applied() {
+			status = "applied " + migration.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Printf("%04d %-30s %s\n", migration.Version, migration.Name, status)
+	}
+	return nil
+}
@@ -0,0 +1,271 @@
+/*
+Copyright Pascal Limeux. 2017 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+       http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package model
+
+import (
+	"errors"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/pascallimeux/ocms2/modules/common"
+	"github.com/pascallimeux/ocms2/modules/log"
+	"time"
+)
+
+func (a *SqlContext) CreateApplication(application Application) (Application, error) {
+	log.Trace(log.Here(), "CreateApplication(", application.Name, ") : calling method -")
+	sql := "insert into applications (Id, Name, CreatedAt) values (?, ?, ?)"
+	if application.Id == "" {
+		application.Id = common.Generate_uuid()
+	}
+	application.CreatedAt = time.Now()
+
+	tx, err1 := a.Db.Begin()
+	if err1 != nil {
+		return application, err1
+	}
+	result, err2 := tx.Exec(sql, application.Id, application.Name, application.CreatedAt)
+	if err2 != nil {
+		tx.Rollback()
+		return application, common.ConflictOnDuplicate(err2, "application "+application.Name+" already exists")
+	}
+	rowAffected, err3 := result.RowsAffected()
+	if err3 != nil {
+		tx.Rollback()
+		return application, err3
+	}
+	if rowAffected != 1 {
+		tx.Rollback()
+		return application, errors.New("row not created")
+	}
+	for _, datatype := range application.Datatypes {
+		_, err4 := tx.Exec("insert into application_datatypes (Application_id, Datatype) values (?, ?)", application.Id, datatype)
+		if err4 != nil {
+			tx.Rollback()
+			return application, common.ConflictOnDuplicate(err4, "datatype "+datatype+" given twice")
+		}
+	}
+	for _, dataaccess := range application.Dataaccess {
+		_, err5 := tx.Exec("insert into application_dataaccess (Application_id, Dataaccess) values (?, ?)", application.Id, dataaccess)
+		if err5 != nil {
+			tx.Rollback()
+			return application, common.ConflictOnDuplicate(err5, "dataaccess "+dataaccess+" given twice")
+		}
+	}
+	if application.Datatypes == nil {
+		application.Datatypes = make([]string, 0)
+	}
+	if application.Dataaccess == nil {
+		application.Dataaccess = make([]string, 0)
+	}
+	return application, tx.Commit()
+}
+
+func (a *SqlContext) GetApplication(id string) (Application, error) {
+	log.Trace(log.Here(), "GetApplication(", id, ") : calling method -")
+	sql := "select Id, Name, CreatedAt from applications where Id = ?"
+	var application Application
+	stmt, err := a.Db.Prepare(sql)
+	if err != nil {
+		return application, err
+	}
+	defer stmt.Close()
+	err1 := stmt.QueryRow(id).Scan(&application.Id, &application.Name, &application.CreatedAt)
+	if err1 != nil {
+		return application, err1
+	}
+	application.Datatypes, err1 = a.GetApplicationDatatypes(id)
+	if err1 != nil {
+		return application, err1
+	}
+	application.Dataaccess, err1 = a.GetApplicationDataaccess(id)
+	return application, err1
+}
+
+func (a *SqlContext) GetApplications() ([]Application, error) {
+	log.Trace(log.Here(), "GetApplications() : calling method -")
+	sql := "select Id, Name, CreatedAt from applications"
+	var result = make([]Application, 0)
+	rows, err1 := a.Db.Query(sql)
+	if err1 != nil {
+		return result, err1
+	}
+	defer rows.Close()
+	for rows.Next() {
+		application := Application{}
+		err2 := rows.Scan(&application.Id, &application.Name, &application.CreatedAt)
+		if err2 != nil {
+			return result, err2
+		}
+		result = append(result, application)
+	}
+	rows.Close()
+	for i := range result {
+		datatypes, err3 := a.GetApplicationDatatypes(result[i].Id)
+		if err3 != nil {
+			return result, err3
+		}
+		result[i].Datatypes = datatypes
+		dataaccess, err4 := a.GetApplicationDataaccess(result[i].Id)
+		if err4 != nil {
+			return result, err4
+		}
+		result[i].Dataaccess = dataaccess
+	}
+	return result, nil
+}
+
+func (a *SqlContext) GetApplicationDatatypes(applicationID string) ([]string, error) {
+	log.Trace(log.Here(), "GetApplicationDatatypes(", applicationID, ") : calling method -")
+	return a.getVocabulary("select Datatype from application_datatypes where Application_id = ? order by Datatype", applicationID)
+}
+
+func (a *SqlContext) AddApplicationDatatype(applicationID, datatype string) error {
+	log.Trace(log.Here(), "AddApplicationDatatype(", applicationID, ", ", datatype, ") : calling method -")
+	return a.execVocabulary("insert or replace into application_datatypes (Application_id, Datatype) values (?, ?)", applicationID, datatype)
+}
+
+func (a *SqlContext) DeleteApplicationDatatype(applicationID, datatype string) error {
+	log.Trace(log.Here(), "DeleteApplicationDatatype(", applicationID, ", ", datatype, ") : calling method -")
+	return a.execVocabulary("delete from application_datatypes where Application_id = ? and Datatype = ?", applicationID, datatype)
+}
+
+func (a *SqlContext) GetApplicationDataaccess(applicationID string) ([]string, error) {
+	log.Trace(log.Here(), "GetApplicationDataaccess(", applicationID, ") : calling method -")
+	return a.getVocabulary("select Dataaccess from application_dataaccess where Application_id = ? order by Dataaccess", applicationID)
+}
+
+func (a *SqlContext) AddApplicationDataaccess(applicationID, dataaccess string) error {
+	log.Trace(log.Here(), "AddApplicationDataaccess(", applicationID, ", ", dataaccess, ") : calling method -")
+	return a.execVocabulary("insert or replace into application_dataaccess (Application_id, Dataaccess) values (?, ?)", applicationID, dataaccess)
+}
+
+func (a *SqlContext) DeleteApplicationDataaccess(applicationID, dataaccess string) error {
+	log.Trace(log.Here(), "DeleteApplicationDataaccess(", applicationID, ", ", dataaccess, ") : calling method -")
+	return a.execVocabulary("delete from application_dataaccess where Application_id = ? and Dataaccess = ?", applicationID, dataaccess)
+}
+
+func (a *SqlContext) getVocabulary(sql, applicationID string) ([]string, error) {
+	var result = make([]string, 0)
+	rows, err1 := a.Db.Query(sql, applicationID)
+	if err1 != nil {
+		return result, err1
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var term string
+		err2 := rows.Scan(&term)
+		if err2 != nil {
+			return result, err2
+		}
+		result = append(result, term)
+	}
+	return result, nil
+}
+
+func (a *SqlContext) execVocabulary(sql, applicationID, term string) error {
+	stmt, err1 := a.Db.Prepare(sql)
+	if err1 != nil {
+		return err1
+	}
+	defer stmt.Close()
+	_, err2 := stmt.Exec(applicationID, term)
+	return err2
+}
+
+func (a *SqlContext) AddApplicationUser(applicationID, userID string) error {
+	log.Trace(log.Here(), "AddApplicationUser(", applicationID, ", ", userID, ") : calling method -")
+	sql := "insert or replace into application_users (Application_id, User_id) values (?, ?)"
+	stmt, err1 := a.Db.Prepare(sql)
+	if err1 != nil {
+		return err1
+	}
+	defer stmt.Close()
+	_, err2 := stmt.Exec(applicationID, userID)
+	return err2
+}
+
+// Make every user a member of the application, returns the number of users added
+func (a *SqlContext) AddAllApplicationUsers(applicationID string) (int64, error) {
+	log.Trace(log.Here(), "AddAllApplicationUsers(", applicationID, ") : calling method -")
+	sql := "insert or ignore into application_users (Application_id, User_id) select ?, Id from users"
+	result, err1 := a.Db.Exec(sql, applicationID)
+	if err1 != nil {
+		return 0, err1
+	}
+	return result.RowsAffected()
+}
+
+func (a *SqlContext) DeleteApplicationUser(applicationID, userID string) error {
+	log.Trace(log.Here(), "DeleteApplicationUser(", applicationID, ", ", userID, ") : calling method -")
+	sql := "delete from application_users where Application_id = ? and User_id = ?"
+	stmt, err1 := a.Db.Prepare(sql)
+	if err1 != nil {
+		return err1
+	}
+	defer stmt.Close()
+	_, err2 := stmt.Exec(applicationID, userID)
+	return err2
+}
+
+func (a *SqlContext) GetApplicationUsers(applicationID string) ([]string, error) {
+	log.Trace(log.Here(), "GetApplicationUsers(", applicationID, ") : calling method -")
+	sql := "select User_id from application_users where Application_id = ?"
+	var result = make([]string, 0)
+	rows, err1 := a.Db.Query(sql, applicationID)
+	if err1 != nil {
+		return result, err1
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var userID string
+		err2 := rows.Scan(&userID)
+		if err2 != nil {
+			return result, err2
+		}
+		result = append(result, userID)
+	}
+	return result, nil
+}
+
+func (a *SqlContext) IsApplicationUser(applicationID, userID string) (bool, error) {
+	log.Trace(log.Here(), "IsApplicationUser(", applicationID, ", ", userID, ") : calling method -")
+	sql := "select count(*) from application_users where Application_id = ? and User_id = ?"
+	var count int
+	err := a.Db.QueryRow(sql, applicationID, userID).Scan(&count)
+	return count > 0, err
+}
+
+func (a *SqlContext) CreateApplicationPermission(permission ApplicationPermission) (ApplicationPermission, error) {
+	log.Trace(log.Here(), "CreateApplicationPermission() : calling method -")
+	sql := "insert or replace into application_permissions (Application_id, Resource_name, Role_code, Owner_only) values (?, ?, ?, ?)"
+	stmt, err1 := a.Db.Prepare(sql)
+	if err1 != nil {
+		return permission, err1
+	}
+	defer stmt.Close()
+	_, err2 := stmt.Exec(permission.Application_id, permission.Resource_name, permission.Role_code, permission.Owner_only)
+	return permission, err2
+}
+
+func (a *SqlContext) GetApplicationPermission(applicationID, resource_name string, role_code int) (Permission, error) {
+	log.Trace(log.Here(), "GetApplicationPermission(", applicationID, " ", resource_name, ") : calling method -")
+	sql := "select Resource_name, Role_code, Owner_only from application_permissions where Application_id = ? and Resource_name = ? and Role_code = ?"
+	var permission Permission
+	stmt, err := a.Db.Prepare(sql)
+	if err != nil {
+		return permission, err
+	}
+	defer stmt.Close()
+	err1 := stmt.QueryRow(applicationID, resource_name, role_code).Scan(&permission.Resource_name, &permission.Role_code, &permission.Owner_only)
+	return permission, err1
+}
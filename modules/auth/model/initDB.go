@@ -19,112 +19,53 @@ import (
 	"github.com/pascallimeux/ocms2/modules/log"
 )
 
-func (s *SqlContext) InitBDD() {
+// Seed the missing roles, permissions and initial admin, the existing rows are kept.
+// The schema itself is created by the migrations.
+func (s *SqlContext) InitBDD() error {
 	log.Trace(log.Here(), "InitBDD() : calling method -")
-	s.DropTables()
-	s.CreateTables()
-	s.InitTables()
-}
-
-func (s *SqlContext) DropTables() {
-	log.Trace(log.Here(), "DropTables() : calling method -")
-	_, err := s.Db.Exec("DROP TABLE IF EXISTS users;")
-	if err != nil {
-		log.Fatal(log.Here(), "could not drop table:", err.Error())
-	}
-	_, err = s.Db.Exec("DROP TABLE IF EXISTS roles;")
-	if err != nil {
-		log.Fatal(log.Here(), "could not drop table:", err.Error())
-	}
-	_, err = s.Db.Exec("DROP TABLE IF EXISTS tokens;")
-	if err != nil {
-		log.Fatal(log.Here(), "could not drop table:", err.Error())
-	}
-	_, err = s.Db.Exec("DROP TABLE IF EXISTS permissions;")
-	if err != nil {
-		log.Fatal(log.Here(), "could not drop table:", err.Error())
-	}
-	_, err = s.Db.Exec("DROP TABLE IF EXISTS logs;")
-	if err != nil {
-		log.Fatal(log.Here(), "could not drop table:", err.Error())
-	}
-}
-
-func (s *SqlContext) CreateTables() {
-	log.Trace(log.Here(), "CreateTables() : calling method -")
-	_, err := s.Db.Exec("create table if not exists users (Id varchar(255), Username varchar(50) not null UNIQUE, Firstname varchar(50), Lastname varchar(50), Email varchar(100), Password byte, CreatedAt datetime, UpdatedAt datetime, Activated boolean, Role_id integer, FOREIGN KEY(Role_id) REFERENCES roles(code), primary key (Id))")
-	if err != nil {
-		log.Fatal(log.Here(), err.Error())
-	}
-	_, err = s.Db.Exec("create table if not exists roles (Code integer, Label varchar(50), primary key (Code))")
-	if err != nil {
-		log.Fatal(log.Here(), err.Error())
-	}
-	_, err = s.Db.Exec("create table if not exists tokens (Token varchar(50), Expires_in datetime, User_id varchar(255), FOREIGN KEY(User_id) REFERENCES users(Id), primary key (Token))")
-	if err != nil {
-		log.Fatal(log.Here(), err.Error())
-	}
-	_, err = s.Db.Exec("create table if not exists permissions (Resource_name varchar(255), Role_code integer, Owner_only boolean, FOREIGN KEY(Role_code) REFERENCES roles(Code), primary key (Resource_name, Role_code))")
-	if err != nil {
-		log.Fatal(log.Here(), err.Error())
-	}
-	_, err = s.Db.Exec("create table if not exists logs (Timestamp datetime, Resource_name varchar(255), Resource_param varchar(255), User_id varchar(255), Username varchar(255), Access_granted boolean, FOREIGN KEY (User_id) REFERENCES users(Id) )")
-	if err != nil {
-		log.Fatal(log.Here(), err.Error())
-	}
-}
-
-func (s *SqlContext) InitTables() {
-	log.Trace(log.Here(), "InitTables() : calling method -")
-	err := s.CreateInitialAdmin()
-	if err != nil {
-		log.Fatal(log.Here(), err.Error())
-	}
-	err = s.CreateInitialRoles()
+	err := s.CreateInitialRoles()
 	if err != nil {
-		log.Fatal(log.Here(), err.Error())
+		return err
 	}
 	err = s.CreateInitialPermissions()
 	if err != nil {
-		log.Fatal(log.Here(), err.Error())
+		return err
 	}
+	return s.CreateInitialAdmin()
 }
 
 func (s *SqlContext) CreateInitialAdmin() error {
 	log.Trace(log.Here(), "CreateInitialAdmin() : calling method -")
-	_, err := s.CreateUser(setting.ADMINLOGIN, "", "", setting.ADMINEMAIL, setting.ADMINPWD, 1)
+	var count int
+	err := s.Db.QueryRow("select count(*) from users where Username = ?", setting.ADMINLOGIN).Scan(&count)
 	if err != nil {
-		log.Error(log.Here(), "initial admin already exist!!")
 		return err
 	}
+	if count > 0 {
+		log.Info(log.Here(), "initial admin already exist")
+		return nil
+	}
+	_, err1 := s.CreateUser(setting.ADMINLOGIN, "", "", setting.ADMINEMAIL, setting.ADMINPWD, 1)
+	if err1 != nil {
+		log.Error(log.Here(), err1.Error())
+		return err1
+	}
 	return nil
 }
 
 func (s *SqlContext) CreateInitialRoles() error {
 	log.Trace(log.Here(), "CreateInitialRoles() : calling method -")
-	none := Role{Code: 0, Label: "None"}
-	admin := Role{Code: 1, Label: "Administrator"}
-	superUser := Role{Code: 2, Label: "Superuser"}
-	user := Role{Code: 3, Label: "user"}
-	_, err := s.CreateRole(none)
-	if err != nil {
-		log.Error(log.Here(), err.Error())
-		return err
-	}
-	_, err = s.CreateRole(admin)
-	if err != nil {
-		log.Error(log.Here(), err.Error())
-		return err
-	}
-	_, err = s.CreateRole(superUser)
-	if err != nil {
-		log.Error(log.Here(), err.Error())
-		return err
-	}
-	_, err = s.CreateRole(user)
-	if err != nil {
-		log.Error(log.Here(), err.Error())
-		return err
+	var roles []Role
+	roles = append(roles, Role{Code: 0, Label: "None"})
+	roles = append(roles, Role{Code: 1, Label: "Administrator"})
+	roles = append(roles, Role{Code: 2, Label: "Superuser"})
+	roles = append(roles, Role{Code: 3, Label: "user"})
+	for _, role := range roles {
+		_, err := s.SeedRole(role)
+		if err != nil {
+			log.Error(log.Here(), err.Error())
+			return err
+		}
 	}
 	return nil
 }
@@ -160,10 +101,28 @@ func (s *SqlContext) CreateInitialPermissions() error {
 
 	perms = append(perms, Permission{Resource_name: "deleteUser", Role_code: 1, Owner_only: false})
 
+	perms = append(perms, Permission{Resource_name: "getSessions", Role_code: 1, Owner_only: false})
+	perms = append(perms, Permission{Resource_name: "getSessions", Role_code: 2, Owner_only: true})
+	perms = append(perms, Permission{Resource_name: "getSessions", Role_code: 3, Owner_only: true})
+
+	perms = append(perms, Permission{Resource_name: "revokeSessions", Role_code: 1, Owner_only: false})
+	perms = append(perms, Permission{Resource_name: "revokeSessions", Role_code: 2, Owner_only: true})
+	perms = append(perms, Permission{Resource_name: "revokeSessions", Role_code: 3, Owner_only: true})
+
 	perms = append(perms, Permission{Resource_name: "getLogs", Role_code: 1, Owner_only: false})
+	perms = append(perms, Permission{Resource_name: "getMaintenance", Role_code: 1, Owner_only: false})
+
+	perms = append(perms, Permission{Resource_name: "postApplication", Role_code: 1, Owner_only: false})
+	perms = append(perms, Permission{Resource_name: "getApplication", Role_code: 1, Owner_only: false})
+	perms = append(perms, Permission{Resource_name: "getApplications", Role_code: 1, Owner_only: false})
+	perms = append(perms, Permission{Resource_name: "postApplicationUser", Role_code: 1, Owner_only: false})
+	perms = append(perms, Permission{Resource_name: "deleteApplicationUser", Role_code: 1, Owner_only: false})
+	perms = append(perms, Permission{Resource_name: "postApplicationPermission", Role_code: 1, Owner_only: false})
+	perms = append(perms, Permission{Resource_name: "postApplicationVocabulary", Role_code: 1, Owner_only: false})
+	perms = append(perms, Permission{Resource_name: "deleteApplicationVocabulary", Role_code: 1, Owner_only: false})
 
 	for _, perm := range perms {
-		_, err := s.CreatePermission(perm)
+		_, err := s.SeedPermission(perm)
 		if err != nil {
 			log.Error(log.Here(), err.Error())
 			return err
@@ -0,0 +1,238 @@
+/*
+Copyright Pascal Limeux. 2017 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package model
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"github.com/pascallimeux/ocms2/modules/common"
+	"github.com/pascallimeux/ocms2/modules/log"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const (
+	JWT_HS256 = "HS256"
+	JWT_RS256 = "RS256"
+	JWT_ES256 = "ES256"
+	JWT_TYPE  = "JWT"
+)
+
+// Claims of an access token
+type Claims struct {
+	Subject   string `json:"sub"` // user id
+	Username  string `json:"name"`
+	Role      int    `json:"role"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+	Id        string `json:"jti"`
+	Session   string `json:"sid"`
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+}
+
+// Sign and check the access tokens: HMAC-SHA256 with a shared secret, RSA or ECDSA P-256 with a private key
+type Jwt_Signer struct {
+	Algorithm  string
+	secret     []byte
+	privateKey crypto.Signer
+}
+
+// An HS256 signer without secret draws a random one, its tokens do not survive a restart
+func NewJwtSigner(algorithm, secret, privateKeyFile string) (*Jwt_Signer, error) {
+	log.Trace(log.Here(), "NewJwtSigner(", algorithm, ") : calling method -")
+	if algorithm == "" {
+		algorithm = JWT_HS256
+	}
+	signer := &Jwt_Signer{Algorithm: algorithm}
+	switch algorithm {
+	case JWT_HS256:
+		signer.secret = []byte(secret)
+		if secret == "" {
+			log.Warning(log.Here(), "no token secret configured, the access tokens are signed with a random secret")
+			signer.secret = make([]byte, 32)
+			_, err := rand.Read(signer.secret)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return signer, nil
+	case JWT_RS256, JWT_ES256:
+		key, err := readPrivateKey(privateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		switch k := key.(type) {
+		case *rsa.PrivateKey:
+			if algorithm != JWT_RS256 {
+				return nil, errors.New("RSA key given for " + algorithm)
+			}
+		case *ecdsa.PrivateKey:
+			if algorithm != JWT_ES256 || k.Curve != elliptic.P256() {
+				return nil, errors.New("ECDSA P-256 key expected for " + algorithm)
+			}
+		default:
+			return nil, errors.New("unsupported private key for " + algorithm)
+		}
+		signer.privateKey = key
+		return signer, nil
+	}
+	return nil, errors.New("unsupported token algorithm: " + algorithm)
+}
+
+// PEM private key, PKCS#8, PKCS#1 (RSA) or SEC 1 (EC)
+func readPrivateKey(privateKeyFile string) (crypto.Signer, error) {
+	if privateKeyFile == "" {
+		return nil, errors.New("no private key file given to sign the tokens")
+	}
+	data, err := ioutil.ReadFile(privateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM key in " + privateKeyFile)
+	}
+	if key, err1 := x509.ParsePKCS8PrivateKey(block.Bytes); err1 == nil {
+		if signer, ok := key.(crypto.Signer); ok {
+			return signer, nil
+		}
+		return nil, errors.New("unsupported private key in " + privateKeyFile)
+	}
+	if key, err2 := x509.ParsePKCS1PrivateKey(block.Bytes); err2 == nil {
+		return key, nil
+	}
+	if key, err3 := x509.ParseECPrivateKey(block.Bytes); err3 == nil {
+		return key, nil
+	}
+	return nil, errors.New("could not parse the private key in " + privateKeyFile)
+}
+
+func (s *Jwt_Signer) Sign(claims Claims) (string, error) {
+	log.Trace(log.Here(), "Sign() : calling method -")
+	header, err := json.Marshal(jwtHeader{Alg: s.Algorithm, Typ: JWT_TYPE})
+	if err != nil {
+		return "", err
+	}
+	payload, err1 := json.Marshal(claims)
+	if err1 != nil {
+		return "", err1
+	}
+	signingInput := encodeSegment(header) + "." + encodeSegment(payload)
+	signature, err2 := s.signature(signingInput)
+	if err2 != nil {
+		return "", err2
+	}
+	return signingInput + "." + encodeSegment(signature), nil
+}
+
+// Check the signature and the expiry of an access token, a token signed with another algorithm is rejected
+func (s *Jwt_Signer) Verify(tokenValue string) (Claims, error) {
+	log.Trace(log.Here(), "Verify() : calling method -")
+	var claims Claims
+	invalid := common.NewUnauthorizedError("Invalid token!")
+	parts := strings.Split(tokenValue, ".")
+	if len(parts) != 3 {
+		return claims, invalid
+	}
+	var header jwtHeader
+	err := decodeSegment(parts[0], &header)
+	if err != nil || header.Alg != s.Algorithm {
+		log.Trace(log.Here(), "bad token header")
+		return claims, invalid
+	}
+	signature, err1 := base64.RawURLEncoding.DecodeString(parts[2])
+	if err1 != nil || !s.verifySignature(parts[0]+"."+parts[1], signature) {
+		log.Trace(log.Here(), "bad token signature")
+		return claims, invalid
+	}
+	err2 := decodeSegment(parts[1], &claims)
+	if err2 != nil || claims.Subject == "" {
+		log.Trace(log.Here(), "bad token claims")
+		return claims, invalid
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		log.Trace(log.Here(), "expired token")
+		return claims, common.NewUnauthorizedError("Expired token!")
+	}
+	return claims, nil
+}
+
+func (s *Jwt_Signer) signature(signingInput string) ([]byte, error) {
+	if s.Algorithm == JWT_HS256 {
+		mac := hmac.New(sha256.New, s.secret)
+		mac.Write([]byte(signingInput))
+		return mac.Sum(nil), nil
+	}
+	digest := sha256.Sum256([]byte(signingInput))
+	if key, ok := s.privateKey.(*ecdsa.PrivateKey); ok {
+		r, sig, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			return nil, err
+		}
+		// JWS form of the signature: r and s on 32 bytes each
+		signature := make([]byte, 64)
+		r.FillBytes(signature[:32])
+		sig.FillBytes(signature[32:])
+		return signature, nil
+	}
+	return s.privateKey.Sign(rand.Reader, digest[:], crypto.SHA256)
+}
+
+func (s *Jwt_Signer) verifySignature(signingInput string, signature []byte) bool {
+	if s.Algorithm == JWT_HS256 {
+		expected, _ := s.signature(signingInput)
+		return hmac.Equal(signature, expected)
+	}
+	digest := sha256.Sum256([]byte(signingInput))
+	switch key := s.privateKey.Public().(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil
+	case *ecdsa.PublicKey:
+		if len(signature) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		sig := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(key, digest[:], r, sig)
+	}
+	return false
+}
+
+func encodeSegment(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
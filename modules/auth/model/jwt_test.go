@@ -0,0 +1,101 @@
+/*
+Copyright Pascal Limeux. 2017 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package model
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJwtSignerNominal(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	signers := map[string]string{JWT_HS256: "", JWT_RS256: writeKey(t, rsaKey), JWT_ES256: writeKey(t, ecKey)}
+	for algorithm, keyFile := range signers {
+		signer, err := NewJwtSigner(algorithm, "secret", keyFile)
+		if err != nil {
+			t.Fatal(algorithm, ": ", err)
+		}
+		claims := Claims{Subject: "user1", Username: "username1", Role: 2, IssuedAt: time.Now().Unix(), ExpiresAt: time.Now().Add(time.Hour).Unix(), Id: "1"}
+		token, err1 := signer.Sign(claims)
+		if err1 != nil {
+			t.Fatal(algorithm, ": ", err1)
+		}
+		verified, err2 := signer.Verify(token)
+		if err2 != nil || verified != claims {
+			t.Error(algorithm, ": bad claims ", verified, " ", err2)
+		}
+		parts := strings.Split(token, ".")
+		_, err3 := signer.Verify(parts[0] + "." + encodeSegment([]byte(`{"sub":"admin","role":1,"exp":9999999999}`)) + "." + parts[2])
+		if err3 == nil {
+			t.Error(algorithm, ": tampered token accepted")
+		}
+		claims.ExpiresAt = time.Now().Add(-time.Second).Unix()
+		expired, _ := signer.Sign(claims)
+		_, err4 := signer.Verify(expired)
+		if err4 == nil {
+			t.Error(algorithm, ": expired token accepted")
+		}
+	}
+}
+
+func TestJwtSignerAlgorithm(t *testing.T) {
+	signer, _ := NewJwtSigner(JWT_HS256, "secret", "")
+	other, _ := NewJwtSigner(JWT_HS256, "other", "")
+	claims := Claims{Subject: "user1", ExpiresAt: time.Now().Add(time.Hour).Unix()}
+	token, _ := other.Sign(claims)
+	_, err := signer.Verify(token)
+	if err == nil {
+		t.Error("token signed with another secret accepted")
+	}
+	payload := strings.Split(token, ".")[1]
+	unsigned := encodeSegment([]byte(`{"alg":"none","typ":"JWT"}`)) + "." + payload + "."
+	_, err1 := signer.Verify(unsigned)
+	if err1 == nil {
+		t.Error("unsigned token accepted")
+	}
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	_, err2 := NewJwtSigner(JWT_ES256, "", writeKey(t, rsaKey))
+	if err2 == nil {
+		t.Error("RSA key accepted for ES256")
+	}
+	_, err3 := NewJwtSigner("none", "", "")
+	if err3 == nil {
+		t.Error("none algorithm accepted")
+	}
+}
+
+func writeKey(t *testing.T, key interface{}) string {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err1 := ioutil.TempFile("", "token_key")
+	if err1 != nil {
+		t.Fatal(err1)
+	}
+	defer file.Close()
+	pem.Encode(file, &pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	t.Cleanup(func() { os.Remove(file.Name()) })
+	return file.Name()
+}
@@ -14,9 +14,12 @@ limitations under the License.
 package model
 
 import (
+	"database/sql"
+	"encoding/json"
 	"errors"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/pascallimeux/ocms2/modules/log"
+	"io"
 	"time"
 )
 
@@ -90,3 +93,96 @@ func (a *SqlContext) GetLog4Period(date1, date2 time.Time) ([]Logg, error) {
 	}
 	return result, nil
 }
+
+// Archive of the purged logs, it is written and closed before the delete and published once the delete committed
+type LogArchive interface {
+	io.WriteCloser
+	Publish() error
+}
+
+// Delete the logs older than before and the oldest ones beyond maxRows (0 keeps every row).
+// With an archive, the logs are written to it as NDJSON in the transaction of the delete, the archive
+// is published after the commit so a failed delete leaves no archive of logs still in the table.
+func (a *SqlContext) PurgeLogs(before time.Time, maxRows int, archive LogArchive) (int64, error) {
+	log.Trace(log.Here(), "PurgeLogs() : calling method -")
+	var limit int64
+	if maxRows > 0 {
+		err1 := a.Db.QueryRow("select rowid from logs order by rowid desc limit 1 offset ?", maxRows).Scan(&limit)
+		if err1 != nil && err1 != sql.ErrNoRows {
+			return 0, err1
+		}
+	}
+	tx, err := a.Db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	condition := "(Timestamp < ? or rowid <= ?)"
+	args := []interface{}{before, limit}
+	if archive != nil {
+		last, err2 := archiveLogs(tx, condition, before, limit, archive)
+		if err2 != nil {
+			tx.Rollback()
+			return 0, err2
+		}
+		condition += " and rowid <= ?"
+		args = append(args, last)
+	}
+	result, err3 := tx.Exec("delete from logs where "+condition, args...)
+	if err3 != nil {
+		tx.Rollback()
+		return 0, err3
+	}
+	purged, err4 := result.RowsAffected()
+	if err4 != nil {
+		tx.Rollback()
+		return 0, err4
+	}
+	err5 := tx.Commit()
+	if err5 != nil {
+		return 0, err5
+	}
+	if archive != nil && purged > 0 {
+		return purged, archive.Publish()
+	}
+	return purged, nil
+}
+
+// Rank of the last archived log, the archive is closed
+func archiveLogs(tx *sql.Tx, condition string, before time.Time, limit int64, archive io.WriteCloser) (int64, error) {
+	rows, err1 := tx.Query("select rowid, Timestamp, Resource_name, Resource_param, User_id, Username, Access_granted from logs where "+condition+" order by rowid", before, limit)
+	if err1 != nil {
+		archive.Close()
+		return 0, err1
+	}
+	defer rows.Close()
+	var last int64
+	encoder := json.NewEncoder(archive)
+	for rows.Next() {
+		logs := Logg{}
+		err2 := rows.Scan(&last, &logs.Timestamp, &logs.Resource_name, &logs.Resource_param, &logs.User_id, &logs.Username, &logs.Access_granted)
+		if err2 == nil {
+			err2 = encoder.Encode(logs)
+		}
+		if err2 != nil {
+			archive.Close()
+			return 0, err2
+		}
+	}
+	err3 := rows.Err()
+	if err3 != nil {
+		archive.Close()
+		return 0, err3
+	}
+	return last, archive.Close()
+}
+
+// Rebuild the database file and refresh the statistics of the query planner
+func (a *SqlContext) Optimize() error {
+	log.Trace(log.Here(), "Optimize() : calling method -")
+	_, err := a.Db.Exec("VACUUM")
+	if err != nil {
+		return err
+	}
+	_, err = a.Db.Exec("ANALYZE")
+	return err
+}
@@ -0,0 +1,278 @@
+/*
+Copyright Pascal Limeux. 2017 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package model
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"github.com/pascallimeux/ocms2/modules/log"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	MIGRATIONS_DIR     = "migrations"
+	MIGRATION_UP_EXT   = ".up.sql"
+	MIGRATION_DOWN_EXT = ".down.sql"
+	AUTH_SCHEMA        = "auth"
+)
+
+// Schema migrations of the auth database, NNNN_name.up.sql and NNNN_name.down.sql, built into the binary
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Data moved by a migration once its up script has run, in the same transaction
+var migrationHooks = map[int]func(tx *sql.Tx) error{
+	2: func(tx *sql.Tx) error {
+		migrated, err := migrateLegacyTokens(tx)
+		if migrated > 0 {
+			log.Info(log.Here(), strconv.Itoa(migrated), " former tokens migrated to sessions")
+		}
+		return err
+	},
+}
+
+// Migrations of a set of tables sharing the database, each schema records its versions in its own table
+type Schema struct {
+	Name  string
+	Table string                         // applied migrations
+	Files fs.FS                          // NNNN_name.up.sql and NNNN_name.down.sql in MIGRATIONS_DIR
+	Hooks map[int]func(tx *sql.Tx) error // run after the up script of their version
+}
+
+// Tables of the auth module
+var AuthSchema = Schema{Name: AUTH_SCHEMA, Table: "schema_version", Files: migrationFiles, Hooks: migrationHooks}
+
+type Migration struct {
+	Version   int       `json:"version"`
+	Name      string    `json:"name"`
+	AppliedAt time.Time `json:"applied_at"` // zero while the migration is pending
+	up        string
+	down      string
+}
+
+func (m Migration) Applied() bool {
+	return !m.AppliedAt.IsZero()
+}
+
+// Migrations of the binary in version order
+func loadMigrations(schema Schema) ([]Migration, error) {
+	entries, err := fs.ReadDir(schema.Files, MIGRATIONS_DIR)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		fileName := entry.Name()
+		var up bool
+		var base string
+		switch {
+		case strings.HasSuffix(fileName, MIGRATION_UP_EXT):
+			up, base = true, strings.TrimSuffix(fileName, MIGRATION_UP_EXT)
+		case strings.HasSuffix(fileName, MIGRATION_DOWN_EXT):
+			base = strings.TrimSuffix(fileName, MIGRATION_DOWN_EXT)
+		default:
+			return nil, errors.New("bad migration file name: " + fileName)
+		}
+		parts := strings.SplitN(base, "_", 2)
+		version, err1 := strconv.Atoi(parts[0])
+		if err1 != nil || version <= 0 || len(parts) != 2 {
+			return nil, errors.New("bad migration file name: " + fileName)
+		}
+		script, err2 := fs.ReadFile(schema.Files, MIGRATIONS_DIR+"/"+fileName)
+		if err2 != nil {
+			return nil, err2
+		}
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: parts[1]}
+			byVersion[version] = migration
+		}
+		if migration.Name != parts[1] {
+			return nil, errors.New("two names for migration " + strconv.Itoa(version))
+		}
+		if up {
+			migration.up = string(script)
+		} else {
+			migration.down = string(script)
+		}
+	}
+	var migrations []Migration
+	for _, migration := range byVersion {
+		if migration.up == "" || migration.down == "" {
+			return nil, errors.New("migration " + strconv.Itoa(migration.Version) + " needs an up and a down script")
+		}
+		migrations = append(migrations, *migration)
+	}
+	if len(migrations) == 0 {
+		return nil, errors.New("no migrations for the " + schema.Name + " schema")
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+func (s *SqlContext) createSchemaVersion(schema Schema) error {
+	_, err := s.Db.Exec("create table if not exists " + schema.Table + " (Version integer, Name varchar(255), AppliedAt datetime, primary key (Version))")
+	return err
+}
+
+// Version of the last migration of the auth schema applied, 0 on an empty database
+func (s *SqlContext) SchemaVersion() (int, error) {
+	return s.SchemaVersionOf(AuthSchema)
+}
+
+func (s *SqlContext) SchemaVersionOf(schema Schema) (int, error) {
+	log.Trace(log.Here(), "SchemaVersionOf(", schema.Name, ") : calling method -")
+	err := s.createSchemaVersion(schema)
+	if err != nil {
+		return 0, err
+	}
+	var version int
+	err1 := s.Db.QueryRow("select coalesce(max(Version), 0) from " + schema.Table).Scan(&version)
+	return version, err1
+}
+
+// Migrations of the auth schema in the binary, with the date they were applied to the database
+func (s *SqlContext) Migrations() ([]Migration, error) {
+	return s.MigrationsOf(AuthSchema)
+}
+
+func (s *SqlContext) MigrationsOf(schema Schema) ([]Migration, error) {
+	log.Trace(log.Here(), "MigrationsOf(", schema.Name, ") : calling method -")
+	migrations, err := loadMigrations(schema)
+	if err != nil {
+		return nil, err
+	}
+	err1 := s.createSchemaVersion(schema)
+	if err1 != nil {
+		return nil, err1
+	}
+	applied := make(map[int]time.Time)
+	rows, err2 := s.Db.Query("select Version, AppliedAt from " + schema.Table)
+	if err2 != nil {
+		return nil, err2
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		err3 := rows.Scan(&version, &appliedAt)
+		if err3 != nil {
+			return nil, err3
+		}
+		applied[version] = appliedAt
+	}
+	for i := range migrations {
+		migrations[i].AppliedAt = applied[migrations[i].Version]
+	}
+	return migrations, rows.Err()
+}
+
+// Apply the pending migrations of the auth schema, run at startup
+func (s *SqlContext) MigrateUp() error {
+	return s.MigrateUpOf(AuthSchema)
+}
+
+func (s *SqlContext) MigrateUpOf(schema Schema) error {
+	log.Trace(log.Here(), "MigrateUpOf(", schema.Name, ") : calling method -")
+	migrations, err := loadMigrations(schema)
+	if err != nil {
+		return err
+	}
+	return s.MigrateOf(schema, migrations[len(migrations)-1].Version)
+}
+
+// Bring the auth schema to a version, up or down
+func (s *SqlContext) Migrate(target int) error {
+	return s.MigrateOf(AuthSchema, target)
+}
+
+// Bring a schema to a version, up or down. Each migration runs in its own transaction,
+// a failed one is rolled back and leaves the database at the previous version.
+func (s *SqlContext) MigrateOf(schema Schema, target int) error {
+	log.Trace(log.Here(), "MigrateOf(", schema.Name, ", ", strconv.Itoa(target), ") : calling method -")
+	migrations, err := loadMigrations(schema)
+	if err != nil {
+		return err
+	}
+	current, err1 := s.SchemaVersionOf(schema)
+	if err1 != nil {
+		return err1
+	}
+	latest := migrations[len(migrations)-1].Version
+	if current > latest {
+		return errors.New("database " + schema.Name + " schema version " + strconv.Itoa(current) + " is newer than this binary (" + strconv.Itoa(latest) + ")")
+	}
+	if target < 0 || target > latest {
+		return errors.New("unknown schema version " + strconv.Itoa(target))
+	}
+	for _, migration := range migrations {
+		if migration.Version > current && migration.Version <= target {
+			err2 := s.applyMigration(schema, migration, true)
+			if err2 != nil {
+				return err2
+			}
+		}
+	}
+	for i := len(migrations) - 1; i >= 0; i-- {
+		if migrations[i].Version <= current && migrations[i].Version > target {
+			err3 := s.applyMigration(schema, migrations[i], false)
+			if err3 != nil {
+				return err3
+			}
+		}
+	}
+	return nil
+}
+
+func (s *SqlContext) applyMigration(schema Schema, migration Migration, up bool) error {
+	name := schema.Name + " " + strconv.Itoa(migration.Version) + "_" + migration.Name
+	tx, err := s.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if up {
+		_, err = tx.Exec(migration.up)
+		if err == nil && schema.Hooks[migration.Version] != nil {
+			err = schema.Hooks[migration.Version](tx)
+		}
+		if err == nil {
+			_, err = tx.Exec("insert into "+schema.Table+" (Version, Name, AppliedAt) values (?, ?, ?)", migration.Version, migration.Name, time.Now())
+		}
+	} else {
+		_, err = tx.Exec(migration.down)
+		if err == nil {
+			_, err = tx.Exec("delete from "+schema.Table+" where Version = ?", migration.Version)
+		}
+	}
+	if err != nil {
+		return errors.New("migration " + name + " failed: " + err.Error())
+	}
+	err1 := tx.Commit()
+	if err1 != nil {
+		return err1
+	}
+	if up {
+		log.Info(log.Here(), "schema migration ", name, " applied")
+	} else {
+		log.Info(log.Here(), "schema migration ", name, " reverted")
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+/*
+Copyright Pascal Limeux. 2017 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package model
+
+import (
+	"os"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+const MIGRATE_TEST_DB = "/tmp/auth_migrate_test.db"
+
+// A database of its own, emptied, so the migrations start from scratch
+func openMigrateTestDB(t *testing.T) SqlContext {
+	os.Remove(MIGRATE_TEST_DB)
+	migrateContext, err := OpenSqlContext(MIGRATE_TEST_DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	migrateContext.Revocations = NewRevocationList()
+	return migrateContext
+}
+
+func countTables(t *testing.T, s SqlContext, names ...string) int {
+	var total int
+	for _, name := range names {
+		var count int
+		err := s.Db.QueryRow("select count(*) from sqlite_master where type = 'table' and name = ?", name).Scan(&count)
+		if err != nil {
+			t.Fatal(err)
+		}
+		total += count
+	}
+	return total
+}
+
+func TestMigrateNominal(t *testing.T) {
+	migrateContext := openMigrateTestDB(t)
+	defer migrateContext.Db.Close()
+	migrations, err := loadMigrations(AuthSchema)
+	if err != nil || len(migrations) == 0 {
+		t.Fatal("no migrations: ", err)
+	}
+	latest := migrations[len(migrations)-1].Version
+
+	err1 := migrateContext.MigrateUp()
+	if err1 != nil {
+		t.Fatal(err1)
+	}
+	version, _ := migrateContext.SchemaVersion()
+	if version != latest {
+		t.Error("bad schema version ", version, " expected ", latest)
+	}
+	migrations, _ = migrateContext.Migrations()
+	for _, migration := range migrations {
+		if !migration.Applied() {
+			t.Error("migration ", migration.Version, " not applied")
+		}
+	}
+	if countTables(t, migrateContext, "users", "roles", "sessions", "refresh_tokens") != 4 || countTables(t, migrateContext, "tokens") != 0 {
+		t.Error("bad schema after migrate up")
+	}
+	err2 := migrateContext.MigrateUp()
+	if err2 != nil {
+		t.Error("migrate up not idempotent: ", err2)
+	}
+
+	err3 := migrateContext.Migrate(1)
+	if err3 != nil {
+		t.Fatal(err3)
+	}
+	version, _ = migrateContext.SchemaVersion()
+	if version != 1 || countTables(t, migrateContext, "sessions", "refresh_tokens") != 0 || countTables(t, migrateContext, "tokens") != 1 {
+		t.Error("bad schema after migrate down to 1: ", version)
+	}
+	err4 := migrateContext.Migrate(0)
+	if err4 != nil {
+		t.Fatal(err4)
+	}
+	version, _ = migrateContext.SchemaVersion()
+	if version != 0 || countTables(t, migrateContext, "users", "roles", "permissions", "logs", "applications") != 0 {
+		t.Error("bad schema after migrate down to 0: ", version)
+	}
+	err5 := migrateContext.MigrateUp()
+	if err5 != nil {
+		t.Error("migrate up after down: ", err5)
+	}
+}
+
+func TestMigrateUnknownVersion(t *testing.T) {
+	migrateContext := openMigrateTestDB(t)
+	defer migrateContext.Db.Close()
+	err := migrateContext.Migrate(9999)
+	if err == nil {
+		t.Error("unknown version migrated")
+	}
+	version, _ := migrateContext.SchemaVersion()
+	if version != 0 {
+		t.Error("schema migrated to ", version)
+	}
+	migrateContext.Db.Exec("insert into schema_version (Version, Name, AppliedAt) values (9999, 'future', ?)", time.Now())
+	err1 := migrateContext.MigrateUp()
+	if err1 == nil {
+		t.Error("schema newer than the binary migrated")
+	}
+}
+
+// Schema sharing the database, versioned in a table of its own
+var otherSchema = Schema{Name: "other", Table: "other_schema_version", Files: fstest.MapFS{
+	MIGRATIONS_DIR + "/0001_things.up.sql":   {Data: []byte("create table if not exists things (Id varchar(255), primary key (Id));")},
+	MIGRATIONS_DIR + "/0001_things.down.sql": {Data: []byte("drop table if exists things;")},
+}}
+
+func TestMigrateOtherSchema(t *testing.T) {
+	migrateContext := openMigrateTestDB(t)
+	defer migrateContext.Db.Close()
+	migrateContext.MigrateUp()
+	err := migrateContext.MigrateUpOf(otherSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	version, _ := migrateContext.SchemaVersionOf(otherSchema)
+	authVersion, _ := migrateContext.SchemaVersion()
+	if version != 1 || authVersion <= 1 || countTables(t, migrateContext, "things") != 1 {
+		t.Error("bad versions after migrate up: ", version, authVersion)
+	}
+	err1 := migrateContext.MigrateOf(otherSchema, 0)
+	if err1 != nil {
+		t.Fatal(err1)
+	}
+	version, _ = migrateContext.SchemaVersionOf(otherSchema)
+	authVersion, _ = migrateContext.SchemaVersion()
+	if version != 0 || authVersion <= 1 || countTables(t, migrateContext, "things") != 0 || countTables(t, migrateContext, "users") != 1 {
+		t.Error("bad versions after migrate down to 0: ", version, authVersion)
+	}
+}
+
+func TestMigrateLegacyTokensNominal(t *testing.T) {
+	migrateContext := openMigrateTestDB(t)
+	defer migrateContext.Db.Close()
+	err := migrateContext.Migrate(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err1 := migrateContext.CreateUser("usernamedb_legacy", "", "", "legacy@orange.fr", "passwordlegacy", 3)
+	if err1 != nil {
+		t.Fatal(err1)
+	}
+	valid := "0123456789abcde0123456789abcde0123456789"
+	expired := "abcde0123456789abcde0123456789abcde01234"
+	migrateContext.Db.Exec("insert into tokens (Token, Expires_in, User_id) values (?, ?, ?)", valid, time.Now().Add(time.Hour), user.Id)
+	migrateContext.Db.Exec("insert into tokens (Token, Expires_in, User_id) values (?, ?, ?)", expired, time.Now().Add(-time.Hour), user.Id)
+
+	err2 := migrateContext.MigrateUp()
+	if err2 != nil {
+		t.Fatal(err2)
+	}
+	if countTables(t, migrateContext, "tokens") != 0 {
+		t.Error("former tokens table kept")
+	}
+	var count int
+	migrateContext.Db.QueryRow("select count(*) from refresh_tokens where Token_hash = ? or Token_hash = ?", valid, expired).Scan(&count)
+	if count != 0 {
+		t.Error("token stored verbatim")
+	}
+	sessions, _ := migrateContext.GetActiveSessions(user.Id)
+	if len(sessions) != 1 || sessions[0].Client != LEGACY_CLIENT {
+		t.Fatal("bad migrated sessions: ", sessions)
+	}
+	session, _, err3 := migrateContext.RotateRefreshToken(valid, time.Now().Add(time.Hour), 1)
+	if err3 != nil || session.User_id != user.Id {
+		t.Error("migrated token not exchanged: ", err3)
+	}
+	_, _, err4 := migrateContext.RotateRefreshToken(expired, time.Now().Add(time.Hour), 1)
+	if err4 == nil {
+		t.Error("expired former token migrated")
+	}
+}
+
+func TestInitBDDKeepsData(t *testing.T) {
+	user, err := sqlContext.CreateUser("usernamedb_init", "", "", "init@orange.fr", "passwordinit", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err1 := sqlContext.CreatePermission(Permission{Resource_name: "getUser", Role_code: 3, Owner_only: false})
+	if err1 != nil {
+		t.Fatal(err1)
+	}
+	sqlContext.Db.Exec("delete from permissions where Resource_name = 'getLogs' and Role_code = 1")
+
+	err2 := sqlContext.InitBDD()
+	if err2 != nil {
+		t.Fatal(err2)
+	}
+	_, err3 := sqlContext.GetUser(user.Id)
+	if err3 != nil {
+		t.Error("user lost by init: ", err3)
+	}
+	permission, _ := sqlContext.GetPermission("getUser", 3)
+	if permission.Owner_only {
+		t.Error("changed permission overwritten by init")
+	}
+	_, err4 := sqlContext.GetPermission("getLogs", 1)
+	if err4 != nil {
+		t.Error("missing permission not seeded: ", err4)
+	}
+	sqlContext.CreatePermission(Permission{Resource_name: "getUser", Role_code: 3, Owner_only: true})
+}
@@ -0,0 +1,10 @@
+drop table if exists application_permissions;
+drop table if exists application_dataaccess;
+drop table if exists application_datatypes;
+drop table if exists application_users;
+drop table if exists applications;
+drop table if exists logs;
+drop table if exists permissions;
+drop table if exists tokens;
+drop table if exists users;
+drop table if exists roles;
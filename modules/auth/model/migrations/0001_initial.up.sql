@@ -0,0 +1,10 @@
+create table if not exists roles (Code integer, Label varchar(50), primary key (Code));
+create table if not exists users (Id varchar(255), Username varchar(50) not null UNIQUE, Firstname varchar(50), Lastname varchar(50), Email varchar(100), Password byte, CreatedAt datetime, UpdatedAt datetime, Activated boolean, Role_id integer, FOREIGN KEY(Role_id) REFERENCES roles(code), primary key (Id));
+create table if not exists tokens (Token varchar(50), Expires_in datetime, User_id varchar(255), FOREIGN KEY(User_id) REFERENCES users(Id), primary key (Token));
+create table if not exists permissions (Resource_name varchar(255), Role_code integer, Owner_only boolean, FOREIGN KEY(Role_code) REFERENCES roles(Code), primary key (Resource_name, Role_code));
+create table if not exists logs (Timestamp datetime, Resource_name varchar(255), Resource_param varchar(255), User_id varchar(255), Username varchar(255), Access_granted boolean, FOREIGN KEY (User_id) REFERENCES users(Id) );
+create table if not exists applications (Id varchar(255), Name varchar(255) not null UNIQUE, CreatedAt datetime, primary key (Id));
+create table if not exists application_users (Application_id varchar(255), User_id varchar(255), FOREIGN KEY(Application_id) REFERENCES applications(Id), FOREIGN KEY(User_id) REFERENCES users(Id), primary key (Application_id, User_id));
+create table if not exists application_datatypes (Application_id varchar(255), Datatype varchar(255), FOREIGN KEY(Application_id) REFERENCES applications(Id), primary key (Application_id, Datatype));
+create table if not exists application_dataaccess (Application_id varchar(255), Dataaccess varchar(255), FOREIGN KEY(Application_id) REFERENCES applications(Id), primary key (Application_id, Dataaccess));
+create table if not exists application_permissions (Application_id varchar(255), Resource_name varchar(255), Role_code integer, Owner_only boolean, FOREIGN KEY(Application_id) REFERENCES applications(Id), FOREIGN KEY(Role_code) REFERENCES roles(Code), primary key (Application_id, Resource_name, Role_code));
@@ -0,0 +1,4 @@
+-- the sessions are lost, their users log in again
+drop table if exists refresh_tokens;
+drop table if exists sessions;
+create table if not exists tokens (Token varchar(50), Expires_in datetime, User_id varchar(255), FOREIGN KEY(User_id) REFERENCES users(Id), primary key (Token));
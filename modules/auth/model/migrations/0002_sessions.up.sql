@@ -0,0 +1,5 @@
+-- the former tokens are moved to the sessions and the tokens table dropped once this script has run
+create table if not exists sessions (Id varchar(255), User_id varchar(255), Client varchar(255), Address varchar(255), CreatedAt datetime, LastUsedAt datetime, Expires_in datetime, Access_expires_in datetime, Revoked boolean, FOREIGN KEY(User_id) REFERENCES users(Id), primary key (Id));
+create index if not exists sessions_user on sessions (User_id);
+create table if not exists refresh_tokens (Token_hash varchar(64), Session_id varchar(255), User_id varchar(255), CreatedAt datetime, Expires_in datetime, Revoked boolean, FOREIGN KEY(Session_id) REFERENCES sessions(Id), FOREIGN KEY(User_id) REFERENCES users(Id), primary key (Token_hash));
+create index if not exists refresh_tokens_session on refresh_tokens (Session_id);
@@ -0,0 +1 @@
+drop index if exists logs_timestamp;
@@ -0,0 +1 @@
+create index if not exists logs_timestamp on logs (Timestamp);
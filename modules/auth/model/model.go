@@ -17,9 +17,16 @@ import (
 	"database/sql"
 	"errors"
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/pascallimeux/ocms2/modules/log"
 	"time"
 )
 
+// Role codes of the administrators and of the superusers
+const (
+	ADMINROLE     = 1
+	SUPERUSERROLE = 2
+)
+
 type User struct {
 	Id        string    `json:"id"`
 	Username  string    `json:"username"`
@@ -33,10 +40,35 @@ type User struct {
 	Role_id   int       `json:"role_id"` //foreign key with role table
 }
 
+// Signed access token and the refresh token to renew it
 type Token struct {
-	Token      string    `json:"token"`
+	Token              string    `json:"token"`
+	Token_type         string    `json:"token_type"`
+	Expires_in         time.Time `json:"expire_in"`
+	Refresh_token      string    `json:"refresh_token"`
+	Refresh_expires_in time.Time `json:"refresh_expire_in"`
+}
+
+type RefreshToken struct {
+	Token_hash string    `json:"token_hash"`
+	Session_id string    `json:"session_id"` // foreign key with session table
+	User_id    string    `json:"user_id"`    // foreign key with user table
+	CreatedAt  time.Time `json:"created_at"`
 	Expires_in time.Time `json:"expire_in"`
-	User_id    string    `json:"user_id"` // foreign key with user table
+	Revoked    bool      `json:"revoked"`
+}
+
+// A login of a user, renewed by its refresh tokens until it expires or is revoked
+type Session struct {
+	Id                string    `json:"id"`
+	User_id           string    `json:"user_id"` // foreign key with user table
+	Client            string    `json:"client"`
+	Address           string    `json:"address"`
+	CreatedAt         time.Time `json:"created_at"`
+	LastUsedAt        time.Time `json:"last_used_at"`
+	Expires_in        time.Time `json:"expire_in"`
+	Access_expires_in time.Time `json:"-"` // end of validity of the last access token of the session
+	Revoked           bool      `json:"revoked"`
 }
 
 type Role struct {
@@ -50,6 +82,21 @@ type Permission struct {
 	Owner_only    bool   `json:"owner_only"`
 }
 
+type Application struct {
+	Id         string    `json:"id"`
+	Name       string    `json:"name"`
+	CreatedAt  time.Time `json:"created_at"`
+	Datatypes  []string  `json:"datatypes"`
+	Dataaccess []string  `json:"dataaccess"`
+}
+
+type ApplicationPermission struct {
+	Application_id string `json:"application_id"` // foreign key with application table
+	Resource_name  string `json:"resource_name"`
+	Role_code      int    `json:"role_code"` // foreign key with role table
+	Owner_only     bool   `json:"owner_only"`
+}
+
 type Logg struct {
 	Timestamp      time.Time `json:"timestamp"`
 	Resource_name  string    `json:"resource_name"`
@@ -59,25 +106,58 @@ type Logg struct {
 	Access_granted bool      `json:"access_granted"`
 }
 
+// Result of the last housekeeping run
+type MaintenanceStatus struct {
+	StartedAt      time.Time `json:"started_at"`
+	FinishedAt     time.Time `json:"finished_at"`
+	SessionsPurged int64     `json:"sessions_purged"`
+	TokensPurged   int64     `json:"tokens_purged"`
+	LogsPurged     int64     `json:"logs_purged"`
+	Archive        string    `json:"archive,omitempty"`
+	Optimized      bool      `json:"optimized"`
+	Error          string    `json:"error,omitempty"`
+	NextRun        time.Time `json:"next_run"`
+}
+
 type SqlContext struct {
 	dataSourceName string
 	Db             *sql.DB
+	Revocations    *Revocation_List
 }
 
+// Open the database, apply its pending migrations and, with initDB, seed the missing roles, permissions and admin
 func GetSqlContext(dataSourceName string, initDB bool) (SqlContext, error) {
-	var err error
-	sqlContext := SqlContext{}
-	sqlContext.dataSourceName = dataSourceName
-	sqlContext.Db, err = GetSqlDB(dataSourceName)
+	sqlContext, err := OpenSqlContext(dataSourceName)
+	if err != nil {
+		return sqlContext, err
+	}
+	err = sqlContext.MigrateUp()
 	if err != nil {
 		return sqlContext, err
 	}
 	if initDB {
-		sqlContext.InitBDD()
+		err = sqlContext.InitBDD()
+		if err != nil {
+			return sqlContext, err
+		}
+	}
+	sqlContext.Revocations = NewRevocationList()
+	err = sqlContext.LoadRevocations()
+	if err != nil {
+		log.Warning(log.Here(), "could not load the revoked sessions: ", err.Error())
 	}
 	return sqlContext, nil
 }
 
+// Open the database as it is, without migrating it
+func OpenSqlContext(dataSourceName string) (SqlContext, error) {
+	var err error
+	sqlContext := SqlContext{}
+	sqlContext.dataSourceName = dataSourceName
+	sqlContext.Db, err = GetSqlDB(dataSourceName)
+	return sqlContext, err
+}
+
 func GetSqlDB(dataSourceName string) (*sql.DB, error) {
 	db, err := sql.Open("sqlite3", dataSourceName)
 	if err != nil {
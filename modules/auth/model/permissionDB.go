@@ -14,8 +14,10 @@ limitations under the License.
 package model
 
 import (
+	"database/sql"
 	"errors"
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/pascallimeux/ocms2/modules/common"
 	"github.com/pascallimeux/ocms2/modules/log"
 	"strconv"
 	"time"
@@ -45,6 +47,20 @@ func (a *SqlContext) CreatePermission(permission Permission) (Permission, error)
 	return permission, nil
 }
 
+// Create the permission if the role has none on the resource, an existing one is left as it is
+func (a *SqlContext) SeedPermission(permission Permission) (bool, error) {
+	log.Trace(log.Here(), "SeedPermission(", permission.Resource_name, ") : calling method -")
+	result, err1 := a.Db.Exec("insert or ignore into permissions (Resource_name, Role_code, Owner_only) values (?, ?, ?)", permission.Resource_name, permission.Role_code, permission.Owner_only)
+	if err1 != nil {
+		return false, err1
+	}
+	rowAffected, err2 := result.RowsAffected()
+	if err2 != nil {
+		return false, err2
+	}
+	return rowAffected == 1, nil
+}
+
 func (a *SqlContext) GetPermission(resource_name string, role_code int) (Permission, error) {
 	log.Trace(log.Here(), "GetPermission(", strconv.Itoa(role_code), "  ", resource_name, ") : calling method -")
 	sql := "select * from permissions where resource_name = ? and role_code = ?"
@@ -96,56 +112,90 @@ func (a *SqlContext) DeletePermission(resourceName string, roleCode int) error {
 	return nil
 }
 
-func (a *SqlContext) IsAuthorized4Token(tokenValue, resourceName, resourceId string) error {
-	log.Trace(log.Here(), "IsAuthorized4Token() : calling method -")
+// The user and the role come from the claims of a verified access token, without database lookup
+func (a *SqlContext) IsAuthorized4Claims(claims Claims, resourceName, resourceId string) error {
+	log.Trace(log.Here(), "IsAuthorized4Claims() : calling method -")
 
-	token, err2 := a.GetToken(tokenValue)
-	if err2 != nil {
-		log.Trace(log.Here(), "get token (", tokenValue, ") ", err2.Error())
-		return err2
+	authorized := a.IsPermitted4User(Role{Code: claims.Role}, claims.Subject, claims.Username, resourceName, resourceId)
+	if authorized {
+		log.Trace(log.Here(), "The user: ", claims.Username, " is granted to access to the resource: ", resourceName)
+		return nil
+	} else {
+		log.Trace(log.Here(), "The user: ", claims.Username, " is not authorized to access to the resource: ", resourceName)
+		return common.NewForbiddenError("User not authorized for this resource!")
 	}
-	if token.IsValid() == false {
-		log.Trace(log.Here(), "invalide token ")
-		return errors.New("Invalid token!")
+}
+
+// Same check as IsAuthorized4Claims but the decision is not recorded in the logs,
+// for a coarse check followed by the logged check of the operation itself
+func (a *SqlContext) IsAllowed4Claims(claims Claims, resourceName, resourceId string) error {
+	log.Trace(log.Here(), "IsAllowed4Claims() : calling method -")
+	permission, err := a.GetPermission(resourceName, claims.Role)
+	if err != nil {
+		log.Error(log.Here(), err.Error())
 	}
-	user, err3 := a.GetUser(token.User_id)
-	if err3 != nil {
-		log.Trace(log.Here(), "get user ", err3.Error())
-		return err3
+	if !permitted(permission, err, claims.Subject, resourceId) {
+		log.Trace(log.Here(), "The user: ", claims.Username, " is not authorized to access to the resource: ", resourceName)
+		return common.NewForbiddenError("User not authorized for this resource!")
 	}
-	if user.Activated == false {
-		log.Trace(log.Here(), "user not activated ")
-		return errors.New("Unactivated user!")
+	return nil
+}
+
+// Administrators act on every application, the other users only on the applications they belong to
+func (a *SqlContext) IsAuthorized4Application(claims Claims, applicationID, resourceName, resourceId string) error {
+	log.Trace(log.Here(), "IsAuthorized4Application() : calling method -")
+
+	_, err1 := a.GetApplication(applicationID)
+	if err1 != nil {
+		if err1 == sql.ErrNoRows {
+			return common.NewNotFoundError("Unknown application " + applicationID + "!")
+		}
+		return err1
 	}
-	userRole, _ := a.GetRole(user.Role_id)
-	authorized := a.IsPermitted4User(userRole, user.Id, user.Username, resourceName, resourceId)
+	if claims.Role != ADMINROLE {
+		member, err2 := a.IsApplicationUser(applicationID, claims.Subject)
+		if err2 != nil {
+			return err2
+		}
+		if !member {
+			log.Trace(log.Here(), "The user: ", claims.Username, " does not belong to the application: ", applicationID)
+			return common.NewForbiddenError("User not member of this application!")
+		}
+	}
+	authorized := a.IsPermitted4Application(applicationID, Role{Code: claims.Role}, claims.Subject, claims.Username, resourceName, resourceId)
 	if authorized {
-		log.Trace(log.Here(), "The user: ", user.Username, " is granted to access to the resource: ", resourceName)
+		log.Trace(log.Here(), "The user: ", claims.Username, " is granted to access to the resource: ", resourceName, " of application: ", applicationID)
 		return nil
 	} else {
-		log.Trace(log.Here(), "The user: ", user.Username, " is not authorized to access to the resource: ", resourceName)
-		return errors.New("User not authorized for this resource!")
+		log.Trace(log.Here(), "The user: ", claims.Username, " is not authorized to access to the resource: ", resourceName, " of application: ", applicationID)
+		return common.NewForbiddenError("User not authorized for this resource!")
 	}
 }
 
 func (a *SqlContext) IsPermitted4User(userRole Role, userId, username, resourceName, resourceParam string) bool {
 	log.Trace(log.Here(), "IsPermitted4User() : calling method -")
 	permission, err := a.GetPermission(resourceName, userRole.Code)
+	return a.grant(permission, err, userId, username, resourceName, resourceParam)
+}
+
+// An application permission overrides the global permission of the same resource and role
+func (a *SqlContext) IsPermitted4Application(applicationID string, userRole Role, userId, username, resourceName, resourceParam string) bool {
+	log.Trace(log.Here(), "IsPermitted4Application() : calling method -")
+	permission, err := a.GetApplicationPermission(applicationID, resourceName, userRole.Code)
+	if err == sql.ErrNoRows {
+		permission, err = a.GetPermission(resourceName, userRole.Code)
+	}
+	return a.grant(permission, err, userId, username, resourceName, resourceParam)
+}
+
+func (a *SqlContext) grant(permission Permission, err error, userId, username, resourceName, resourceParam string) bool {
 	if resourceParam == "" {
 		resourceParam = "None"
 	}
-	var granted = false
 	if err != nil {
 		log.Error(log.Here(), err.Error())
-	} else {
-		if !permission.Owner_only {
-			granted = true
-		} else {
-			if resourceParam == userId {
-				granted = true
-			}
-		}
 	}
+	granted := permitted(permission, err, userId, resourceParam)
 	logs := Logg{Timestamp: time.Now(), Resource_name: resourceName, Resource_param: resourceParam, User_id: userId, Username: username, Access_granted: granted}
 	_, err = a.CreateLog(logs)
 	if err != nil {
@@ -153,3 +203,10 @@ func (a *SqlContext) IsPermitted4User(userRole Role, userId, username, resourceN
 	}
 	return granted
 }
+
+func permitted(permission Permission, err error, userId, resourceParam string) bool {
+	if err != nil {
+		return false
+	}
+	return !permission.Owner_only || resourceParam == userId
+}
@@ -44,6 +44,20 @@ func (a *SqlContext) CreateRole(role Role) (Role, error) {
 	return role, nil
 }
 
+// Create the role if its code is free, an existing role is left as it is
+func (a *SqlContext) SeedRole(role Role) (bool, error) {
+	log.Trace(log.Here(), "SeedRole(", role.Label, ") : calling method -")
+	result, err1 := a.Db.Exec("insert or ignore into roles (Code, Label) values (?, ?)", role.Code, role.Label)
+	if err1 != nil {
+		return false, err1
+	}
+	rowAffected, err2 := result.RowsAffected()
+	if err2 != nil {
+		return false, err2
+	}
+	return rowAffected == 1, nil
+}
+
 func (a *SqlContext) GetRole(code int) (Role, error) {
 	log.Trace(log.Here(), "GetRole(", strconv.Itoa(code), ") : calling method -")
 	sql := "select * from roles where code = ?"
@@ -0,0 +1,257 @@
+/*
+Copyright Pascal Limeux. 2017 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package model
+
+import (
+	"database/sql"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/pascallimeux/ocms2/modules/log"
+	"sync"
+	"time"
+)
+
+const sessionColumns = "Id, User_id, Client, Address, CreatedAt, LastUsedAt, Expires_in, Access_expires_in, Revoked"
+
+// Sessions revoked while some of their access tokens are still valid.
+// The access tokens are checked against this list, without database lookup, and the list is reloaded
+// from the database once older than Refresh so the sessions revoked by the other instances are seen.
+type Revocation_List struct {
+	Refresh  time.Duration // 0 never reloads
+	mutex    sync.RWMutex
+	sessions map[string]time.Time // end of validity of the last access token of the session
+	loadedAt time.Time
+	loading  bool
+}
+
+func NewRevocationList() *Revocation_List {
+	return &Revocation_List{sessions: make(map[string]time.Time)}
+}
+
+func (l *Revocation_List) Revoke(sessionID string, until time.Time) {
+	if l == nil || !until.After(time.Now()) {
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := time.Now()
+	for id, end := range l.sessions {
+		if !end.After(now) {
+			delete(l.sessions, id)
+		}
+	}
+	l.sessions[sessionID] = until
+}
+
+// True when the list must be reloaded by the caller, a single caller reloads it at a time
+func (l *Revocation_List) startReload() bool {
+	if l == nil || l.Refresh <= 0 {
+		return false
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.loading || time.Since(l.loadedAt) < l.Refresh {
+		return false
+	}
+	l.loading = true
+	return true
+}
+
+func (l *Revocation_List) endReload(loaded bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.loading = false
+	if loaded {
+		l.loadedAt = time.Now()
+	}
+}
+
+func (l *Revocation_List) IsRevoked(sessionID string) bool {
+	if l == nil {
+		return false
+	}
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	_, revoked := l.sessions[sessionID]
+	return revoked
+}
+
+// Fill the revocation list with the revoked sessions of which access tokens are still valid
+func (a *SqlContext) LoadRevocations() error {
+	log.Trace(log.Here(), "LoadRevocations() : calling method -")
+	rows, err1 := a.Db.Query("select Id, Access_expires_in from sessions where Revoked = 1 and Access_expires_in > ?", time.Now())
+	if err1 != nil {
+		return err1
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		var until time.Time
+		err2 := rows.Scan(&id, &until)
+		if err2 != nil {
+			return err2
+		}
+		a.Revocations.Revoke(id, until)
+	}
+	return rows.Err()
+}
+
+func (a *SqlContext) IsRevokedSession(sessionID string) bool {
+	if a.Revocations.startReload() {
+		err := a.LoadRevocations()
+		if err != nil {
+			log.Error(log.Here(), "could not reload the revoked sessions: ", err.Error())
+		}
+		a.Revocations.endReload(err == nil)
+	}
+	return a.Revocations.IsRevoked(sessionID)
+}
+
+func (a *SqlContext) GetSession(id string) (Session, error) {
+	log.Trace(log.Here(), "GetSession() : calling method -")
+	return getSession(a.Db, id)
+}
+
+// Sessions of a user neither revoked nor expired
+func (a *SqlContext) GetActiveSessions(userID string) ([]Session, error) {
+	log.Trace(log.Here(), "GetActiveSessions() : calling method -")
+	sql := "select " + sessionColumns + " from sessions where User_id = ? and Revoked = 0 and Expires_in > ? order by CreatedAt"
+	var result = make([]Session, 0)
+	rows, err1 := a.Db.Query(sql, userID, time.Now())
+	if err1 != nil {
+		return result, err1
+	}
+	defer rows.Close()
+	for rows.Next() {
+		session := Session{}
+		err2 := rows.Scan(&session.Id, &session.User_id, &session.Client, &session.Address, &session.CreatedAt, &session.LastUsedAt, &session.Expires_in, &session.Access_expires_in, &session.Revoked)
+		if err2 != nil {
+			return result, err2
+		}
+		result = append(result, session)
+	}
+	return result, nil
+}
+
+// Revoke a session: its refresh tokens are revoked and its access tokens rejected until they expire
+func (a *SqlContext) RevokeSession(id string) error {
+	log.Trace(log.Here(), "RevokeSession(", id, ") : calling method -")
+	tx, err1 := a.Db.Begin()
+	if err1 != nil {
+		return err1
+	}
+	defer tx.Rollback()
+	session, err2 := revokeSession(tx, id)
+	if err2 != nil {
+		return err2
+	}
+	err3 := tx.Commit()
+	if err3 != nil {
+		return err3
+	}
+	a.Revocations.Revoke(session.Id, session.Access_expires_in)
+	return nil
+}
+
+// Revoke every session of a user
+func (a *SqlContext) RevokeSessions(userID string) error {
+	log.Trace(log.Here(), "RevokeSessions(", userID, ") : calling method -")
+	tx, err1 := a.Db.Begin()
+	if err1 != nil {
+		return err1
+	}
+	defer tx.Rollback()
+	rows, err2 := tx.Query("select Id from sessions where User_id = ? and Revoked = 0", userID)
+	if err2 != nil {
+		return err2
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		err3 := rows.Scan(&id)
+		if err3 != nil {
+			rows.Close()
+			return err3
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	var sessions []Session
+	for _, id := range ids {
+		session, err4 := revokeSession(tx, id)
+		if err4 != nil {
+			return err4
+		}
+		sessions = append(sessions, session)
+	}
+	err5 := tx.Commit()
+	if err5 != nil {
+		return err5
+	}
+	for _, session := range sessions {
+		a.Revocations.Revoke(session.Id, session.Access_expires_in)
+	}
+	return nil
+}
+
+func getSession(db querier, id string) (Session, error) {
+	var session Session
+	err := db.QueryRow("select "+sessionColumns+" from sessions where Id = ?", id).Scan(&session.Id, &session.User_id, &session.Client, &session.Address, &session.CreatedAt, &session.LastUsedAt, &session.Expires_in, &session.Access_expires_in, &session.Revoked)
+	return session, err
+}
+
+func revokeSession(tx *sql.Tx, id string) (Session, error) {
+	session, err1 := getSession(tx, id)
+	if err1 != nil {
+		return session, err1
+	}
+	_, err2 := tx.Exec("update sessions set Revoked = 1 where Id = ?", id)
+	if err2 != nil {
+		return session, err2
+	}
+	_, err3 := tx.Exec("update refresh_tokens set Revoked = 1 where Session_id = ?", id)
+	if err3 != nil {
+		return session, err3
+	}
+	session.Revoked = true
+	return session, nil
+}
+
+// Delete the expired refresh tokens and the sessions of which no token is valid anymore
+func (a *SqlContext) PurgeExpiredSessions() (int64, int64, error) {
+	log.Trace(log.Here(), "PurgeExpiredSessions() : calling method -")
+	now := time.Now()
+	ended := "(Expires_in < ? or Revoked = 1) and Access_expires_in < ?"
+	tx, err1 := a.Db.Begin()
+	if err1 != nil {
+		return 0, 0, err1
+	}
+	defer tx.Rollback()
+	result, err2 := tx.Exec("delete from refresh_tokens where Expires_in < ? or Session_id in (select Id from sessions where "+ended+")", now, now, now)
+	if err2 != nil {
+		return 0, 0, err2
+	}
+	tokens, err3 := result.RowsAffected()
+	if err3 != nil {
+		return 0, 0, err3
+	}
+	result, err4 := tx.Exec("delete from sessions where "+ended, now, now)
+	if err4 != nil {
+		return 0, 0, err4
+	}
+	sessions, err5 := result.RowsAffected()
+	if err5 != nil {
+		return 0, 0, err5
+	}
+	return sessions, tokens, tx.Commit()
+}
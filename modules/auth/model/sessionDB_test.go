@@ -0,0 +1,108 @@
+/*
+Copyright Pascal Limeux. 2017 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRotateRefreshTokenNominal(t *testing.T) {
+	user, err := sqlContext.CreateUser("usernamedb_refresh", "", "", "refresh@orange.fr", "passwordrefresh", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	access := time.Now().Add(time.Hour)
+	session, first, err1 := sqlContext.CreateSession(user.Id, "test", "127.0.0.1", access, 1)
+	if err1 != nil {
+		t.Fatal(err1)
+	}
+	rotated, second, err2 := sqlContext.RotateRefreshToken(first, access, 1)
+	if err2 != nil || rotated.Id != session.Id || rotated.User_id != user.Id || second == first || !rotated.Expires_in.After(time.Now()) {
+		t.Fatal("bad rotation: ", rotated, " ", err2)
+	}
+	_, _, err3 := sqlContext.RotateRefreshToken(first, access, 1)
+	if err3 == nil {
+		t.Error("refresh token used twice")
+	}
+	_, _, err4 := sqlContext.RotateRefreshToken(second, access, 1)
+	if err4 == nil {
+		t.Error("refresh token still valid after the reuse of its predecessor")
+	}
+	if !sqlContext.IsRevokedSession(session.Id) {
+		t.Error("session not revoked after a reuse")
+	}
+	_, expired, _ := sqlContext.CreateSession(user.Id, "test", "127.0.0.1", access, -1)
+	_, _, err5 := sqlContext.RotateRefreshToken(expired, access, 1)
+	if err5 == nil {
+		t.Error("expired refresh token accepted")
+	}
+}
+
+func TestRevokeSessionsNominal(t *testing.T) {
+	user, err := sqlContext.CreateUser("usernamedb_sessions", "", "", "sessions@orange.fr", "passwordsessions", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	access := time.Now().Add(time.Hour)
+	session1, _, _ := sqlContext.CreateSession(user.Id, "client1", "127.0.0.1", access, 1)
+	session2, refresh2, _ := sqlContext.CreateSession(user.Id, "client2", "127.0.0.1", access, 1)
+	sessions, err1 := sqlContext.GetActiveSessions(user.Id)
+	if err1 != nil || len(sessions) != 2 || sessions[0].Client != "client1" {
+		t.Fatal("bad active sessions: ", sessions, " ", err1)
+	}
+	_, err2 := sqlContext.PutUser(user.Id, "", "", "", "newpassword")
+	if err2 != nil {
+		t.Fatal(err2)
+	}
+	if !sqlContext.IsRevokedSession(session1.Id) || !sqlContext.IsRevokedSession(session2.Id) {
+		t.Error("sessions not revoked after a password change")
+	}
+	_, _, err3 := sqlContext.RotateRefreshToken(refresh2, access, 1)
+	if err3 == nil {
+		t.Error("refresh token of a revoked session accepted")
+	}
+	sessions, _ = sqlContext.GetActiveSessions(user.Id)
+	if len(sessions) != 0 {
+		t.Error("revoked sessions still active: ", sessions)
+	}
+
+	// The revocations survive a restart
+	reloaded := SqlContext{Db: sqlContext.Db, Revocations: NewRevocationList()}
+	err4 := reloaded.LoadRevocations()
+	if err4 != nil || !reloaded.IsRevokedSession(session1.Id) {
+		t.Error("revocations not reloaded: ", err4)
+	}
+}
+
+func TestRevocationsOfOtherInstances(t *testing.T) {
+	user, err := sqlContext.CreateUser("usernamedb_instances", "", "", "instances@orange.fr", "passwordinstances", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, _, _ := sqlContext.CreateSession(user.Id, "client", "127.0.0.1", time.Now().Add(time.Hour), 1)
+	other := SqlContext{Db: sqlContext.Db, Revocations: NewRevocationList()}
+	other.Revocations.Refresh = 50 * time.Millisecond
+	if other.IsRevokedSession(session.Id) {
+		t.Fatal("active session revoked")
+	}
+	err1 := sqlContext.RevokeSession(session.Id)
+	if err1 != nil {
+		t.Fatal(err1)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if !other.IsRevokedSession(session.Id) {
+		t.Error("session revoked by another instance not seen after the refresh")
+	}
+}
@@ -14,6 +14,9 @@ limitations under the License.
 package model
 
 import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
 	"errors"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/pascallimeux/ocms2/modules/common"
@@ -21,53 +24,189 @@ import (
 	"time"
 )
 
-func (a *SqlContext) CreateToken(user User, expire_in time.Duration) (interface{}, error) {
-	log.Trace(log.Here(), "CreateToken() : calling method -")
-	sql := "insert into tokens (Token, Expires_in, User_id) values (?, ?, ?)"
-	type Token struct {
-		Token      string
-		Expires_in time.Time
-	}
-	var token Token
-	token.Token = common.Generate_Token()
-	token.Expires_in = time.Now().Add(expire_in * time.Hour)
-	stmt, err1 := a.Db.Prepare(sql)
+// Client of the sessions migrated from the former opaque tokens
+const LEGACY_CLIENT = "legacy token"
+
+// Statements shared by a database and a transaction
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// Open a session for a user with its first refresh token, only the hash of the token is stored
+func (a *SqlContext) CreateSession(userID, client, address string, accessExpires time.Time, expire_in time.Duration) (Session, string, error) {
+	log.Trace(log.Here(), "CreateSession() : calling method -")
+	now := time.Now()
+	session := Session{Id: common.Generate_uuid(), User_id: userID, Client: client, Address: address, CreatedAt: now, LastUsedAt: now, Expires_in: now.Add(expire_in * time.Hour), Access_expires_in: accessExpires}
+	tx, err1 := a.Db.Begin()
 	if err1 != nil {
-		return token, err1
+		return session, "", err1
 	}
-	defer stmt.Close()
-	result, err2 := stmt.Exec(token.Token, token.Expires_in, user.Id)
+	defer tx.Rollback()
+	_, err2 := tx.Exec("insert into sessions (Id, User_id, Client, Address, CreatedAt, LastUsedAt, Expires_in, Access_expires_in, Revoked) values (?, ?, ?, ?, ?, ?, ?, ?, 0)", session.Id, session.User_id, session.Client, session.Address, session.CreatedAt, session.LastUsedAt, session.Expires_in, session.Access_expires_in)
 	if err2 != nil {
-		return token, err2
+		return session, "", err2
 	}
-	rowAffected, err3 := result.RowsAffected()
+	tokenValue, err3 := insertRefreshToken(tx, session)
 	if err3 != nil {
-		return token, err3
+		return session, "", err3
+	}
+	return session, tokenValue, tx.Commit()
+}
+
+// Exchange a refresh token for a new one of the same session, the presented token is revoked.
+// A revoked token presented again was stolen or replayed: its whole session is revoked.
+func (a *SqlContext) RotateRefreshToken(tokenValue string, accessExpires time.Time, expire_in time.Duration) (Session, string, error) {
+	log.Trace(log.Here(), "RotateRefreshToken() : calling method -")
+	var session Session
+	invalid := common.NewUnauthorizedError("Invalid refresh token!")
+	tx, err1 := a.Db.Begin()
+	if err1 != nil {
+		return session, "", err1
+	}
+	defer tx.Rollback()
+
+	hash := HashToken(tokenValue)
+	var token RefreshToken
+	err2 := tx.QueryRow("select Token_hash, Session_id, User_id, CreatedAt, Expires_in, Revoked from refresh_tokens where Token_hash = ?", hash).Scan(&token.Token_hash, &token.Session_id, &token.User_id, &token.CreatedAt, &token.Expires_in, &token.Revoked)
+	if err2 != nil {
+		if err2 == sql.ErrNoRows {
+			return session, "", invalid
+		}
+		return session, "", err2
+	}
+	if token.Revoked {
+		log.Warning(log.Here(), "revoked refresh token reused, revoking the session ", token.Session_id, " of the user ", token.User_id)
+		revoked, err3 := revokeSession(tx, token.Session_id)
+		if err3 != nil {
+			return revoked, "", err3
+		}
+		err4 := tx.Commit()
+		if err4 != nil {
+			return revoked, "", err4
+		}
+		a.Revocations.Revoke(revoked.Id, revoked.Access_expires_in)
+		return revoked, "", invalid
+	}
+	if !token.IsValid() {
+		return session, "", common.NewUnauthorizedError("Expired refresh token!")
+	}
+	session, err5 := getSession(tx, token.Session_id)
+	if err5 != nil {
+		return session, "", err5
+	}
+	if session.Revoked {
+		return session, "", invalid
+	}
+	result, err6 := tx.Exec("update refresh_tokens set Revoked = 1 where Token_hash = ? and Revoked = 0", hash)
+	if err6 != nil {
+		return session, "", err6
+	}
+	rowAffected, err7 := result.RowsAffected()
+	if err7 != nil {
+		return session, "", err7
 	}
 	if rowAffected != 1 {
-		return token, errors.New("row not created")
+		return session, "", invalid
+	}
+	session.LastUsedAt = time.Now()
+	session.Expires_in = session.LastUsedAt.Add(expire_in * time.Hour)
+	session.Access_expires_in = accessExpires
+	_, err8 := tx.Exec("update sessions set LastUsedAt = ?, Expires_in = ?, Access_expires_in = ? where Id = ?", session.LastUsedAt, session.Expires_in, session.Access_expires_in, session.Id)
+	if err8 != nil {
+		return session, "", err8
 	}
-	return token, nil
+	newToken, err9 := insertRefreshToken(tx, session)
+	if err9 != nil {
+		return session, "", err9
+	}
+	return session, newToken, tx.Commit()
 }
 
-func (a *SqlContext) GetToken(tokenvalue string) (Token, error) {
-	log.Trace(log.Here(), "GetToken() : calling method -")
-	sql := "select * from tokens where token = ?"
-	var token Token
-	stmt, err := a.Db.Prepare(sql)
+func insertRefreshToken(db querier, session Session) (string, error) {
+	tokenValue, err := common.Generate_Token()
 	if err != nil {
-		return token, err
+		return "", err
+	}
+	return tokenValue, storeRefreshToken(db, session, tokenValue)
+}
+
+func storeRefreshToken(db querier, session Session, tokenValue string) error {
+	sql := "insert into refresh_tokens (Token_hash, Session_id, User_id, CreatedAt, Expires_in, Revoked) values (?, ?, ?, ?, ?, 0)"
+	result, err1 := db.Exec(sql, HashToken(tokenValue), session.Id, session.User_id, time.Now(), session.Expires_in)
+	if err1 != nil {
+		return err1
+	}
+	rowAffected, err2 := result.RowsAffected()
+	if err2 != nil {
+		return err2
+	}
+	if rowAffected != 1 {
+		return errors.New("row not created")
+	}
+	return nil
+}
+
+// The opaque tokens of the former tokens table were stored verbatim. Each valid one becomes a session
+// of which refresh token is the former token, so its holder gets an access token from /o/auth/refresh.
+// Only the hashes are kept, the former table is dropped. Run by the sessions migration, in its transaction.
+func migrateLegacyTokens(tx *sql.Tx) (int, error) {
+	log.Trace(log.Here(), "migrateLegacyTokens() : calling method -")
+	var name string
+	err1 := tx.QueryRow("select name from sqlite_master where type = 'table' and name = 'tokens'").Scan(&name)
+	if err1 == sql.ErrNoRows {
+		return 0, nil
 	}
-	defer stmt.Close()
-	err1 := stmt.QueryRow(tokenvalue).Scan(&token.Token, &token.Expires_in, &token.User_id)
 	if err1 != nil {
-		return token, err1
+		return 0, err1
+	}
+	now := time.Now()
+	rows, err2 := tx.Query("select Token, Expires_in, User_id from tokens where Expires_in > ?", now)
+	if err2 != nil {
+		return 0, err2
+	}
+	type legacyToken struct {
+		value   string
+		session Session
+	}
+	var tokens []legacyToken
+	for rows.Next() {
+		token := legacyToken{session: Session{Client: LEGACY_CLIENT, CreatedAt: now, LastUsedAt: now, Access_expires_in: now}}
+		err3 := rows.Scan(&token.value, &token.session.Expires_in, &token.session.User_id)
+		if err3 != nil {
+			rows.Close()
+			return 0, err3
+		}
+		tokens = append(tokens, token)
 	}
-	return token, nil
+	rows.Close()
+	for _, token := range tokens {
+		session := token.session
+		session.Id = common.Generate_uuid()
+		_, err4 := tx.Exec("insert into sessions (Id, User_id, Client, Address, CreatedAt, LastUsedAt, Expires_in, Access_expires_in, Revoked) values (?, ?, ?, ?, ?, ?, ?, ?, 0)", session.Id, session.User_id, session.Client, session.Address, session.CreatedAt, session.LastUsedAt, session.Expires_in, session.Access_expires_in)
+		if err4 != nil {
+			return 0, err4
+		}
+		err5 := storeRefreshToken(tx, session, token.value)
+		if err5 != nil {
+			return 0, err5
+		}
+	}
+	_, err6 := tx.Exec("DROP TABLE tokens")
+	if err6 != nil {
+		return 0, err6
+	}
+	return len(tokens), nil
+}
+
+// SHA-256 of a token, the refresh tokens are looked up by hash
+func HashToken(tokenValue string) string {
+	sum := sha256.Sum256([]byte(tokenValue))
+	return hex.EncodeToString(sum[:])
 }
 
-func (a *Token) IsValid() bool {
+func (a *RefreshToken) IsValid() bool {
 	log.Trace(log.Here(), "IsValid() : calling method -")
 	log.Trace(log.Here(), "token Expire_in:", a.Expires_in.String(), "  now:", time.Now().String())
-	return a.Expires_in.After(time.Now())
+	return !a.Revoked && a.Expires_in.After(time.Now())
 }
@@ -0,0 +1,27 @@
+/*
+Copyright Pascal Limeux. 2017 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package model
+
+import (
+	"github.com/pascallimeux/ocms2/modules/common"
+	"testing"
+)
+
+func TestGenerateToken(t *testing.T) {
+	token1, err := common.Generate_Token()
+	token2, _ := common.Generate_Token()
+	if err != nil || len(token1) != 43 || token1 == token2 {
+		t.Error("bad tokens: ", token1, " ", token2, " ", err)
+	}
+}
@@ -48,7 +48,7 @@ func (a *SqlContext) CreateUser(username, lastname, firstname, email, password s
 	defer stmt.Close()
 	result, err2 := stmt.Exec(user.Id, user.Username, user.Lastname, user.Firstname, user.Email, user.Password, user.CreatedAt, user.UpdatedAt, user.Activated, user.Role_id)
 	if err2 != nil {
-		return user, err2
+		return user, common.ConflictOnDuplicate(err2, "user "+username+" already exists")
 	}
 	rowAffected, err3 := result.RowsAffected()
 	if err3 != nil {
@@ -164,6 +164,13 @@ func (a *SqlContext) PutUser(id, lastname, firstname, email, password string) (U
 	if err2 != nil {
 		return user, err2
 	}
+	// A new password closes the sessions opened with the old one
+	if newuser.Password != nil {
+		err3 := a.RevokeSessions(user.Id)
+		if err3 != nil {
+			return user, err3
+		}
+	}
 	return user, nil
 }
 
@@ -181,5 +188,5 @@ func (a *SqlContext) UnactivateUser(id string) error {
 	if err2 != nil {
 		return err2
 	}
-	return nil
+	return a.RevokeSessions(id)
 }
@@ -39,11 +39,14 @@ func setup() {
 	var err error
 
 	// Init config
-	config := setting.Settings{DataSourceName: "/tmp/auth_test.db", LogFileName: "/tmp/test.log", LogMode: "Trace"}
+	config := setting.Settings{DataSourceName: "/tmp/auth_model_test.db", LogFileName: "/tmp/test.log", LogMode: "Trace"}
 
 	// Init logger
 	logfile = log.Init_log(config.LogFileName, config.LogMode)
 
+	// Start from an empty database
+	os.Remove(config.DataSourceName)
+
 	// Init sqliteDB
 	sqlContext, err = GetSqlContext(config.DataSourceName, true)
 	if err != nil {
@@ -13,8 +13,13 @@ limitations under the License.
 
 package setting
 
+import "time"
+
 const (
 	ADMINLOGIN = "admin"
 	ADMINPWD   = "orangeadmin"
 	ADMINEMAIL = "admin@orange.com"
+
+	DEFAULT_REFRESH_TOKEN_EXPIRY = 720 // in hours
+	DEFAULT_REVOCATION_REFRESH   = 10 * time.Second
 )
@@ -25,19 +25,30 @@ import (
 )
 
 type Settings struct {
-	LogMode        string
-	LogFileName    string
-	DataSourceName string
-	ExpireInToken  time.Duration
-	HttpHostUrl    string
-	ReadTimeout    time.Duration
-	WriteTimeout   time.Duration
+	LogMode              string
+	LogFileName          string
+	DataSourceName       string
+	ExpireInToken        time.Duration // access token lifetime in hours
+	ExpireInRefreshToken time.Duration // refresh token lifetime in hours
+	TokenAlgorithm       string        // HS256, RS256 or ES256
+	TokenSecret          string        // HS256 secret
+	TokenPrivateKey      string        // PEM private key file of RS256 and ES256
+	RevocationRefresh    time.Duration // reload of the revoked sessions, the other instances revoke sessions too
+	MaintenanceInterval  time.Duration // 0 disables the housekeeping
+	LogRetention         time.Duration // 0 keeps the logs whatever their age
+	LogMaxRows           int           // 0 keeps every log
+	LogArchiveDir        string        // archive of the purged logs, none when empty
+	OptimizeInterval     time.Duration // 0 disables VACUUM and ANALYZE
+	HttpHostUrl          string
+	ReadTimeout          time.Duration
+	WriteTimeout         time.Duration
 }
 
 func (s *Settings) ToString() string {
 	st := "Logger          --> file:" + s.LogFileName + " in " + s.LogMode + " mode \n"
 	st = st + "Database        --> name:" + s.DataSourceName + "\n"
-	st = st + "Server          --> url :" + s.HttpHostUrl
+	st = st + "Server          --> url :" + s.HttpHostUrl + "\n"
+	st = st + "Tokens          --> algorithm:" + s.TokenAlgorithm
 	return st
 }
 
@@ -94,6 +105,26 @@ func GetSettings(configPath, configFileName string) (*Settings, error) {
 	configuration.WriteTimeout = viper.GetDuration("server.writeTimeout")
 
 	configuration.ExpireInToken = viper.GetDuration("token.expireInToken")
+	configuration.ExpireInRefreshToken = viper.GetDuration("token.expireInRefreshToken")
+	if configuration.ExpireInRefreshToken == 0 {
+		configuration.ExpireInRefreshToken = DEFAULT_REFRESH_TOKEN_EXPIRY
+	}
+	configuration.TokenAlgorithm = viper.GetString("token.algorithm")
+	configuration.TokenPrivateKey = viper.GetString("token.privateKeyFile")
+	configuration.RevocationRefresh = viper.GetDuration("token.revocationRefresh")
+	if configuration.RevocationRefresh == 0 {
+		configuration.RevocationRefresh = DEFAULT_REVOCATION_REFRESH
+	}
+	configuration.TokenSecret = os.Getenv("AUTHTOKENSECRET")
+	if configuration.TokenSecret == "" {
+		configuration.TokenSecret = viper.GetString("token.secret")
+	}
+
+	configuration.MaintenanceInterval = viper.GetDuration("maintenance.interval")
+	configuration.LogRetention = viper.GetDuration("maintenance.logRetention")
+	configuration.LogMaxRows = viper.GetInt("maintenance.logMaxRows")
+	configuration.LogArchiveDir = viper.GetString("maintenance.logArchiveDir")
+	configuration.OptimizeInterval = viper.GetDuration("maintenance.optimizeInterval")
 
 	fmt.Println("Authentication module configuration: \n" + configuration.ToString())
 	return &configuration, nil
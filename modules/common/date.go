@@ -15,6 +15,7 @@ package common
 
 import (
 	"github.com/pascallimeux/ocms2/modules/log"
+	"strings"
 	"time"
 )
 
@@ -29,6 +30,35 @@ func DateParse(datestr string) (time.Time, error) {
 	return date, err
 }
 
+// Parse a received date (2006-01-02) with DateParse, or a RFC 3339 timestamp with its time zone when it carries a time
+func DateTimeParse(datestr string) (time.Time, error) {
+	log.Trace(log.Here(), "DateTimeParse() : calling method for: ", datestr)
+	if !strings.Contains(datestr, "T") {
+		return DateParse(datestr)
+	}
+	return time.Parse(time.RFC3339, datestr)
+}
+
+// Parse a date or a timestamp read back from a consent, they were validated when received so nothing is logged
+func DateTimeValue(datestr string) (time.Time, error) {
+	if !strings.Contains(datestr, "T") {
+		return time.Parse(DATEFORMAT, datestr)
+	}
+	return time.Parse(time.RFC3339, datestr)
+}
+
+// First instant after a validity window ending at the given date or timestamp, a date covers the whole day
+func WindowEnd(datestr string) (time.Time, error) {
+	date, err := DateTimeValue(datestr)
+	if err != nil {
+		return date, err
+	}
+	if !strings.Contains(datestr, "T") {
+		return date.AddDate(0, 0, 1), nil
+	}
+	return date.Add(time.Nanosecond), nil
+}
+
 func GetStringDateNow(nbdaysafter time.Duration) string {
 	t := time.Now().Add(nbdaysafter * 24 * time.Hour)
 	return t.Format(DATEFORMAT)
@@ -0,0 +1,118 @@
+/*
+Copyright Pascal Limeux. 2017 All Rights Reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		 http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/mattn/go-sqlite3"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Stable error codes sent to the API clients
+const (
+	VALIDATION_ERROR = "VALIDATION_ERROR"
+	NOT_FOUND        = "NOT_FOUND"
+	CONFLICT         = "CONFLICT"
+	UNAUTHORIZED     = "UNAUTHORIZED"
+	FORBIDDEN        = "FORBIDDEN"
+	LEDGER_ERROR     = "LEDGER_ERROR"
+	TIMEOUT          = "TIMEOUT"
+	NOT_IMPLEMENTED  = "NOT_IMPLEMENTED"
+	INTERNAL_ERROR   = "INTERNAL_ERROR"
+)
+
+// Message of the internal errors, their detail is only logged
+const INTERNAL_MESSAGE = "internal error"
+
+// Error carrying the http status and the error code sent to the client
+type HttpError struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *HttpError) Error() string {
+	return e.Message
+}
+
+func NewValidationError(message string) error {
+	return &HttpError{Status: http.StatusBadRequest, Code: VALIDATION_ERROR, Message: message}
+}
+
+func NewNotFoundError(message string) error {
+	return &HttpError{Status: http.StatusNotFound, Code: NOT_FOUND, Message: message}
+}
+
+func NewConflictError(message string) error {
+	return &HttpError{Status: http.StatusConflict, Code: CONFLICT, Message: message}
+}
+
+func NewUnauthorizedError(message string) error {
+	return &HttpError{Status: http.StatusUnauthorized, Code: UNAUTHORIZED, Message: message}
+}
+
+func NewForbiddenError(message string) error {
+	return &HttpError{Status: http.StatusForbidden, Code: FORBIDDEN, Message: message}
+}
+
+func NewLedgerError(message string) error {
+	return &HttpError{Status: http.StatusBadGateway, Code: LEDGER_ERROR, Message: message}
+}
+
+func NewNotImplementedError(message string) error {
+	return &HttpError{Status: http.StatusNotImplemented, Code: NOT_IMPLEMENTED, Message: message}
+}
+
+func NewTimeoutError(message string) error {
+	return &HttpError{Status: http.StatusGatewayTimeout, Code: TIMEOUT, Message: message}
+}
+
+// Conflict error when err violates a unique or primary key constraint of the database, err otherwise
+func ConflictOnDuplicate(err error, message string) error {
+	var sqliteError sqlite3.Error
+	if errors.As(err, &sqliteError) && (sqliteError.ExtendedCode == sqlite3.ErrConstraintUnique || sqliteError.ExtendedCode == sqlite3.ErrConstraintPrimaryKey) {
+		return NewConflictError(message)
+	}
+	return err
+}
+
+// Classify any error, errors not created by this package are guessed from their type.
+// An unknown error is internal, its message is not sent to the client.
+func ToHttpError(err error) *HttpError {
+	var httpError *HttpError
+	if errors.As(err, &httpError) {
+		return httpError
+	}
+	var syntaxError *json.SyntaxError
+	var typeError *json.UnmarshalTypeError
+	var parseError *time.ParseError
+	var numError *strconv.NumError
+	var netError net.Error
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return &HttpError{Status: http.StatusNotFound, Code: NOT_FOUND, Message: "resource not found"}
+	case errors.Is(err, io.EOF), errors.As(err, &syntaxError), errors.As(err, &typeError), errors.As(err, &parseError), errors.As(err, &numError):
+		return &HttpError{Status: http.StatusBadRequest, Code: VALIDATION_ERROR, Message: err.Error()}
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netError) && netError.Timeout():
+		return &HttpError{Status: http.StatusGatewayTimeout, Code: TIMEOUT, Message: err.Error()}
+	}
+	return &HttpError{Status: http.StatusInternalServerError, Code: INTERNAL_ERROR, Message: INTERNAL_MESSAGE}
+}
@@ -21,16 +21,37 @@ import (
 	"strings"
 )
 
+const REQUESTIDHEADER = "X-Request-Id"
+
+type ErrorBody struct {
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	Request_id string `json:"request_id"`
+}
+
 // Build and send http error
 func SendError(from string, w http.ResponseWriter, err error) {
 	log.Trace(log.Here(), "sendError() : calling method -")
-	libelle := err.Error()
-	libelle = strings.Replace(libelle, "\"", "'", -1)
-	log.Error(from, "sendError: ", libelle)
-	message := "{\"content\":\"" + libelle + "\"} "
+	httpError := ToHttpError(err)
+	requestID := w.Header().Get(REQUESTIDHEADER)
+	log.Error(from, "sendError: ", httpError.Code, " ", err.Error(), " request: ", requestID)
+	body, _ := json.Marshal(ErrorBody{Code: httpError.Code, Message: httpError.Message, Request_id: requestID})
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusBadRequest)
-	w.Write([]byte(message))
+	w.WriteHeader(httpError.Status)
+	w.Write(body)
+}
+
+// Tag each request and its response with a request id, reusing the caller's one if any
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(REQUESTIDHEADER)
+		if requestID == "" {
+			requestID = Generate_uuid()
+			r.Header.Set(REQUESTIDHEADER, requestID)
+		}
+		w.Header().Set(REQUESTIDHEADER, requestID)
+		next.ServeHTTP(w, r)
+	})
 }
 
 func BuildHttp201Response(w http.ResponseWriter, data interface{}) {
@@ -63,11 +84,7 @@ func BuildHttp204Response(w http.ResponseWriter) {
 
 func BuildHttp401Response(w http.ResponseWriter) {
 	log.Trace(log.Here(), "buildHttp401Response() : calling method -")
-	error := "Not authorized request"
-	message := "{\"content\":\"" + error + "\"} "
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusUnauthorized)
-	w.Write([]byte(message))
+	SendError(log.Here(), w, NewUnauthorizedError("Not authorized request"))
 }
 
 func BuildRequest(method, uri, data string) (*http.Request, error) {
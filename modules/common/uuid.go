@@ -16,11 +16,14 @@ package common
 import (
 	"bytes"
 	"crypto/rand"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"github.com/pascallimeux/ocms2/modules/log"
 )
 
+const TOKEN_SIZE = 32 // 256 bits
+
 func Generate_uuid() string {
 	log.Trace(log.Here(), "Generate_uuid() : calling method -")
 	b := make([]byte, 16)
@@ -30,15 +33,15 @@ func Generate_uuid() string {
 	return uuid
 }
 
-func Generate_Token() string {
+// Random token of TOKEN_SIZE bytes from crypto/rand, base64url encoded without padding
+func Generate_Token() (string, error) {
 	log.Trace(log.Here(), "Generate_Token() : calling method -")
-	var dictionary = "01234567890abcde"
-	var bytes = make([]byte, 40)
-	rand.Read(bytes)
-	for k, v := range bytes {
-		bytes[k] = dictionary[v%byte(len(dictionary))]
+	bytes := make([]byte, TOKEN_SIZE)
+	_, err := rand.Read(bytes)
+	if err != nil {
+		return "", err
 	}
-	return string(bytes)
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
 }
 
 func object2Bytes(obj interface{}) (*bytes.Buffer, error) {
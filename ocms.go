@@ -14,13 +14,18 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"github.com/pascallimeux/ocms2/controllers"
 	"github.com/pascallimeux/ocms2/hyperledger"
+	"github.com/pascallimeux/ocms2/model"
 	auth "github.com/pascallimeux/ocms2/modules/auth/initialize"
+	authmodel "github.com/pascallimeux/ocms2/modules/auth/model"
 	"github.com/pascallimeux/ocms2/modules/log"
 	"github.com/pascallimeux/ocms2/setting"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
@@ -43,24 +48,82 @@ func main() {
 	f := log.Init_log(configuration.LogFileName, configuration.LogMode)
 	defer f.Close()
 
+	// Migrate the auth and OCMS database schemas and exit
+	if len(args) >= 1 && args[0] == "migrate" {
+		err1 := auth.MigrateSchemas(args[1:], nil, authmodel.AuthSchema, model.Schema)
+		if err1 != nil {
+			panic(err1.Error())
+		}
+		return
+	}
+
+	// Closed on shutdown to stop the background jobs
+	stop := make(chan struct{})
+
 	// Init Auth mode
-	router, authContext, err2 := auth.Init(initDB, nil)
+	router, authContext, err2 := auth.Init(initDB, nil, stop)
 	if err2 != nil {
 		panic(err2.Error())
 	}
 	defer authContext.SqlContext.Db.Close()
 
-	// Init Hyperledger helpers
-	HP_helper := hyperledger.HP_Helper{HttpHyperledger: configuration.HttpHyperledger, HLTimeout: configuration.HLTimeout}
-	Consent_Helper := hyperledger.Consent_Helper{HP_helper: HP_helper, ChainCodePath: configuration.ChainCodePath, ChainCodeName: configuration.ChainCodeName, EnrollID: configuration.EnrollID, EnrollSecret: configuration.EnrollSecret}
+	// Migrate the OCMS tables
+	sqlContext := model.SqlContext{Db: authContext.SqlContext.Db}
+	err3 := sqlContext.MigrateUp()
+	if err3 != nil {
+		panic(err3.Error())
+	}
+
+	// Init ledger backend
+	var consentLedger hyperledger.ConsentLedger
+	switch configuration.Ledger {
+	case hyperledger.MEMORY_LEDGER:
+		consentLedger = hyperledger.NewMemoryLedger(configuration.ChainCodeName)
+	case hyperledger.HYPERLEDGER_LEDGER:
+		HP_helper := hyperledger.HP_Helper{HttpHyperledger: configuration.HttpHyperledger, HLTimeout: configuration.HLTimeout}
+		consentLedger = &hyperledger.Consent_Helper{HP_helper: HP_helper, ChainCodePath: configuration.ChainCodePath, ChainCodeName: configuration.ChainCodeName, EnrollID: configuration.EnrollID, EnrollSecret: configuration.EnrollSecret}
+	default:
+		panic("unknown ledger backend: " + configuration.Ledger)
+	}
+	log.Info(log.Here(), "Ledger backend: ", configuration.Ledger)
+
+	// Serve the reads from the local consent index
+	if configuration.IndexStaleness > 0 {
+		consentIndex, err4 := hyperledger.NewIndexLedger(consentLedger, authContext.SqlContext.Db, configuration.IndexStaleness, configuration.TransactionTimeout)
+		if err4 != nil {
+			panic(err4.Error())
+		}
+		if configuration.ReconcileInterval > 0 {
+			go consentIndex.Reconciliation(configuration.ReconcileInterval, stop)
+		}
+		consentLedger = consentIndex
+	}
 
 	// Init application context
-	appContext := controllers.AppContext{Consent_helper: Consent_Helper, Configuration: configuration, AuthContext: authContext}
+	appContext := controllers.AppContext{Consent_helper: consentLedger, Configuration: configuration, AuthContext: authContext, SqlContext: sqlContext}
+
+	// Log the consent events, stream them and deliver them to the webhooks
+	appContext.Events = controllers.NewEventBroker(appContext.SqlContext)
+	if configuration.WebhookWorkers > 0 {
+		appContext.Webhooks = controllers.NewWebhookDispatcher(appContext.SqlContext, configuration.WebhookWorkers, configuration.WebhookAttempts, configuration.WebhookBackoff, configuration.WebhookTimeout, configuration.WebhookPrivate)
+	}
+	if configuration.ExpirySweep > 0 {
+		go appContext.ExpirySweeper(configuration.ExpirySweep, stop)
+	}
+	if configuration.EventRetention > 0 {
+		go appContext.EventPurger(configuration.EventRetention, stop)
+	}
 
 	// Init permissions for application
-	err3 := appContext.InitPermissions()
-	if err3 != nil {
-		panic(err3.Error())
+	err5 := appContext.InitPermissions()
+	if err5 != nil {
+		panic(err5.Error())
+	}
+
+	// Register the default application
+	err6 := appContext.InitApplication()
+	if err6 != nil {
+		panic(err6.Error())
 	}
 
 	// Init routes for application
@@ -74,5 +137,25 @@ func main() {
 		ReadTimeout:  configuration.ReadTimeout * time.Nanosecond,
 		WriteTimeout: configuration.WriteTimeout * time.Nanosecond,
 	}
-	log.Fatal(log.Here(), s.ListenAndServe().Error())
+	done := make(chan struct{})
+	go shutdown(s, stop, done)
+	err7 := s.ListenAndServe()
+	if err7 != http.ErrServerClosed {
+		log.Fatal(log.Here(), err7.Error())
+	}
+	<-done
+	if authContext.Maintenance != nil {
+		<-authContext.Maintenance.Stopped()
+	}
+}
+
+// Stop the background jobs and the http server on SIGINT or SIGTERM, done is closed once the requests are served
+func shutdown(s *http.Server, stop, done chan struct{}) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
+	log.Info(log.Here(), "Shutting down")
+	close(stop)
+	s.Shutdown(context.Background())
+	close(done)
 }
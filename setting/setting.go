@@ -35,17 +35,30 @@ type Settings struct {
 	HLTimeout          time.Duration
 	DeployTimeout      time.Duration
 	TransactionTimeout time.Duration
+	Ledger             string
 	HttpHyperledger    string
 	ChainCodePath      string
 	ChainCodeName      string
 	ApplicationID      string
 	EnrollID           string
 	EnrollSecret       string
+	IndexStaleness     time.Duration
+	ReconcileInterval  time.Duration
+	BatchWorkers       int
+	WebhookWorkers     int
+	WebhookAttempts    int
+	WebhookBackoff     time.Duration
+	WebhookTimeout     time.Duration
+	WebhookPrivate     bool
+	ExpirySweep        time.Duration
+	EventRetention     time.Duration
 }
 
 func (s *Settings) ToString() string {
 	st := "Logger          --> file:" + s.LogFileName + " in " + s.LogMode + " mode \n"
 	st = st + "Server          --> url :" + s.HttpHostUrl + "\n"
+	st = st + "Ledger          --> backend :" + s.Ledger + "\n"
+	st = st + "Consent index   --> staleness :" + s.IndexStaleness.String() + "\n"
 	st = st + "Hyperledger srv --> url :" + s.HttpHyperledger
 	return st
 }
@@ -100,6 +113,29 @@ func GetSettings(configPath, configFileName string) (Settings, error) {
 		configuration.HandlerTimeout = viper.GetDuration("server.handlerTimeout")
 		configuration.HLTimeout = viper.GetDuration("server.hLTimeout")
 		configuration.DeployTimeout = viper.GetDuration("server.deployTimeout")
+		configuration.TransactionTimeout = viper.GetDuration("server.transactionTimeout")
+		if configuration.TransactionTimeout == 0 {
+			configuration.TransactionTimeout = 5 * time.Second
+		}
+
+		configuration.Ledger = viper.GetString("ledger.backend")
+		if configuration.Ledger == "" {
+			configuration.Ledger = "hyperledger"
+		}
+
+		configuration.IndexStaleness = viper.GetDuration("index.staleness")
+		configuration.ReconcileInterval = viper.GetDuration("index.reconcileInterval")
+
+		configuration.BatchWorkers = viper.GetInt("batch.workers")
+
+		configuration.WebhookWorkers = viper.GetInt("webhook.workers")
+		configuration.WebhookAttempts = viper.GetInt("webhook.maxAttempts")
+		configuration.WebhookBackoff = viper.GetDuration("webhook.backoff")
+		configuration.WebhookTimeout = viper.GetDuration("webhook.timeout")
+		configuration.WebhookPrivate = viper.GetBool("webhook.allowPrivate")
+
+		configuration.ExpirySweep = viper.GetDuration("events.expirySweep")
+		configuration.EventRetention = viper.GetDuration("events.retention")
 
 		configuration.HttpHyperledger = viper.GetString("hyperledger.httpHyperledger")
 		configuration.ChainCodePath = viper.GetString("hyperledger.chainCodePath")